@@ -15,9 +15,8 @@ import (
 // DB gorm connector
 var DB *gorm.DB
 
-// ConnectDB connect to db with retry logic
-func ConnectDB() {
-	var err error
+// DSN builds the PostgreSQL connection string from the environment
+func DSN() string {
 	p := config.Config("DB_PORT")
 	port, err := strconv.ParseUint(p, 10, 32)
 
@@ -25,7 +24,13 @@ func ConnectDB() {
 		log.Println("Error parsing port")
 	}
 
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", config.Config("DB_HOST"), port, config.Config("DB_USER"), config.Config("DB_PASSWORD"), config.Config("DB_NAME"))
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", config.Config("DB_HOST"), port, config.Config("DB_USER"), config.Config("DB_PASSWORD"), config.Config("DB_NAME"))
+}
+
+// ConnectDB connect to db with retry logic
+func ConnectDB() {
+	var err error
+	dsn := DSN()
 
 	// Retry logic
 	for i := 0; i < 5; i++ {
@@ -33,7 +38,14 @@ func ConnectDB() {
 		if err == nil {
 			fmt.Println("Connection Opened to Database")
 			// Migrate the schema
-			DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.File{}, &models.Folder{}, &models.Knowledge{}, &models.Model{}, &models.Prompt{}, &models.Tool{})
+			DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.File{}, &models.Folder{}, &models.Knowledge{}, &models.Model{}, &models.Prompt{}, &models.Tool{}, &models.ChatShare{}, &models.ChatParticipant{}, &models.KnowledgeFile{}, &models.KnowledgeChunk{}, &models.KnowledgeWebSource{}, &models.Upload{}, &models.QuarantinedFile{}, &models.FileArtifact{}, &models.Group{}, &models.GroupMember{})
+			// Full-text index for hybrid knowledge retrieval
+			if err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_knowledge_chunks_fts ON knowledge_chunks USING gin (to_tsvector('simple', content))").Error; err != nil {
+				log.Printf("Failed to create the knowledge chunk full-text index: %v", err)
+			}
+			if err := migrateKnowledgeFileIDs(); err != nil {
+				log.Printf("Failed to migrate knowledge base file lists: %v", err)
+			}
 			fmt.Println("Database Migrated")
 			return
 		}
@@ -43,3 +55,26 @@ func ConnectDB() {
 
 	panic("failed to connect database after multiple retries")
 }
+
+// migrateKnowledgeFileIDs moves the JSONB file_ids arrays that used to hold
+// knowledge base membership into knowledge_files, then drops the column.
+// Files that no longer exist or belong to another user are left out, and
+// files without an ingestion status are queued for indexing.
+func migrateKnowledgeFileIDs() error {
+	if !DB.Migrator().HasColumn("knowledges", "file_ids") {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT INTO knowledge_files (knowledge_id, file_id, status, created_at, updated_at)
+			SELECT DISTINCT k.id, f.id, ?, now(), now()
+			FROM knowledges k
+			CROSS JOIN LATERAL jsonb_array_elements_text(k.file_ids) AS e(file_id)
+			JOIN files f ON f.id = e.file_id::bigint AND f.user_id = k.user_id AND f.deleted_at IS NULL
+			WHERE k.deleted_at IS NULL AND jsonb_typeof(k.file_ids) = 'array'
+			ON CONFLICT (knowledge_id, file_id) DO NOTHING`, models.IngestionPending).Error
+		if err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn("knowledges", "file_ids")
+	})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -29,6 +30,24 @@ func GetJWTKey() []byte {
 	return jwtKey
 }
 
+// UserFromToken validates a JWT and returns the user it was issued to
+func UserFromToken(tokenString string) (models.User, error) {
+	var user models.User
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return GetJWTKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return user, errors.New("invalid token")
+	}
+
+	if result := database.DB.Where("email = ?", claims.Email).First(&user); result.Error != nil {
+		return user, errors.New("user not found")
+	}
+	return user, nil
+}
+
 func Register(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
@@ -3,7 +3,6 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +10,7 @@ import (
 
 	"backend/database"
 	"backend/models"
+	"backend/services"
 	"backend/utils"
 
 	"github.com/go-chi/chi/v5"
@@ -63,8 +63,11 @@ func (h *Handler) GetChats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Include chats the user participates in as well as the ones they own
+	participating := database.DB.Model(&models.ChatParticipant{}).Select("chat_id").Where("user_id = ?", userID)
+
 	var chats []models.Chat
-	if result := database.DB.Where("user_id = ?", userID).Find(&chats); result.Error != nil {
+	if result := database.DB.Where("user_id = ? OR id IN (?)", userID, participating).Find(&chats); result.Error != nil {
 		http.Error(w, "Failed to retrieve chats", http.StatusInternalServerError)
 		return
 	}
@@ -86,8 +89,7 @@ func (h *Handler) GetChatMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var chat models.Chat
-	if result := database.DB.Where("id = ? AND user_id = ?", chatID, userID).First(&chat); result.Error != nil {
+	if _, _, err := ChatRoleForUser(uint(chatID), userID); err != nil {
 		http.Error(w, "Chat not found or unauthorized", http.StatusNotFound)
 		return
 	}
@@ -115,19 +117,51 @@ func (h *Handler) CreateChatMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var chat models.Chat
-	if result := database.DB.Where("id = ? AND user_id = ?", chatID, userID).First(&chat); result.Error != nil {
+	_, role, err := ChatRoleForUser(uint(chatID), userID)
+	if err != nil {
 		http.Error(w, "Chat not found or unauthorized", http.StatusNotFound)
 		return
 	}
+	if !CanPostToChat(role) {
+		http.Error(w, "Viewers cannot post messages", http.StatusForbidden)
+		return
+	}
 
-	var message models.Message
-	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+	var form models.MessageForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	message.ChatID = uint(chatID)
+	message := models.Message{
+		ChatID:      uint(chatID),
+		ParentID:    form.ParentID,
+		UserID:      &userID,
+		Role:        form.Role,
+		Content:     form.Content,
+		Attachments: form.Attachments,
+	}
+
+	// A message replies to the message it names, which must be in this
+	// chat, or else continues from the latest message
+	if message.ParentID != nil {
+		var parent models.Message
+		if result := database.DB.Where("id = ? AND chat_id = ?", *message.ParentID, chatID).First(&parent); result.Error != nil {
+			http.Error(w, "Parent message not found in this chat", http.StatusBadRequest)
+			return
+		}
+	} else {
+		message.ParentID = latestChatMessageID(uint(chatID))
+	}
+
+	if len(message.Attachments) > 0 {
+		attachments, err := services.ResolveAttachments(userID, message.Attachments)
+		if err != nil {
+			respondAttachmentError(w, err)
+			return
+		}
+		message.Attachments = attachments
+	}
 
 	if result := database.DB.Create(&message); result.Error != nil {
 		http.Error(w, "Failed to create message", http.StatusInternalServerError)
@@ -137,7 +171,7 @@ func (h *Handler) CreateChatMessage(w http.ResponseWriter, r *http.Request) {
 	utils.RespondWithJSON(w, http.StatusCreated, message)
 
 	// Broadcast the new message via Socket.IO
-	h.SocketIOServer.BroadcastToRoom(fmt.Sprintf("chat:%d", chatID), "message", message)
+	h.SocketIOServer.BroadcastToRoom(services.ChatRoom(uint(chatID)), "message", message)
 
 	// After saving the user's message, call the LLM to get a response
 	var allMessages []models.Message
@@ -180,3 +214,13 @@ func (h *Handler) CreateChatMessage(w http.ResponseWriter, r *http.Request) {
 
 	// The LLM handler will save the message and broadcast it via Socket.IO
 }
+
+// latestChatMessageID returns the ID of the most recent message of a chat, or
+// nil when the chat has no messages yet
+func latestChatMessageID(chatID uint) *uint {
+	var latest models.Message
+	if result := database.DB.Select("id").Where("chat_id = ?", chatID).Order("created_at desc, id desc").First(&latest); result.Error != nil {
+		return nil
+	}
+	return &latest.ID
+}
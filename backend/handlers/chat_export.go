@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/database"
+	"backend/models"
+	"backend/services"
+	"backend/utils"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// maxImportSize caps the size of an uploaded chat import document
+const maxImportSize = 100 << 20 // 100 MB
+
+// ExportChat exports a single chat with all of its messages and branches.
+// The format query parameter selects json (default), markdown or zip.
+func (h *Handler) ExportChat(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	chatIDStr := chi.URLParam(r, "id")
+	chatID, err := strconv.ParseUint(chatIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	var chat models.Chat
+	if result := database.DB.Where("id = ? AND user_id = ?", chatID, userID).First(&chat); result.Error != nil {
+		http.Error(w, "Chat not found or unauthorized", http.StatusNotFound)
+		return
+	}
+
+	var messages []models.Message
+	if result := database.DB.Where("chat_id = ?", chatID).Order("created_at asc, id asc").Find(&messages); result.Error != nil {
+		http.Error(w, "Failed to retrieve messages", http.StatusInternalServerError)
+		return
+	}
+
+	exported := services.ExportChat(chat, messages)
+	writeChatExport(w, r.URL.Query().Get("format"), services.ChatExportFilename(exported), services.NewChatExport([]models.ChatExportChat{exported}))
+}
+
+// ExportChats exports every chat of the current user
+func (h *Handler) ExportChats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var chats []models.Chat
+	if result := database.DB.Where("user_id = ?", userID).Order("created_at asc").Find(&chats); result.Error != nil {
+		http.Error(w, "Failed to retrieve chats", http.StatusInternalServerError)
+		return
+	}
+
+	chatIDs := make([]uint, 0, len(chats))
+	for _, c := range chats {
+		chatIDs = append(chatIDs, c.ID)
+	}
+
+	// Load all messages in one query and group them per chat
+	messagesByChat := make(map[uint][]models.Message, len(chats))
+	if len(chatIDs) > 0 {
+		var messages []models.Message
+		if result := database.DB.Where("chat_id IN ?", chatIDs).Order("created_at asc, id asc").Find(&messages); result.Error != nil {
+			http.Error(w, "Failed to retrieve messages", http.StatusInternalServerError)
+			return
+		}
+		for _, m := range messages {
+			messagesByChat[m.ChatID] = append(messagesByChat[m.ChatID], m)
+		}
+	}
+
+	exported := make([]models.ChatExportChat, 0, len(chats))
+	for _, c := range chats {
+		exported = append(exported, services.ExportChat(c, messagesByChat[c.ID]))
+	}
+
+	writeChatExport(w, r.URL.Query().Get("format"), "chats", services.NewChatExport(exported))
+}
+
+// ImportChats imports chats from a webui-go export (JSON or ZIP bundle), a
+// ChatGPT conversations.json or an Open WebUI export. The document can be sent
+// as the request body or as the "file" field of a multipart form. With
+// dry_run=true the document is only validated and a report is returned.
+func (h *Handler) ImportChats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := readImportDocument(w, r)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	chats, format, err := services.ParseChatImport(data, r.URL.Query().Get("format"))
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	valid, errs, warnings := services.ValidateChatImport(chats)
+	report := models.ChatImportReport{
+		Format:   format,
+		DryRun:   r.URL.Query().Get("dry_run") == "true",
+		Skipped:  len(chats) - len(valid),
+		Errors:   errs,
+		Warnings: warnings,
+	}
+	for _, c := range valid {
+		report.Chats++
+		report.Messages += len(c.Messages)
+	}
+
+	if report.DryRun || len(valid) == 0 {
+		status := http.StatusOK
+		if !report.DryRun {
+			status = http.StatusUnprocessableEntity
+		}
+		utils.RespondWithJSON(w, status, report)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, c := range valid {
+			chatID, err := importChat(tx, userID, c)
+			if err != nil {
+				return err
+			}
+			report.ChatIDs = append(report.ChatIDs, chatID)
+		}
+		return nil
+	})
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to import chats"})
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, report)
+}
+
+// importChat stores one validated chat. Messages arrive parent-first, so each
+// parent's new ID is known by the time its replies are created.
+func importChat(tx *gorm.DB, userID uint, c models.ChatExportChat) (uint, error) {
+	chat := models.Chat{
+		UserID:    userID,
+		Title:     c.Title,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+	if result := tx.Create(&chat); result.Error != nil {
+		return 0, result.Error
+	}
+
+	// Only messages of this chat are in newIDs, so a parent_id can never
+	// point into another chat; one that is not known leaves a root message
+	newIDs := make(map[string]uint, len(c.Messages))
+	for _, m := range c.Messages {
+		message := models.Message{
+			ChatID:    chat.ID,
+			Role:      m.Role,
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt,
+		}
+		if parentID, ok := newIDs[m.ParentID]; ok {
+			message.ParentID = &parentID
+		}
+		if result := tx.Create(&message); result.Error != nil {
+			return 0, result.Error
+		}
+		newIDs[m.ID] = message.ID
+	}
+
+	return chat.ID, nil
+}
+
+func readImportDocument(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var data []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			return nil, fmt.Errorf("failed to parse multipart form")
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file from form")
+		}
+		defer file.Close()
+		if data, err = io.ReadAll(file); err != nil {
+			return nil, fmt.Errorf("failed to read import file")
+		}
+	} else {
+		var err error
+		if data, err = io.ReadAll(r.Body); err != nil {
+			return nil, fmt.Errorf("failed to read request body")
+		}
+	}
+
+	// A ZIP bundle produced by the export endpoints carries its data in chats.json
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ZIP bundle")
+		}
+		for _, f := range zr.File {
+			if f.Name != "chats.json" {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open chats.json in bundle")
+			}
+			defer rc.Close()
+			return io.ReadAll(io.LimitReader(rc, maxImportSize))
+		}
+		return nil, fmt.Errorf("ZIP bundle does not contain chats.json")
+	}
+
+	return data, nil
+}
+
+func writeChatExport(w http.ResponseWriter, format string, filename string, export models.ChatExport) {
+	switch format {
+	case "", "json":
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(export); err != nil {
+			log.Printf("Error writing chat export: %v", err)
+		}
+	case "markdown", "md":
+		documents := make([]string, 0, len(export.Chats))
+		for _, c := range export.Chats {
+			documents = append(documents, services.RenderChatMarkdown(c))
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".md"))
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, strings.Join(documents, "\n---\n\n"))
+	case "zip":
+		var buf bytes.Buffer
+		if err := services.WriteChatExportZip(&buf, export); err != nil {
+			http.Error(w, "Failed to build export bundle", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	default:
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+	}
+}
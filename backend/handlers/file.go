@@ -2,28 +2,26 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"backend/database"
 	"backend/models"
+	"backend/services"
 	"backend/utils"
 
 	"github.com/go-chi/chi/v5"
 )
 
-const uploadDir = "./uploads"
-
-func init() {
-	// Create the upload directory if it doesn't exist
-	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
-		os.Mkdir(uploadDir, 0755)
-	}
-}
+// DefaultSignedURLExpiry is how long a signed download URL stays valid when
+// the request does not say
+const DefaultSignedURLExpiry = 15 * time.Minute
 
 func UploadFile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("userID").(uint)
@@ -32,8 +30,11 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse the multipart form data
-	err := r.ParseMultipartForm(10 << 20) // 10 MB limit
+	// Parse the multipart form data, keeping up to 10 MB in memory. Larger
+	// files should use the resumable upload endpoints. The body limit leaves
+	// room for the multipart framing; the file itself is checked later.
+	r.Body = http.MaxBytesReader(w, r.Body, services.UserFilePolicy(userID).MaxSize+1<<20)
+	err := r.ParseMultipartForm(10 << 20)
 	if err != nil {
 		http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
 		return
@@ -65,33 +66,103 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Create a new file on the server
-	dstPath := filepath.Join(uploadDir, handler.Filename)
-	dst, err := os.Create(dstPath)
+	// Blobs are keyed by content, so identical uploads share one copy and
+	// client file names never reach the storage backend. The type is
+	// detected from the content rather than taken from the client.
+	key, size, contentType, err := services.StoreUpload(userID, file, handler.Filename)
+	if err != nil {
+		respondUploadError(w, err)
+		return
+	}
+
+	saveUploadedFile(w, userID, folderID, handler.Filename, contentType, key, size)
+}
+
+// GetStorageUsage returns the storage taken by the user's files, broken down
+// by folder and by MIME type, along with their quota
+func GetStorageUsage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	usage, err := services.UserStorageUsage(userID)
 	if err != nil {
-		http.Error(w, "Failed to create file on server", http.StatusInternalServerError)
+		http.Error(w, "Failed to compute storage usage", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
 
-	// Copy the uploaded file to the destination file
-	if _, err := io.Copy(dst, file); err != nil {
+	utils.RespondWithJSON(w, http.StatusOK, usage)
+}
+
+// respondUploadError reports an upload that could not be stored, with the
+// reason when the upload policy or the malware scan rejected it
+func respondUploadError(w http.ResponseWriter, err error) {
+	var rejected *services.FileRejectedError
+	if !errors.As(err, &rejected) {
 		http.Error(w, "Failed to save file to server", http.StatusInternalServerError)
 		return
 	}
 
-	// Save file metadata to database
-	fileModel := models.File{
-		UserID:   userID,
-		FolderID: folderID,
-		Name:     handler.Filename,
-		Path:     dstPath,
-		MimeType: handler.Header.Get("Content-Type"),
-		Size:     handler.Size,
+	message := "File rejected: " + rejected.Error()
+	if rejected.QuarantineID != 0 {
+		message += fmt.Sprintf(" (quarantined as %d)", rejected.QuarantineID)
+	}
+	switch rejected.Err {
+	case services.ErrUploadTooLarge:
+		http.Error(w, message, http.StatusRequestEntityTooLarge)
+	case services.ErrFileTypeNotAllowed:
+		http.Error(w, message, http.StatusUnsupportedMediaType)
+	case services.ErrFileInfected:
+		http.Error(w, message, http.StatusUnprocessableEntity)
+	case services.ErrQuotaExceeded:
+		http.Error(w, message, http.StatusInsufficientStorage)
+	default:
+		http.Error(w, message, http.StatusServiceUnavailable)
+	}
+}
+
+// GetQuarantinedFiles lists the user's uploads that were rejected and
+// quarantined
+func GetQuarantinedFiles(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var quarantined []models.QuarantinedFile
+	if result := database.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&quarantined); result.Error != nil {
+		http.Error(w, "Failed to retrieve quarantined files", http.StatusInternalServerError)
+		return
 	}
 
-	if result := database.DB.Create(&fileModel); result.Error != nil {
-		os.Remove(dstPath) // Clean up uploaded file if DB save fails
+	utils.RespondWithJSON(w, http.StatusOK, quarantined)
+}
+
+// saveUploadedFile creates the File for content stored under key and writes
+// it as the response. Content the user already uploaded gets a new file with
+// its own name and folder that shares the stored blob.
+func saveUploadedFile(w http.ResponseWriter, userID uint, folderID *uint, name string, contentType string, key string, size int64) {
+	// Save file metadata to database
+	fileModel := models.File{
+		UserID:      userID,
+		FolderID:    folderID,
+		Name:        name,
+		Path:        key,
+		MimeType:    contentType,
+		Size:        size,
+		ContentHash: key,
+	}
+
+	if err := services.CreateBlobFile(&fileModel); err != nil {
+		services.DeleteFileBlob(models.File{Path: key}) // Clean up uploaded file if DB save fails
+		var rejected *services.FileRejectedError
+		if errors.As(err, &rejected) {
+			respondUploadError(w, err)
+			return
+		}
 		http.Error(w, "Failed to save file metadata", http.StatusInternalServerError)
 		return
 	}
@@ -142,7 +213,245 @@ func DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.ServeFile(w, r, file.Path)
+	start, length, partial, err := parseByteRange(r.Header.Get("Range"), file.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.Size))
+		http.Error(w, "Invalid range", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	content, err := services.OpenFileRange(file, start, length)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	contentType := file.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": file.Name}))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, file.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, content)
+}
+
+// parseByteRange parses a single-range Range header against a content size.
+// Without a header, or with several ranges, the whole content is served.
+func parseByteRange(header string, size int64) (start int64, length int64, partial bool, err error) {
+	if header == "" || !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, size, false, nil
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false, fmt.Errorf("invalid range %q", header)
+	}
+
+	if first == "" {
+		// A suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, fmt.Errorf("invalid range %q", header)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, fmt.Errorf("invalid range %q", header)
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, fmt.Errorf("invalid range %q", header)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
+
+// GetFileThumbnail returns a thumbnail of an image file, at most ?size=
+// pixels (256 by default) on its longest side
+func GetFileThumbnail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	size := 256
+	if v := r.URL.Query().Get("size"); v != "" {
+		size, err = strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var file models.File
+	if result := database.DB.Where("id = ? AND user_id = ?", fileID, userID).First(&file); result.Error != nil {
+		http.Error(w, "File not found or unauthorized", http.StatusNotFound)
+		return
+	}
+
+	// Thumbnails only change with the content, so the hash makes a good ETag
+	size = services.ThumbnailSize(size)
+	etag := fmt.Sprintf(`"%s-%d"`, file.ContentHash, size)
+	if file.ContentHash != "" && r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, contentType, err := services.FileThumbnail(file, size)
+	if err == services.ErrNoPreview {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create thumbnail: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if file.ContentHash != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Write(data)
+}
+
+// GetFileText returns the plain text extracted from a document. Long texts
+// can be read in pages with ?offset= and ?limit=, counted in characters.
+func GetFileText(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	offset, limit := 0, -1
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var file models.File
+	if result := database.DB.Where("id = ? AND user_id = ?", fileID, userID).First(&file); result.Error != nil {
+		http.Error(w, "File not found or unauthorized", http.StatusNotFound)
+		return
+	}
+
+	text, err := services.FileText(file)
+	if err == services.ErrNoPreview {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to extract text: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	runes := []rune(text)
+	if offset > len(runes) {
+		offset = len(runes)
+	}
+	end := len(runes)
+	if limit >= 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"file_id": file.ID,
+		"text":    string(runes[offset:end]),
+		"offset":  offset,
+		"length":  end - offset,
+		"total":   len(runes),
+	})
+}
+
+// GetFileURL returns a signed URL to download a file directly from the
+// storage backend, valid for ?expires= seconds
+func GetFileURL(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var file models.File
+	if result := database.DB.Where("id = ? AND user_id = ?", fileID, userID).First(&file); result.Error != nil {
+		http.Error(w, "File not found or unauthorized", http.StatusNotFound)
+		return
+	}
+
+	expiry := DefaultSignedURLExpiry
+	if v := r.URL.Query().Get("expires"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			http.Error(w, "Invalid expires", http.StatusBadRequest)
+			return
+		}
+		expiry = time.Duration(seconds) * time.Second
+	}
+
+	if !services.IsBlobKey(file.Path) {
+		http.Error(w, services.ErrSignedURLUnsupported.Error(), http.StatusNotImplemented)
+		return
+	}
+	signedURL, err := services.FileStorage().SignedURL(file.Path, expiry)
+	if err == services.ErrSignedURLUnsupported {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"url":        signedURL,
+		"expires_at": time.Now().Add(expiry),
+	})
 }
 
 func DeleteFile(w http.ResponseWriter, r *http.Request) {
@@ -165,16 +474,17 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete from database
-	if result := database.DB.Delete(&file); result.Error != nil {
-		http.Error(w, "Failed to delete file from database", http.StatusInternalServerError)
+	// Purge the file's chunks and vectors from the knowledge bases using it
+	if err := services.RemoveFileFromKnowledges(file.ID); err != nil {
+		http.Error(w, "Failed to remove file from knowledge bases", http.StatusInternalServerError)
 		return
 	}
 
-	// Delete from file system
-	if err := os.Remove(file.Path); err != nil {
-		// Log the error but don't return, as the DB record is already gone
-		fmt.Printf("Error deleting file from disk: %v\n", err)
+	// Delete from database. The content is purged from storage by the
+	// retention job once FILE_RETENTION_DAYS have passed.
+	if result := database.DB.Delete(&file); result.Error != nil {
+		http.Error(w, "Failed to delete file from database", http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -269,10 +579,17 @@ func GetFolderContent(w http.ResponseWriter, r *http.Request) {
 	var subfolders []models.Folder
 	database.DB.Where("parent_id = ? AND user_id = ?", folderID, userID).Find(&subfolders)
 
+	breadcrumbs, err := services.FolderBreadcrumbs(folder.ID, userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve folder path", http.StatusInternalServerError)
+		return
+	}
+
 	response := map[string]interface{}{
 		"folder":    folder,
 		"files":     files,
 		"subfolders": subfolders,
+		"breadcrumbs": breadcrumbs,
 	}
 
 	utils.RespondWithJSON(w, http.StatusOK, response)
@@ -298,6 +615,26 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Recursive deletes remove the whole subtree, or only count it on a dry run
+	query := r.URL.Query()
+	if recursive, _ := strconv.ParseBool(query.Get("recursive")); recursive {
+		dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+		count, err := services.DeleteFolderTree(folder.ID, userID, dryRun)
+		if err == services.ErrFolderNotFound {
+			http.Error(w, "Folder not found or unauthorized", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to delete folder", http.StatusInternalServerError)
+			return
+		}
+		if dryRun {
+			utils.RespondWithJSON(w, http.StatusOK, count)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	// Check for contents (files or subfolders)
 	var fileCount int64
 	database.DB.Model(&models.File{}).Where("folder_id = ? AND user_id = ?", folderID, userID).Count(&fileCount)
@@ -305,7 +642,7 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 	database.DB.Model(&models.Folder{}).Where("parent_id = ? AND user_id = ?", folderID, userID).Count(&subfolderCount)
 
 	if fileCount > 0 || subfolderCount > 0 {
-		http.Error(w, "Folder is not empty. Please delete all contents first.", http.StatusBadRequest)
+		http.Error(w, "Folder is not empty. Delete its contents first or pass recursive=true.", http.StatusBadRequest)
 		return
 	}
 
@@ -317,3 +654,197 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RenameFile changes a file's name
+func RenameFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var form models.RenameForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if form.Name = strings.TrimSpace(form.Name); form.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	var file models.File
+	if result := database.DB.Where("id = ? AND user_id = ?", fileID, userID).First(&file); result.Error != nil {
+		http.Error(w, "File not found or unauthorized", http.StatusNotFound)
+		return
+	}
+
+	if result := database.DB.Model(&file).Update("name", form.Name); result.Error != nil {
+		http.Error(w, "Failed to rename file", http.StatusInternalServerError)
+		return
+	}
+	file.Name = form.Name
+
+	utils.RespondWithJSON(w, http.StatusOK, file)
+}
+
+// MoveFile moves a file into another folder, or out of all folders
+func MoveFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var form models.FileMoveForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var file models.File
+	if result := database.DB.Where("id = ? AND user_id = ?", fileID, userID).First(&file); result.Error != nil {
+		http.Error(w, "File not found or unauthorized", http.StatusNotFound)
+		return
+	}
+
+	// Verify folder belongs to user
+	if form.FolderID != nil {
+		var folder models.Folder
+		if result := database.DB.Where("id = ? AND user_id = ?", *form.FolderID, userID).First(&folder); result.Error != nil {
+			http.Error(w, "Folder not found or unauthorized", http.StatusNotFound)
+			return
+		}
+	}
+
+	if result := database.DB.Model(&file).Update("folder_id", form.FolderID); result.Error != nil {
+		http.Error(w, "Failed to move file", http.StatusInternalServerError)
+		return
+	}
+	file.FolderID = form.FolderID
+
+	utils.RespondWithJSON(w, http.StatusOK, file)
+}
+
+// RenameFolder changes a folder's name
+func RenameFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	folderID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+
+	var form models.RenameForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if form.Name = strings.TrimSpace(form.Name); form.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	var folder models.Folder
+	if result := database.DB.Where("id = ? AND user_id = ?", folderID, userID).First(&folder); result.Error != nil {
+		http.Error(w, "Folder not found or unauthorized", http.StatusNotFound)
+		return
+	}
+
+	if result := database.DB.Model(&folder).Update("name", form.Name); result.Error != nil {
+		http.Error(w, "Failed to rename folder", http.StatusInternalServerError)
+		return
+	}
+	folder.Name = form.Name
+
+	utils.RespondWithJSON(w, http.StatusOK, folder)
+}
+
+// MoveFolder moves a folder under another folder, or to the top level.
+// Moving a folder into itself or one of its subfolders is refused.
+func MoveFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	folderID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+
+	var form models.FolderMoveForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	folder, err := services.MoveFolder(uint(folderID), userID, form.ParentID)
+	switch err {
+	case nil:
+	case services.ErrFolderNotFound:
+		http.Error(w, "Folder not found or unauthorized", http.StatusNotFound)
+		return
+	case services.ErrFolderCycle:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Failed to move folder", http.StatusInternalServerError)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, folder)
+}
+
+// GetFolderTree returns the user's whole folder tree, or the subtree of
+// ?root_id=, with files included when ?files=true
+func GetFolderTree(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	var rootID *uint
+	if v := query.Get("root_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid root_id", http.StatusBadRequest)
+			return
+		}
+		uID := uint(id)
+		rootID = &uID
+	}
+	withFiles, _ := strconv.ParseBool(query.Get("files"))
+
+	tree, err := services.FolderTree(userID, rootID, withFiles)
+	if err == services.ErrFolderNotFound {
+		http.Error(w, "Folder not found or unauthorized", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to retrieve folder tree", http.StatusInternalServerError)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, tree)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/database"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// GetGroups lists the user groups with their members (admin only)
+func GetGroups(w http.ResponseWriter, r *http.Request) {
+	var groups []models.Group
+	if result := database.DB.Order("name asc").Find(&groups); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve groups"})
+		return
+	}
+
+	var members []models.GroupMember
+	if result := database.DB.Order("user_id asc").Find(&members); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve groups"})
+		return
+	}
+	userIDs := make(map[uint][]uint)
+	for _, m := range members {
+		userIDs[m.GroupID] = append(userIDs[m.GroupID], m.UserID)
+	}
+
+	responses := make([]models.GroupResponse, len(groups))
+	for i, g := range groups {
+		responses[i] = models.GroupResponse{Group: g, UserIDs: userIDs[g.ID]}
+		if responses[i].UserIDs == nil {
+			responses[i].UserIDs = []uint{}
+		}
+	}
+	utils.RespondWithJSON(w, http.StatusOK, responses)
+}
+
+// CreateGroup creates a user group (admin only)
+func CreateGroup(w http.ResponseWriter, r *http.Request) {
+	form, ok := decodeGroupForm(w, r)
+	if !ok {
+		return
+	}
+
+	if groupNameTaken(form.Name, 0) {
+		utils.RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "A group with this name already exists"})
+		return
+	}
+
+	group := models.Group{Name: form.Name, Description: form.Description}
+	setGroupQuota(&group, form.StorageQuota)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		return setGroupMembers(tx, group.ID, form.UserIDs)
+	})
+	if err != nil {
+		respondGroupError(w, err, "Failed to create group")
+		return
+	}
+
+	respondGroup(w, http.StatusCreated, group)
+}
+
+// UpdateGroup updates a user group. Its members are replaced when user_ids
+// is given. (admin only)
+func UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid group ID"})
+		return
+	}
+
+	var group models.Group
+	if result := database.DB.First(&group, groupID); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Group not found"})
+		return
+	}
+
+	form, ok := decodeGroupForm(w, r)
+	if !ok {
+		return
+	}
+
+	if groupNameTaken(form.Name, group.ID) {
+		utils.RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "A group with this name already exists"})
+		return
+	}
+
+	group.Name = form.Name
+	group.Description = form.Description
+	setGroupQuota(&group, form.StorageQuota)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&group).Error; err != nil {
+			return err
+		}
+		if form.UserIDs == nil {
+			return nil
+		}
+		return setGroupMembers(tx, group.ID, form.UserIDs)
+	})
+	if err != nil {
+		respondGroupError(w, err, "Failed to update group")
+		return
+	}
+
+	respondGroup(w, http.StatusOK, group)
+}
+
+// DeleteGroup deletes a user group. Its members fall back to their role's
+// default quota. (admin only)
+func DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid group ID"})
+		return
+	}
+
+	var deleted int64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", groupID).Delete(&models.GroupMember{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.Group{}, groupID)
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete group"})
+		return
+	}
+	if deleted == 0 {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Group not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errUnknownGroupUser is returned when user_ids names a user that does not
+// exist
+var errUnknownGroupUser = errors.New("user_ids contains an unknown user")
+
+func decodeGroupForm(w http.ResponseWriter, r *http.Request) (models.GroupForm, bool) {
+	var form models.GroupForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return form, false
+	}
+	form.Name = strings.TrimSpace(form.Name)
+	if form.Name == "" {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+		return form, false
+	}
+	return form, true
+}
+
+// groupNameTaken reports whether a group other than exceptID has name
+func groupNameTaken(name string, exceptID uint) bool {
+	var count int64
+	database.DB.Model(&models.Group{}).Where("name = ? AND id <> ?", name, exceptID).Count(&count)
+	return count > 0
+}
+
+// setGroupQuota sets a group's storage quota from a form. A negative quota
+// clears it, so the group no longer sets one.
+func setGroupQuota(group *models.Group, quota *int64) {
+	if quota != nil && *quota < 0 {
+		quota = nil
+	}
+	group.StorageQuota = quota
+}
+
+// setGroupMembers replaces the members of a group with userIDs
+func setGroupMembers(tx *gorm.DB, groupID uint, userIDs []uint) error {
+	unique := make(map[uint]bool)
+	var members []models.GroupMember
+	for _, id := range userIDs {
+		if !unique[id] {
+			unique[id] = true
+			members = append(members, models.GroupMember{GroupID: groupID, UserID: id})
+		}
+	}
+	if len(members) > 0 {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("id IN ?", userIDs).Count(&count).Error; err != nil {
+			return err
+		}
+		if count != int64(len(members)) {
+			return errUnknownGroupUser
+		}
+	}
+
+	if err := tx.Where("group_id = ?", groupID).Delete(&models.GroupMember{}).Error; err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return tx.Create(&members).Error
+}
+
+func respondGroupError(w http.ResponseWriter, err error, message string) {
+	if err == errUnknownGroupUser {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": message})
+}
+
+func respondGroup(w http.ResponseWriter, code int, group models.Group) {
+	var userIDs []uint
+	if result := database.DB.Model(&models.GroupMember{}).Where("group_id = ?", group.ID).
+		Order("user_id asc").Pluck("user_id", &userIDs); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve group members"})
+		return
+	}
+	if userIDs == nil {
+		userIDs = []uint{}
+	}
+	utils.RespondWithJSON(w, code, models.GroupResponse{Group: group, UserIDs: userIDs})
+}
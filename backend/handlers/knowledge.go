@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"backend/database"
 	"backend/models"
+	"backend/services"
 	"backend/utils"
 
 	"github.com/go-chi/chi/v5"
@@ -32,17 +37,25 @@ func CreateKnowledge(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Knowledge base name cannot be empty"})
 		return
 	}
-
-	// Generate a simple collection name (slugified name)
-	collectionName := strings.ToLower(strings.ReplaceAll(form.Name, " ", "-"))
+	if form.TopK < 0 || form.RelevanceThreshold < 0 || form.RelevanceThreshold > 1 {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "top_k must be positive and relevance_threshold between 0 and 1"})
+		return
+	}
+	if form.ChunkOverlap != nil && *form.ChunkOverlap < 0 {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk_overlap cannot be negative"})
+		return
+	}
 
 	knowledge := models.Knowledge{
-		UserID:       userID,
-		Name:         form.Name,
-		Description:  form.Description,
-		CollectionName: collectionName,
-		FileIDs:      []byte("[]"), // Initialize as empty JSON array
-		AccessControl: []byte("{}"), // Initialize as empty JSON object
+		UserID:             userID,
+		Name:               form.Name,
+		Description:        form.Description,
+		CollectionName:     services.KnowledgeCollectionName(form.Name, 0), // Final name needs the ID
+		ChunkSize:          form.ChunkSize,
+		ChunkOverlap:       form.ChunkOverlap,
+		TopK:               form.TopK,
+		RelevanceThreshold: form.RelevanceThreshold,
+		AccessControl:      []byte("{}"), // Initialize as empty JSON object
 	}
 
 	if result := database.DB.Create(&knowledge); result.Error != nil {
@@ -50,6 +63,16 @@ func CreateKnowledge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	knowledge.CollectionName = services.KnowledgeCollectionName(knowledge.Name, knowledge.ID)
+	if result := database.DB.Model(&knowledge).Update("collection_name", knowledge.CollectionName); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create knowledge base"})
+		return
+	}
+
+	// Creating the vector collection calls the embedder, so it is left to the
+	// ingestion workers; the first ingestion creates it if that fails
+	services.EnqueueKnowledgeCollection(knowledge.ID)
+
 	utils.RespondWithJSON(w, http.StatusCreated, knowledge)
 }
 
@@ -67,30 +90,13 @@ func GetKnowledges(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Populate files for each knowledge base (simplified, without full metadata yet)
-	var knowledgeResponses []models.KnowledgeUserResponse
-	for _, k := range knowledges {
-		var fileIDs []uint
-		json.Unmarshal(k.FileIDs, &fileIDs)
-
-		var files []models.File
-		if len(fileIDs) > 0 {
-			database.DB.Where(fileIDs).Find(&files)
-		}
-
-		knowledgeResponses = append(knowledgeResponses, models.KnowledgeUserResponse{
-			KnowledgeResponse: models.KnowledgeResponse{
-				ID:          k.ID,
-				Name:        k.Name,
-				Description: k.Description,
-				CreatedAt:   k.CreatedAt,
-				UpdatedAt:   k.UpdatedAt,
-			},
-			Files: files,
-		})
+	// Populate files and their ingestion status for every knowledge base at once
+	responses, err := knowledgeUserResponses(knowledges)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve knowledge base files"})
+		return
 	}
-
-	utils.RespondWithJSON(w, http.StatusOK, knowledgeResponses)
+	utils.RespondWithJSON(w, http.StatusOK, responses)
 }
 
 // GetKnowledgeByID retrieves a single knowledge base by ID
@@ -114,23 +120,10 @@ func GetKnowledgeByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var fileIDs []uint
-	json.Unmarshal(knowledge.FileIDs, &fileIDs)
-
-	var files []models.File
-	if len(fileIDs) > 0 {
-		database.DB.Where(fileIDs).Find(&files)
-	}
-
-	response := models.KnowledgeUserResponse{
-		KnowledgeResponse: models.KnowledgeResponse{
-			ID:          knowledge.ID,
-			Name:        knowledge.Name,
-			Description: knowledge.Description,
-			CreatedAt:   knowledge.CreatedAt,
-			UpdatedAt:   knowledge.UpdatedAt,
-		},
-		Files: files,
+	response, err := knowledgeUserResponse(knowledge)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve knowledge base files"})
+		return
 	}
 
 	utils.RespondWithJSON(w, http.StatusOK, response)
@@ -168,32 +161,31 @@ func UpdateKnowledge(w http.ResponseWriter, r *http.Request) {
 		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Knowledge base name cannot be empty"})
 		return
 	}
+	if form.TopK < 0 || form.RelevanceThreshold < 0 || form.RelevanceThreshold > 1 {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "top_k must be positive and relevance_threshold between 0 and 1"})
+		return
+	}
+	if form.ChunkOverlap != nil && *form.ChunkOverlap < 0 {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "chunk_overlap cannot be negative"})
+		return
+	}
 
 	knowledge.Name = form.Name
 	knowledge.Description = form.Description
+	knowledge.ChunkSize = form.ChunkSize
+	knowledge.ChunkOverlap = form.ChunkOverlap
+	knowledge.TopK = form.TopK
+	knowledge.RelevanceThreshold = form.RelevanceThreshold
 
 	if result := database.DB.Save(&knowledge); result.Error != nil {
 		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update knowledge base"})
 		return
 	}
 
-	var fileIDs []uint
-	json.Unmarshal(knowledge.FileIDs, &fileIDs)
-
-	var files []models.File
-	if len(fileIDs) > 0 {
-		database.DB.Where(fileIDs).Find(&files)
-	}
-
-	response := models.KnowledgeUserResponse{
-		KnowledgeResponse: models.KnowledgeResponse{
-			ID:          knowledge.ID,
-			Name:        knowledge.Name,
-			Description: knowledge.Description,
-			CreatedAt:   knowledge.CreatedAt,
-			UpdatedAt:   knowledge.UpdatedAt,
-		},
-		Files: files,
+	response, err := knowledgeUserResponse(knowledge)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve knowledge base files"})
+		return
 	}
 
 	utils.RespondWithJSON(w, http.StatusOK, response)
@@ -220,8 +212,23 @@ func DeleteKnowledge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// For now, simply delete the knowledge base. In a full implementation, you would also delete
-	// associated vector database collections and potentially files if not referenced elsewhere.
+	// Web page snapshots only exist for the knowledge base
+	var sources []models.KnowledgeWebSource
+	database.DB.Where("knowledge_id = ?", knowledge.ID).Find(&sources)
+	for _, source := range sources {
+		if err := services.DeleteWebSource(source); err != nil {
+			utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete web sources"})
+			return
+		}
+	}
+
+	// Drop the knowledge base's file memberships, indexed chunks and vectors;
+	// the files themselves stay since they may be referenced elsewhere
+	if err := services.RemoveFromKnowledge(knowledge, 0); err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete indexed chunks"})
+		return
+	}
+
 	if result := database.DB.Delete(&knowledge); result.Error != nil {
 		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete knowledge base"})
 		return
@@ -263,39 +270,32 @@ func AddFileToKnowledge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var existingFileIDs []uint
-	json.Unmarshal(knowledge.FileIDs, &existingFileIDs)
-
-	// Check if file already exists in knowledge base
-	for _, id := range existingFileIDs {
-		if id == fileID.FileID {
-			utils.RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "File already exists in knowledge base"})
+	// Identical content under another name would only duplicate search results
+	if file.ContentHash != "" {
+		var duplicate models.File
+		result := database.DB.
+			Joins("JOIN knowledge_files ON knowledge_files.file_id = files.id").
+			Where("knowledge_files.knowledge_id = ? AND files.id <> ? AND files.content_hash = ?", knowledge.ID, file.ID, file.ContentHash).
+			First(&duplicate)
+		if result.Error == nil {
+			utils.RespondWithJSON(w, http.StatusConflict, map[string]string{"error": fmt.Sprintf("Knowledge base already contains the same content as %s", duplicate.Name)})
 			return
 		}
 	}
 
-	existingFileIDs = append(existingFileIDs, fileID.FileID)
-	updatedFileIDs, _ := json.Marshal(existingFileIDs)
-	knowledge.FileIDs = updatedFileIDs
-
-	if result := database.DB.Save(&knowledge); result.Error != nil {
+	// Extract and chunk the file in the background; its status shows the progress
+	if err := services.AddToKnowledge(knowledge.ID, file.ID); err == services.ErrFileInKnowledge {
+		utils.RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "File already exists in knowledge base"})
+		return
+	} else if err != nil {
 		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to add file to knowledge base"})
 		return
 	}
 
-	// Re-fetch knowledge with updated files for response
-	var filesInKnowledge []models.File
-	database.DB.Where(existingFileIDs).Find(&filesInKnowledge)
-
-	response := models.KnowledgeUserResponse{
-		KnowledgeResponse: models.KnowledgeResponse{
-			ID:          knowledge.ID,
-			Name:        knowledge.Name,
-			Description: knowledge.Description,
-			CreatedAt:   knowledge.CreatedAt,
-			UpdatedAt:   knowledge.UpdatedAt,
-		},
-		Files: filesInKnowledge,
+	response, err := knowledgeUserResponse(knowledge)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve knowledge base files"})
+		return
 	}
 
 	utils.RespondWithJSON(w, http.StatusOK, response)
@@ -328,48 +328,346 @@ func RemoveFileFromKnowledge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var existingFileIDs []uint
-	json.Unmarshal(knowledge.FileIDs, &existingFileIDs)
+	var kf models.KnowledgeFile
+	if result := database.DB.Where("knowledge_id = ? AND file_id = ?", knowledge.ID, fileID.FileID).First(&kf); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "File not found in knowledge base"})
+		return
+	}
 
-	updatedFileIDs := []uint{}
-	found := false
-	for _, id := range existingFileIDs {
-		if id == fileID.FileID {
-			found = true
-		} else {
-			updatedFileIDs = append(updatedFileIDs, id)
-		}
+	if err := services.RemoveFromKnowledge(knowledge, fileID.FileID); err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to remove file from knowledge base"})
+		return
 	}
 
-	if !found {
-		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "File not found in knowledge base"})
+	response, err := knowledgeUserResponse(knowledge)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve knowledge base files"})
 		return
 	}
 
-	updatedFileIDsBytes, _ := json.Marshal(updatedFileIDs)
-	knowledge.FileIDs = updatedFileIDsBytes
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
 
-	if result := database.DB.Save(&knowledge); result.Error != nil {
-		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to remove file from knowledge base"})
-		return
+// knowledgeUserResponse builds the response for a knowledge base, listing its
+// files along with their ingestion status
+func knowledgeUserResponse(knowledge models.Knowledge) (models.KnowledgeUserResponse, error) {
+	responses, err := knowledgeUserResponses([]models.Knowledge{knowledge})
+	if err != nil {
+		return models.KnowledgeUserResponse{}, err
 	}
+	return responses[0], nil
+}
+
+// knowledgeFileRow is a file of a knowledge base as loaded by knowledgeUserResponses
+type knowledgeFileRow struct {
+	models.KnowledgeFileResponse
+	KnowledgeID uint
+}
 
-	// Re-fetch knowledge with updated files for response
-	var filesInKnowledge []models.File
-	if len(updatedFileIDs) > 0 {
-		database.DB.Where(updatedFileIDs).Find(&filesInKnowledge)
+// knowledgeUserResponses builds the responses of several knowledge bases,
+// loading all of their files and ingestion statuses in a single query. Only
+// files owned by the knowledge base's owner are listed.
+func knowledgeUserResponses(knowledges []models.Knowledge) ([]models.KnowledgeUserResponse, error) {
+	ids := make([]uint, 0, len(knowledges))
+	for _, k := range knowledges {
+		ids = append(ids, k.ID)
+	}
+
+	filesByKnowledge := make(map[uint][]models.KnowledgeFileResponse)
+	if len(ids) > 0 {
+		var rows []knowledgeFileRow
+		result := database.DB.Table("knowledge_files").
+			Select("files.*, knowledge_files.knowledge_id, knowledge_files.status, knowledge_files.error, knowledge_files.chunk_count, knowledge_files.indexed_at").
+			Joins("JOIN knowledges ON knowledges.id = knowledge_files.knowledge_id").
+			Joins("JOIN files ON files.id = knowledge_files.file_id AND files.user_id = knowledges.user_id AND files.deleted_at IS NULL").
+			Where("knowledge_files.knowledge_id IN ?", ids).
+			Order("knowledge_files.created_at, knowledge_files.id").
+			Scan(&rows)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		for _, row := range rows {
+			filesByKnowledge[row.KnowledgeID] = append(filesByKnowledge[row.KnowledgeID], row.KnowledgeFileResponse)
+		}
 	}
 
-	response := models.KnowledgeUserResponse{
+	responses := make([]models.KnowledgeUserResponse, 0, len(knowledges))
+	for _, knowledge := range knowledges {
+		responseFiles := filesByKnowledge[knowledge.ID]
+		if responseFiles == nil {
+			responseFiles = []models.KnowledgeFileResponse{}
+		}
+		responses = append(responses, knowledgeResponse(knowledge, responseFiles))
+	}
+	return responses, nil
+}
+
+func knowledgeResponse(knowledge models.Knowledge, responseFiles []models.KnowledgeFileResponse) models.KnowledgeUserResponse {
+	return models.KnowledgeUserResponse{
 		KnowledgeResponse: models.KnowledgeResponse{
-			ID:          knowledge.ID,
-			Name:        knowledge.Name,
-			Description: knowledge.Description,
-			CreatedAt:   knowledge.CreatedAt,
-			UpdatedAt:   knowledge.UpdatedAt,
+			ID:                 knowledge.ID,
+			Name:               knowledge.Name,
+			Description:        knowledge.Description,
+			ChunkSize:          knowledge.ChunkSize,
+			ChunkOverlap:       knowledge.ChunkOverlap,
+			TopK:               knowledge.TopK,
+			RelevanceThreshold: knowledge.RelevanceThreshold,
+			EmbeddingModel:     knowledge.EmbeddingModel,
+			CreatedAt:          knowledge.CreatedAt,
+			UpdatedAt:          knowledge.UpdatedAt,
 		},
-		Files: filesInKnowledge,
+		Files: responseFiles,
 	}
+}
 
-	utils.RespondWithJSON(w, http.StatusOK, response)
+// QueryKnowledge retrieves the chunks of a knowledge base relevant to a query
+// without calling an LLM, to tune chunking and retrieval
+func QueryKnowledge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	knowledgeID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid knowledge base ID"})
+		return
+	}
+
+	var knowledge models.Knowledge
+	if result := database.DB.Where("id = ? AND user_id = ?", knowledgeID, userID).First(&knowledge); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Knowledge base not found or unauthorized"})
+		return
+	}
+
+	var form models.KnowledgeQueryForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(form.Query) == "" {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Query cannot be empty"})
+		return
+	}
+	if form.TopK < 0 {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "top_k must be positive"})
+		return
+	}
+
+	start := time.Now()
+	results, err := services.QueryKnowledge(knowledge, form.Query, form.FileIDs, form.TopK)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to query knowledge base: " + err.Error()})
+		return
+	}
+	if results == nil {
+		results = []models.Citation{}
+	}
+
+	topK := form.TopK
+	if topK == 0 {
+		topK = services.KnowledgeTopK(knowledge)
+	}
+	utils.RespondWithJSON(w, http.StatusOK, models.KnowledgeQueryResponse{
+		Query:              form.Query,
+		TopK:               topK,
+		RelevanceThreshold: services.KnowledgeRelevanceThreshold(knowledge),
+		HybridSearch:       services.HybridSearchEnabled(),
+		Reranked:           services.KnowledgeReranker() != nil,
+		EmbeddingModel:     knowledge.EmbeddingModel,
+		TookMS:             time.Since(start).Milliseconds(),
+		Results:            results,
+	})
+}
+
+// ReindexKnowledge re-extracts, re-chunks and re-embeds all files of a
+// knowledge base into a fresh collection in the background
+func ReindexKnowledge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	knowledgeID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid knowledge base ID"})
+		return
+	}
+
+	var knowledge models.Knowledge
+	if result := database.DB.Where("id = ? AND user_id = ?", knowledgeID, userID).First(&knowledge); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Knowledge base not found or unauthorized"})
+		return
+	}
+
+	// The rebuild runs in the background and reports knowledgeReindex events to the user's room
+	total, err := services.ReindexKnowledge(knowledge)
+	if err == services.ErrReindexInProgress {
+		utils.RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "Knowledge base is already being reindexed"})
+		return
+	} else if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to start reindexing"})
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusAccepted, map[string]interface{}{
+		"knowledge_id": knowledge.ID,
+		"total":        total,
+		"status":       services.ReindexStarted,
+	})
+}
+
+// AddWebSource adds a web page, sitemap or site crawl to a knowledge base.
+// The pages are fetched and indexed in the background.
+func AddWebSource(w http.ResponseWriter, r *http.Request) {
+	knowledge, ok := ownedKnowledge(w, r)
+	if !ok {
+		return
+	}
+
+	var form models.KnowledgeWebSourceForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Basic validation
+	u, err := url.Parse(strings.TrimSpace(form.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "url must be an absolute http or https URL"})
+		return
+	}
+	if form.Mode == "" {
+		form.Mode = models.WebSourcePage
+	}
+	if form.Mode != models.WebSourcePage && form.Mode != models.WebSourceSitemap && form.Mode != models.WebSourceCrawl {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "mode must be page, sitemap or crawl"})
+		return
+	}
+	if form.MaxDepth < 0 || form.MaxDepth > services.MaxCrawlDepth || form.MaxPages < 0 || form.MaxPages > services.MaxCrawlPages || form.RecrawlInterval < 0 {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("max_depth must be at most %d, max_pages at most %d and recrawl_interval positive", services.MaxCrawlDepth, services.MaxCrawlPages)})
+		return
+	}
+	if form.Mode == models.WebSourceCrawl && form.MaxDepth == 0 {
+		form.MaxDepth = 1
+	}
+
+	source := models.KnowledgeWebSource{
+		KnowledgeID:     knowledge.ID,
+		URL:             u.String(),
+		Mode:            form.Mode,
+		MaxDepth:        form.MaxDepth,
+		MaxPages:        form.MaxPages,
+		AllowedDomains:  strings.Join(form.AllowedDomains, ","),
+		RecrawlInterval: form.RecrawlInterval,
+		Status:          models.CrawlPending,
+	}
+	if result := database.DB.Create(&source); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to add web source"})
+		return
+	}
+
+	go crawlWebSource(source.ID)
+
+	utils.RespondWithJSON(w, http.StatusAccepted, source)
+}
+
+// GetWebSources lists the web sources of a knowledge base
+func GetWebSources(w http.ResponseWriter, r *http.Request) {
+	knowledge, ok := ownedKnowledge(w, r)
+	if !ok {
+		return
+	}
+
+	var sources []models.KnowledgeWebSource
+	if result := database.DB.Where("knowledge_id = ?", knowledge.ID).Order("id").Find(&sources); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve web sources"})
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, sources)
+}
+
+// CrawlWebSource re-crawls a web source now instead of waiting for its schedule
+func CrawlWebSource(w http.ResponseWriter, r *http.Request) {
+	source, ok := ownedWebSource(w, r)
+	if !ok {
+		return
+	}
+	if source.Status == models.CrawlRunning {
+		utils.RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "Web source is already being crawled"})
+		return
+	}
+
+	go crawlWebSource(source.ID)
+
+	utils.RespondWithJSON(w, http.StatusAccepted, source)
+}
+
+// DeleteWebSource removes a web source and its page snapshots from a knowledge base
+func DeleteWebSource(w http.ResponseWriter, r *http.Request) {
+	source, ok := ownedWebSource(w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteWebSource(source); err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete web source"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func crawlWebSource(sourceID uint) {
+	if err := services.CrawlWebSource(sourceID); err != nil && err != services.ErrCrawlInProgress {
+		log.Printf("Crawl of web source %d failed: %v", sourceID, err)
+	}
+}
+
+// ownedKnowledge loads the knowledge base in the URL, responding with an
+// error unless it belongs to the user
+func ownedKnowledge(w http.ResponseWriter, r *http.Request) (models.Knowledge, bool) {
+	var knowledge models.Knowledge
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return knowledge, false
+	}
+
+	knowledgeID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid knowledge base ID"})
+		return knowledge, false
+	}
+
+	if result := database.DB.Where("id = ? AND user_id = ?", knowledgeID, userID).First(&knowledge); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Knowledge base not found or unauthorized"})
+		return knowledge, false
+	}
+	return knowledge, true
+}
+
+// ownedWebSource loads the web source in the URL, responding with an error
+// unless its knowledge base belongs to the user
+func ownedWebSource(w http.ResponseWriter, r *http.Request) (models.KnowledgeWebSource, bool) {
+	var source models.KnowledgeWebSource
+	knowledge, ok := ownedKnowledge(w, r)
+	if !ok {
+		return source, false
+	}
+
+	sourceID, err := strconv.ParseUint(chi.URLParam(r, "sourceID"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid web source ID"})
+		return source, false
+	}
+
+	if result := database.DB.Where("id = ? AND knowledge_id = ?", sourceID, knowledge.ID).First(&source); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Web source not found"})
+		return source, false
+	}
+	return source, true
 }
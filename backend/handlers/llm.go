@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -22,16 +23,20 @@ type LLMHandler struct {
 }
 
 func (h *LLMHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
-	if _, ok := r.Context().Value("userID").(uint); !ok {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
 		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
 		return
 	}
 
 	var request struct {
-		Model    string           `json:"model"`
-		Messages []models.Message `json:"messages"`
-		Stream   bool             `json:"stream"`
-		ChatID   uint             `json:"chat_id"` // Added for continuity with chat history
+		Model     string             `json:"model"`
+		Messages  []models.Message   `json:"messages"`
+		Stream    bool               `json:"stream"`
+		ChatID    uint               `json:"chat_id"`    // Added for continuity with chat history
+		Files     []models.RAGSource `json:"files"`      // Knowledge bases and files to retrieve context from
+		WebSearch bool               `json:"web_search"` // Search the web for context as well
+		ToolIDs   []string           `json:"tool_ids"`   // Tools whose functions the model may call
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -39,23 +44,70 @@ func (h *LLMHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if request.ChatID != 0 {
+		_, role, err := ChatRoleForUser(request.ChatID, userID)
+		if err != nil {
+			http.Error(w, "Chat not found or unauthorized", http.StatusNotFound)
+			return
+		}
+		if !CanPostToChat(role) {
+			http.Error(w, "Viewers cannot post messages", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := resolveMessageAttachments(userID, request.ChatID, request.Messages); err != nil {
+		respondAttachmentError(w, err)
+		return
+	}
+	tools, err := services.LoadToolset(userID, request.ToolIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vision := services.ModelSupportsVision(request.Model)
+	for _, m := range request.Messages {
+		if m.ID == 0 && !vision && services.HasImageAttachments([]models.Message{m}) {
+			http.Error(w, fmt.Sprintf("Model %s does not accept images", request.Model), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Retrieve context from the referenced knowledge bases and files, and
+	// from attached documents too long to give to the model whole
+	sources := append(request.Files, services.AttachmentSources(request.Messages)...)
+	citations, err := retrieveContext(userID, request.Messages, sources)
+	if errors.Is(err, services.ErrInvalidRAGSource) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Retrieval failed, answering without context: %v", err)
+	}
+
+	// Web results are cited after the knowledge base chunks
+	if request.WebSearch {
+		if services.WebSearchProvider() == nil {
+			http.Error(w, "Web search is not enabled", http.StatusBadRequest)
+			return
+		}
+		webCitations, err := services.WebSearch(request.Model, request.Messages)
+		if err != nil {
+			log.Printf("Web search failed, answering without it: %v", err)
+		}
+		citations = services.MergeCitations(citations, webCitations)
+	}
+
 	// Determine which LLM to call based on the model name
 	if strings.HasPrefix(request.Model, "ollama/") {
-		allMessages := request.Messages
-		if request.ChatID != 0 {
-			// Fetch previous messages for context
-			var previousMessages []models.Message
-			database.DB.Where("chat_id = ?", request.ChatID).Order("created_at asc").Find(&previousMessages)
-			allMessages = append(previousMessages, request.Messages...)
-		}
+		allMessages := buildLLMMessages(request.ChatID, request.Messages, citations)
 
 		ollamaRequest := models.OllamaChatRequest{
-			Model:    strings.TrimPrefix(request.Model, "ollama/"),
-			Messages: allMessages,
-			Stream:   request.Stream,
+			Model:  strings.TrimPrefix(request.Model, "ollama/"),
+			Stream: request.Stream,
 		}
 
-		res, err := services.CallOllama(ollamaRequest)
+		res, err := services.CallOllamaWithTools(ollamaRequest, allMessages, vision, tools, h.toolMessageRecorder(request.ChatID))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -64,35 +116,31 @@ func (h *LLMHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		// Save assistant message to DB
 		if request.ChatID != 0 && res != nil && res.Message.Content != "" {
 			assistantMessage := models.Message{
-				ChatID:  request.ChatID,
-				Role:    res.Message.Role,
-				Content: res.Message.Content,
+				ChatID:    request.ChatID,
+				ParentID:  latestChatMessageID(request.ChatID),
+				Role:      res.Message.Role,
+				Content:   res.Message.Content,
+				Citations: citationsJSON(citations),
 			}
 			if result := database.DB.Create(&assistantMessage); result.Error != nil {
 				log.Printf("Error saving assistant message: %v", result.Error)
 			}
 			// Emit new message via Socket.IO
-			h.SocketIOServer.BroadcastToRoom(fmt.Sprintf("chat:%d", request.ChatID), "message", assistantMessage)
+			h.SocketIOServer.BroadcastToRoom(services.ChatRoom(request.ChatID), "message", assistantMessage)
 		}
 
+		res.Citations = citations
 		utils.RespondWithJSON(w, http.StatusOK, res)
 
 	} else if strings.HasPrefix(request.Model, "openai/") {
-		allMessages := request.Messages
-		if request.ChatID != 0 {
-			// Fetch previous messages for context
-			var previousMessages []models.Message
-			database.DB.Where("chat_id = ?", request.ChatID).Order("created_at asc").Find(&previousMessages)
-			allMessages = append(previousMessages, request.Messages...)
-		}
+		allMessages := buildLLMMessages(request.ChatID, request.Messages, citations)
 
 		openaiRequest := models.OpenAIChatRequest{
-			Model:    strings.TrimPrefix(request.Model, "openai/"),
-			Messages: allMessages,
-			Stream:   request.Stream,
+			Model:  strings.TrimPrefix(request.Model, "openai/"),
+			Stream: request.Stream,
 		}
 
-		res, err := services.CallOpenAI(openaiRequest)
+		res, err := services.CallOpenAIWithTools(openaiRequest, allMessages, vision, tools, h.toolMessageRecorder(request.ChatID))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -101,20 +149,148 @@ func (h *LLMHandler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		// Save assistant message to DB
 		if request.ChatID != 0 && res != nil && len(res.Choices) > 0 && res.Choices[0].Message.Content != "" {
 			assistantMessage := models.Message{
-				ChatID:  request.ChatID,
-				Role:    res.Choices[0].Message.Role,
-				Content: res.Choices[0].Message.Content,
+				ChatID:    request.ChatID,
+				ParentID:  latestChatMessageID(request.ChatID),
+				Role:      res.Choices[0].Message.Role,
+				Content:   res.Choices[0].Message.Content,
+				Citations: citationsJSON(citations),
 			}
 			if result := database.DB.Create(&assistantMessage); result.Error != nil {
 				log.Printf("Error saving assistant message: %v", result.Error)
 			}
 			// Emit new message via Socket.IO
-			h.SocketIOServer.BroadcastToRoom(fmt.Sprintf("chat:%d", request.ChatID), "message", assistantMessage)
+			h.SocketIOServer.BroadcastToRoom(services.ChatRoom(request.ChatID), "message", assistantMessage)
 		}
 
+		res.Citations = citations
 		utils.RespondWithJSON(w, http.StatusOK, res)
 	} else {
 		http.Error(w, "Unsupported LLM model", http.StatusBadRequest)
 		return
 	}
 }
+
+// toolMessageRecorder returns a function that saves the tool calls and tool
+// results of a completion to the chat and emits them, like the answer
+func (h *LLMHandler) toolMessageRecorder(chatID uint) func(models.Message) {
+	return func(message models.Message) {
+		if chatID == 0 {
+			return
+		}
+		message.ChatID = chatID
+		message.ParentID = latestChatMessageID(chatID)
+		if result := database.DB.Create(&message); result.Error != nil {
+			log.Printf("Error saving tool message: %v", result.Error)
+			return
+		}
+		h.SocketIOServer.BroadcastToRoom(services.ChatRoom(chatID), "message", message)
+	}
+}
+
+// resolveMessageAttachments checks the attachments of new messages against
+// the user's files. Messages already in the chat had theirs checked when they
+// were posted, so their attachments are read back from the chat rather than
+// trusted from the request.
+func resolveMessageAttachments(userID uint, chatID uint, messages []models.Message) error {
+	for i, m := range messages {
+		if m.ID == 0 {
+			if len(m.Attachments) == 0 {
+				continue
+			}
+			attachments, err := services.ResolveAttachments(userID, m.Attachments)
+			if err != nil {
+				return err
+			}
+			messages[i].Attachments = attachments
+			continue
+		}
+
+		messages[i].Attachments = nil
+		if chatID == 0 {
+			continue
+		}
+		var stored models.Message
+		if result := database.DB.Where("id = ? AND chat_id = ?", m.ID, chatID).First(&stored); result.Error == nil {
+			messages[i].Attachments = stored.Attachments
+		}
+	}
+	return nil
+}
+
+// respondAttachmentError maps attachment errors to HTTP errors
+func respondAttachmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrAttachmentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrAttachmentNotSupported):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		http.Error(w, "Failed to attach files", http.StatusInternalServerError)
+	}
+}
+
+// retrieveContext retrieves the chunks relevant to the last user message from
+// the requested sources and the knowledge bases it mentions as #name
+func retrieveContext(userID uint, messages []models.Message, sources []models.RAGSource) ([]models.Citation, error) {
+	query := lastUserMessage(messages)
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	mentioned, err := services.KnowledgeMentions(userID, query)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, mentioned...)
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	return services.Retrieve(userID, query, sources, 0)
+}
+
+// buildLLMMessages prepends the chat history to the request messages and,
+// when context was retrieved, inserts it as a system message right before them
+func buildLLMMessages(chatID uint, messages []models.Message, citations []models.Citation) []models.Message {
+	var allMessages []models.Message
+	if chatID != 0 {
+		// Fetch previous messages for context
+		database.DB.Where("chat_id = ?", chatID).Order("created_at asc").Find(&allMessages)
+	}
+
+	if len(citations) > 0 {
+		allMessages = append(allMessages, models.Message{
+			Role:    "system",
+			Content: services.RenderRAGPrompt(services.RAGTemplate(), citations, lastUserMessage(messages)),
+		})
+	}
+	allMessages = append(allMessages, messages...)
+
+	// Citations are for clients, not for the model
+	for i := range allMessages {
+		allMessages[i].Citations = nil
+	}
+	return allMessages
+}
+
+// lastUserMessage returns the content of the last user message
+func lastUserMessage(messages []models.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// citationsJSON encodes citations for storage on a message, nil when there are none
+func citationsJSON(citations []models.Citation) json.RawMessage {
+	if len(citations) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(citations)
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/database"
+	"backend/models"
+	"backend/services"
+	"backend/utils"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// ChatRoleForUser returns the chat and the user's role in it: owner, editor
+// or viewer. It returns gorm.ErrRecordNotFound when the user has no access.
+func ChatRoleForUser(chatID uint, userID uint) (models.Chat, string, error) {
+	var chat models.Chat
+	if result := database.DB.First(&chat, chatID); result.Error != nil {
+		return chat, "", result.Error
+	}
+	if chat.UserID == userID {
+		return chat, models.ChatRoleOwner, nil
+	}
+
+	var participant models.ChatParticipant
+	if result := database.DB.Where("chat_id = ? AND user_id = ?", chatID, userID).First(&participant); result.Error != nil {
+		return chat, "", result.Error
+	}
+	return chat, participant.Role, nil
+}
+
+// CanPostToChat reports whether a role may post messages to a chat
+func CanPostToChat(role string) bool {
+	return role == models.ChatRoleOwner || role == models.ChatRoleEditor
+}
+
+// GetChatParticipants lists the users who have access to a chat
+func (h *Handler) GetChatParticipants(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	chatID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid chat ID"})
+		return
+	}
+
+	if _, _, err := ChatRoleForUser(uint(chatID), userID); err != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Chat not found or unauthorized"})
+		return
+	}
+
+	var participants []models.ChatParticipant
+	if result := database.DB.Preload("User").Where("chat_id = ?", chatID).Find(&participants); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve participants"})
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, participants)
+}
+
+// AddChatParticipant gives another user viewer or editor access to a chat (owner only)
+func (h *Handler) AddChatParticipant(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	chatID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid chat ID"})
+		return
+	}
+
+	var chat models.Chat
+	if result := database.DB.Where("id = ? AND user_id = ?", chatID, userID).First(&chat); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Chat not found or unauthorized"})
+		return
+	}
+
+	var form models.ChatParticipantForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !validParticipantRole(form.Role) {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Role must be viewer or editor"})
+		return
+	}
+
+	var user models.User
+	query := database.DB
+	if form.UserID != 0 {
+		query = query.Where("id = ?", form.UserID)
+	} else if strings.TrimSpace(form.Email) != "" {
+		query = query.Where("email = ?", strings.TrimSpace(form.Email))
+	} else {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "user_id or email is required"})
+		return
+	}
+	if result := query.First(&user); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "User not found"})
+		return
+	}
+	if user.ID == chat.UserID {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "The chat owner is already a participant"})
+		return
+	}
+
+	var existing models.ChatParticipant
+	if result := database.DB.Where("chat_id = ? AND user_id = ?", chatID, user.ID).First(&existing); result.Error == nil {
+		utils.RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "User is already a participant"})
+		return
+	}
+
+	participant := models.ChatParticipant{
+		ChatID: chat.ID,
+		UserID: user.ID,
+		Role:   form.Role,
+	}
+	if result := database.DB.Create(&participant); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to add participant"})
+		return
+	}
+	participant.User = user
+
+	h.SocketIOServer.BroadcastToRoom(services.ChatRoom(uint(chatID)), "participantAdded", participant)
+	utils.RespondWithJSON(w, http.StatusCreated, participant)
+}
+
+// UpdateChatParticipant changes a participant's role (owner only)
+func (h *Handler) UpdateChatParticipant(w http.ResponseWriter, r *http.Request) {
+	participant, ok := h.loadParticipantForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var form models.ChatParticipantForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !validParticipantRole(form.Role) {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Role must be viewer or editor"})
+		return
+	}
+
+	downgraded := CanPostToChat(participant.Role) && !CanPostToChat(form.Role)
+	participant.Role = form.Role
+	if result := database.DB.Save(&participant); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update participant"})
+		return
+	}
+
+	h.SocketIOServer.BroadcastToRoom(services.ChatRoom(participant.ChatID), "participantUpdated", participant)
+	// Open connections joined with the old role; they have to join again
+	if downgraded {
+		h.SocketIOServer.BroadcastToRoom(services.UserRoom(participant.UserID), ChatAccessRevokedEvent, ChatAccessRevoked{ChatID: participant.ChatID, Role: participant.Role})
+	}
+	utils.RespondWithJSON(w, http.StatusOK, participant)
+}
+
+// RemoveChatParticipant revokes a participant's access to a chat (owner only)
+func (h *Handler) RemoveChatParticipant(w http.ResponseWriter, r *http.Request) {
+	participant, ok := h.loadParticipantForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	// Hard delete so the user can be added again later without hitting the unique index
+	if result := database.DB.Unscoped().Delete(&participant); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to remove participant"})
+		return
+	}
+
+	h.SocketIOServer.BroadcastToRoom(services.ChatRoom(participant.ChatID), "participantRemoved", map[string]uint{
+		"chat_id": participant.ChatID,
+		"user_id": participant.UserID,
+	})
+	h.SocketIOServer.BroadcastToRoom(services.UserRoom(participant.UserID), ChatAccessRevokedEvent, ChatAccessRevoked{ChatID: participant.ChatID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadParticipantForOwner loads the participant addressed by the {id} and
+// {userID} URL parameters, checking that the current user owns the chat
+func (h *Handler) loadParticipantForOwner(w http.ResponseWriter, r *http.Request) (models.ChatParticipant, bool) {
+	var participant models.ChatParticipant
+
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return participant, false
+	}
+
+	chatID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid chat ID"})
+		return participant, false
+	}
+	participantUserID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+		return participant, false
+	}
+
+	var chat models.Chat
+	if result := database.DB.Where("id = ? AND user_id = ?", chatID, userID).First(&chat); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Chat not found or unauthorized"})
+		return participant, false
+	}
+
+	result := database.DB.Where("chat_id = ? AND user_id = ?", chatID, participantUserID).First(&participant)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Participant not found"})
+		return participant, false
+	} else if result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve participant"})
+		return participant, false
+	}
+
+	return participant, true
+}
+
+func validParticipantRole(role string) bool {
+	return role == models.ChatRoleViewer || role == models.ChatRoleEditor
+}
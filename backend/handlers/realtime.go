@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/services"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// realtimeSendBuffer is the number of events queued per native client before
+// it is considered too slow and disconnected
+const realtimeSendBuffer = 64
+
+// sseHeartbeatInterval keeps idle SSE connections open through proxies
+const sseHeartbeatInterval = 25 * time.Second
+
+// ErrNotInChat is returned for room actions by a client that has not joined the chat
+var ErrNotInChat = errors.New("join the chat first")
+
+// ChatAccessRevokedEvent is sent to a user's room when they are removed from
+// a chat or lose the right to post in it. Every instance evicts the user's
+// connections from the chat when it receives it, see EvictionSink; clients
+// may join again when Role is not empty.
+const ChatAccessRevokedEvent = "chatAccessRevoked"
+
+// ChatAccessRevoked is the payload of ChatAccessRevokedEvent
+type ChatAccessRevoked struct {
+	ChatID uint   `json:"chat_id"`
+	Role   string `json:"role,omitempty"` // Role the user still has, empty when removed
+}
+
+// RealtimeGateway implements the chat room semantics shared by every realtime
+// transport (Socket.IO, WebSocket and SSE): joining with access checks,
+// presence, typing indicators and replay of missed events.
+type RealtimeGateway struct {
+	Hub         *services.Hub
+	Broadcaster *services.RoomBroadcaster
+	Presence    *services.PresenceTracker
+}
+
+// JoinResult describes a successful join
+type JoinResult struct {
+	ChatID         uint
+	Role           string
+	LatestSeq      int64
+	Missed         []services.BroadcastEvent
+	ResyncRequired bool
+}
+
+// Join checks that a user may access a chat, subscribes the client through
+// subscribe, records presence and computes the events missed since lastSeq
+// (0 for a fresh join). subscribe is called before replay is computed so that
+// no event falls between the two; clients ignore duplicate sequence numbers.
+func (g *RealtimeGateway) Join(clientID string, userID uint, chatID uint, lastSeq int64, subscribe func(room string)) (JoinResult, error) {
+	_, role, err := ChatRoleForUser(chatID, userID)
+	if err != nil {
+		return JoinResult{}, errors.New("chat not found or unauthorized")
+	}
+
+	var user models.User
+	database.DB.First(&user, userID)
+
+	room := services.ChatRoom(chatID)
+	subscribe(room)
+	members := g.Presence.Join(chatID, clientID, services.PresenceUser{UserID: userID, Name: user.Name})
+
+	result := JoinResult{ChatID: chatID, Role: role, LatestSeq: g.Broadcaster.LatestSeq(room)}
+	if lastSeq > 0 {
+		missed, complete := g.Broadcaster.Replay(room, lastSeq)
+		result.Missed = missed
+		result.ResyncRequired = !complete
+	}
+
+	g.Broadcaster.BroadcastToRoom(room, "presence", map[string]interface{}{"chat_id": chatID, "users": members})
+	return result, nil
+}
+
+// Leave removes a client from a chat's presence
+func (g *RealtimeGateway) Leave(clientID string, chatID uint) {
+	if members, ok := g.Presence.Leave(chatID, clientID); ok {
+		g.Broadcaster.BroadcastToRoom(services.ChatRoom(chatID), "presence", map[string]interface{}{"chat_id": chatID, "users": members})
+	}
+}
+
+// Disconnect removes a client from every chat's presence
+func (g *RealtimeGateway) Disconnect(clientID string) {
+	for chatID, members := range g.Presence.LeaveAll(clientID) {
+		g.Broadcaster.BroadcastToRoom(services.ChatRoom(chatID), "presence", map[string]interface{}{"chat_id": chatID, "users": members})
+	}
+}
+
+// Evict removes a user's connections on this instance from a chat's room
+// and presence
+func (g *RealtimeGateway) Evict(userID uint, chatID uint) {
+	room := services.ChatRoom(chatID)
+	for _, clientID := range g.Presence.Clients(chatID, userID) {
+		g.Hub.Unsubscribe(room, clientID)
+		g.Leave(clientID, chatID)
+	}
+}
+
+// EvictionSink returns a Hub sink that evicts users from chats when a
+// ChatAccessRevokedEvent reaches their user room. leaveSocketIO removes the
+// user's Socket.IO connections from the chat room, which the Hub does not track.
+func (g *RealtimeGateway) EvictionSink(leaveSocketIO func(userID uint, room string)) func(services.BroadcastEvent) {
+	return func(ev services.BroadcastEvent) {
+		if ev.Event != ChatAccessRevokedEvent {
+			return
+		}
+		userID, ok := services.ParseUserRoom(ev.Room)
+		var revoked ChatAccessRevoked
+		if !ok || json.Unmarshal(ev.Payload, &revoked) != nil {
+			return
+		}
+		leaveSocketIO(userID, services.ChatRoom(revoked.ChatID))
+		g.Evict(userID, revoked.ChatID)
+	}
+}
+
+// Typing relays a typing indicator from a client that has joined the chat
+func (g *RealtimeGateway) Typing(clientID string, chatID uint, typing bool) error {
+	user, ok := g.Presence.InRoom(chatID, clientID)
+	if !ok {
+		return ErrNotInChat
+	}
+	g.Broadcaster.BroadcastToRoom(services.ChatRoom(chatID), "typing", map[string]interface{}{
+		"chat_id": chatID,
+		"user_id": user.UserID,
+		"name":    user.Name,
+		"typing":  typing,
+	})
+	return nil
+}
+
+// realtimeCommand is a message sent by a WebSocket client
+type realtimeCommand struct {
+	Type    string `json:"type"` // auth, joinChat, leaveChat, typing or ping
+	Token   string `json:"token,omitempty"`
+	ChatID  uint   `json:"chat_id,omitempty"`
+	LastSeq int64  `json:"last_seq,omitempty"`
+	Typing  bool   `json:"typing,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Browsers send the token cookie along with cross-site WebSocket
+	// handshakes, which CORS does not cover
+	CheckOrigin: realtimeOriginAllowed,
+}
+
+// realtimeOriginAllowed reports whether a realtime request comes from a page
+// that may use the user's cookie: requests without an Origin header (not sent
+// by a browser), from the backend's own host or from an origin listed in
+// ALLOWED_ORIGINS (comma-separated, e.g. https://chat.example.com)
+func realtimeOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(config.Config("ALLOWED_ORIGINS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeWebSocket serves the native WebSocket transport. Clients authenticate
+// with a bearer token, the token cookie, a token query parameter or an auth
+// command, then send joinChat/leaveChat/typing commands and receive events.
+func (g *RealtimeGateway) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	var userID uint
+	if tokenString := realtimeToken(r); tokenString != "" {
+		user, err := UserFromToken(tokenString)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID = user.ID
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	client := newWSClient(conn)
+	defer func() {
+		g.Hub.UnsubscribeAll(client.id)
+		g.Disconnect(client.id)
+		client.close()
+	}()
+	go client.writePump()
+
+	if userID != 0 {
+		g.Hub.Subscribe(services.UserRoom(userID), client.id, client)
+		client.Send(realtimeReply("authenticated", 0, 0, userID))
+	}
+
+	conn.SetReadLimit(64 << 10)
+	for {
+		var cmd realtimeCommand
+		if err := conn.ReadJSON(&cmd); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket %s read error: %v", client.id, err)
+			}
+			return
+		}
+
+		if cmd.Type == "auth" {
+			user, err := UserFromToken(cmd.Token)
+			if err != nil {
+				client.Send(realtimeReply("authError", 0, 0, "Invalid token"))
+				return
+			}
+			if userID != 0 {
+				g.Hub.Unsubscribe(services.UserRoom(userID), client.id)
+			}
+			userID = user.ID
+			g.Hub.Subscribe(services.UserRoom(userID), client.id, client)
+			client.Send(realtimeReply("authenticated", 0, 0, userID))
+			continue
+		}
+		if userID == 0 {
+			client.Send(realtimeReply("error", 0, 0, "Unauthorized"))
+			continue
+		}
+
+		switch cmd.Type {
+		case "joinChat":
+			result, err := g.Join(client.id, userID, cmd.ChatID, cmd.LastSeq, func(room string) {
+				g.Hub.Subscribe(room, client.id, client)
+			})
+			if err != nil {
+				client.Send(realtimeReply("error", cmd.ChatID, 0, err.Error()))
+				continue
+			}
+			sendJoinResult(client.sendWait, result)
+		case "leaveChat":
+			g.Hub.Unsubscribe(services.ChatRoom(cmd.ChatID), client.id)
+			g.Leave(client.id, cmd.ChatID)
+			client.Send(realtimeReply("leftChat", cmd.ChatID, 0, cmd.ChatID))
+		case "typing":
+			if err := g.Typing(client.id, cmd.ChatID, cmd.Typing); err != nil {
+				client.Send(realtimeReply("error", cmd.ChatID, 0, err.Error()))
+			}
+		case "ping":
+			client.Send(realtimeReply("pong", 0, 0, nil))
+		default:
+			client.Send(realtimeReply("error", 0, 0, fmt.Sprintf("Unknown command %q", cmd.Type)))
+		}
+	}
+}
+
+// ServeSSE streams a chat's events as Server-Sent Events. The event ID is the
+// sequence number, so a reconnecting EventSource resumes through Last-Event-ID.
+// SSE is receive-only; typing indicators need the WebSocket or Socket.IO transport.
+func (g *RealtimeGateway) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	user, err := UserFromToken(realtimeToken(r))
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	lastSeq, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if q := r.URL.Query().Get("last_seq"); q != "" {
+		lastSeq, _ = strconv.ParseInt(q, 10, 64)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	client := &sseClient{id: "sse:" + newClientID(), events: make(chan services.RealtimeEvent, realtimeSendBuffer), overflow: make(chan struct{})}
+	result, err := g.Join(client.id, user.ID, uint(chatID), lastSeq, func(room string) {
+		g.Hub.Subscribe(room, client.id, client)
+	})
+	if err != nil {
+		http.Error(w, "Chat not found or unauthorized", http.StatusNotFound)
+		return
+	}
+	defer func() {
+		g.Hub.UnsubscribeAll(client.id)
+		g.Disconnect(client.id)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Replayed events are written directly; they may exceed the send buffer
+	if !sendJoinResult(func(ev services.RealtimeEvent) bool { return writeSSEEvent(w, ev) == nil }, result) {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev := <-client.events:
+			if err := writeSSEEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-client.overflow:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// sendJoinResult sends the join acknowledgement followed by any replayed
+// events. It stops and returns false as soon as send fails.
+func sendJoinResult(send func(services.RealtimeEvent) bool, result JoinResult) bool {
+	if !send(realtimeReply("joinedChat", result.ChatID, result.LatestSeq, result.ChatID)) {
+		return false
+	}
+	if result.ResyncRequired && !send(realtimeReply("resyncRequired", result.ChatID, result.LatestSeq, result.ChatID)) {
+		return false
+	}
+	for _, ev := range result.Missed {
+		if !send(services.NewRealtimeEvent(ev)) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeSSEEvent(w http.ResponseWriter, ev services.RealtimeEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if ev.Seq > 0 && ev.Type != "joinedChat" && ev.Type != "resyncRequired" {
+		if _, err := fmt.Fprintf(w, "id: %d\n", ev.Seq); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return err
+}
+
+func realtimeReply(eventType string, chatID uint, seq int64, v interface{}) services.RealtimeEvent {
+	ev := services.RealtimeEvent{Type: eventType, ChatID: chatID, Seq: seq}
+	if v != nil {
+		ev.Data, _ = json.Marshal(v)
+	}
+	return ev
+}
+
+// realtimeToken extracts the JWT from the Authorization header, the token
+// cookie or, for clients like EventSource that cannot set headers, the token
+// query parameter. The cookie is only used for requests from allowed origins,
+// since other sites' pages can make the browser send it.
+func realtimeToken(r *http.Request) string {
+	if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		return strings.TrimPrefix(bearer, "Bearer ")
+	}
+	if cookie, err := r.Cookie("token"); err == nil && realtimeOriginAllowed(r) {
+		return cookie.Value
+	}
+	return r.URL.Query().Get("token")
+}
+
+func newClientID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// wsClient is a native WebSocket connection
+type wsClient struct {
+	id        string
+	conn      *websocket.Conn
+	events    chan services.RealtimeEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWSClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{
+		id:     "ws:" + newClientID(),
+		conn:   conn,
+		events: make(chan services.RealtimeEvent, realtimeSendBuffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// Send queues an event, closing the connection if the client is too slow
+func (c *wsClient) Send(ev services.RealtimeEvent) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.events <- ev:
+		return true
+	default:
+		log.Printf("WebSocket %s is too slow, disconnecting", c.id)
+		c.close()
+		return false
+	}
+}
+
+// sendWait queues an event, waiting for room in the buffer. It is used for
+// replays, which can be larger than the buffer.
+func (c *wsClient) sendWait(ev services.RealtimeEvent) bool {
+	select {
+	case c.events <- ev:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *wsClient) writePump() {
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case ev := <-c.events:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.conn.WriteJSON(ev); err != nil {
+				c.close()
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// sseClient is a Server-Sent Events connection
+type sseClient struct {
+	id           string
+	events       chan services.RealtimeEvent
+	overflow     chan struct{}
+	overflowOnce sync.Once
+}
+
+// Send queues an event, ending the stream if the client is too slow
+func (c *sseClient) Send(ev services.RealtimeEvent) bool {
+	select {
+	case c.events <- ev:
+		return true
+	default:
+		c.overflowOnce.Do(func() { close(c.overflow) })
+		return false
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/database"
+	"backend/models"
+	"backend/services"
+	"backend/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// shareTokenBytes is the amount of randomness in a share token (256 bits)
+const shareTokenBytes = 32
+
+// CreateChatShare snapshots a chat and its current messages under a new share token
+func CreateChatShare(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	chatID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid chat ID"})
+		return
+	}
+
+	var form models.ChatShareForm
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+			utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	switch {
+	case form.ExpiresAt != nil:
+		if !form.ExpiresAt.After(now) {
+			utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "expires_at must be in the future"})
+			return
+		}
+		expiresAt = form.ExpiresAt
+	case form.ExpiresInHours < 0:
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "expires_in_hours cannot be negative"})
+		return
+	case form.ExpiresInHours > 0:
+		t := now.Add(time.Duration(form.ExpiresInHours) * time.Hour)
+		expiresAt = &t
+	}
+
+	var chat models.Chat
+	if result := database.DB.Where("id = ? AND user_id = ?", chatID, userID).First(&chat); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Chat not found or unauthorized"})
+		return
+	}
+
+	var messages []models.Message
+	if result := database.DB.Where("chat_id = ?", chatID).Order("created_at asc, id asc").Find(&messages); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve messages"})
+		return
+	}
+
+	// Internal chat and message IDs are not exposed to share viewers
+	snapshot := services.RemapChatExportIDs(services.ExportChat(chat, messages))
+	snapshotBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to snapshot chat"})
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate share token"})
+		return
+	}
+
+	share := models.ChatShare{
+		Token:     token,
+		ChatID:    chat.ID,
+		UserID:    userID,
+		Title:     chat.Title,
+		Snapshot:  snapshotBytes,
+		ExpiresAt: expiresAt,
+	}
+	if result := database.DB.Create(&share); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create share"})
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusCreated, share)
+}
+
+// GetChatShares lists the share links created for a chat
+func GetChatShares(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	chatID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid chat ID"})
+		return
+	}
+
+	var shares []models.ChatShare
+	if result := database.DB.Where("chat_id = ? AND user_id = ?", chatID, userID).Order("created_at desc").Find(&shares); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve shares"})
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, shares)
+}
+
+// RevokeChatShare revokes a share link so it can no longer be viewed
+func RevokeChatShare(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var share models.ChatShare
+	if result := database.DB.Where("token = ? AND user_id = ?", chi.URLParam(r, "token"), userID).First(&share); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Share not found or unauthorized"})
+		return
+	}
+
+	if share.RevokedAt == nil {
+		now := time.Now()
+		share.RevokedAt = &now
+		if result := database.DB.Save(&share); result.Error != nil {
+			utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to revoke share"})
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSharedChat serves a share snapshot to unauthenticated viewers
+func GetSharedChat(w http.ResponseWriter, r *http.Request) {
+	var share models.ChatShare
+	if result := database.DB.Where("token = ?", chi.URLParam(r, "token")).First(&share); result.Error != nil {
+		utils.RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Shared chat not found"})
+		return
+	}
+
+	if !share.Active(time.Now()) {
+		utils.RespondWithJSON(w, http.StatusGone, map[string]string{"error": "Shared chat has expired or was revoked"})
+		return
+	}
+
+	var snapshot models.ChatExportChat
+	if err := json.Unmarshal(share.Snapshot, &snapshot); err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read shared chat"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	utils.RespondWithJSON(w, http.StatusOK, models.SharedChatResponse{
+		Title:     snapshot.Title,
+		SharedAt:  share.CreatedAt,
+		ExpiresAt: share.ExpiresAt,
+		Messages:  snapshot.Messages,
+	})
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
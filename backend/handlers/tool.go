@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 
 	"backend/database"
 	"backend/models"
+	"backend/services"
 	"backend/utils"
 
 	"github.com/go-chi/chi/v5"
@@ -27,10 +30,13 @@ func CreateTool(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Basic validation
-	if strings.TrimSpace(form.ID) == "" || strings.TrimSpace(form.Name) == "" || strings.TrimSpace(form.Content) == "" {
-		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "ID, Name, and Content cannot be empty"})
+	if strings.TrimSpace(form.ID) == "" || strings.TrimSpace(form.Name) == "" {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "ID and Name cannot be empty"})
 		return
 	}
+	if form.Type == "" {
+		form.Type = models.ToolTypeCode
+	}
 
 	// Check if tool ID already exists
 	var existingTool models.Tool
@@ -39,16 +45,18 @@ func CreateTool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Implement Python code processing and spec generation as in python/backend/open_webui/routers/tools.py
-
 	tool := models.Tool{
 		ID:        form.ID,
 		UserID:    userID,
 		Name:      form.Name,
-		Content:   form.Content,
 		Meta:      form.Meta,
 		AccessControl: form.AccessControl,
-		Specs:     []byte("{}"), // Placeholder for generated specs
+	}
+	if form.Auth != nil {
+		tool.Auth = *form.Auth
+	}
+	if !loadToolSource(w, &tool, form) {
+		return
 	}
 
 	if result := database.DB.Create(&tool); result.Error != nil {
@@ -80,7 +88,9 @@ func GetTools(w http.ResponseWriter, r *http.Request) {
 			ToolResponse: models.ToolResponse{
 				ID:        t.ID,
 				Name:      t.Name,
+				Type:      t.Type,
 				Content:   t.Content,
+				URL:       t.URL,
 				Specs:     t.Specs,
 				Meta:      t.Meta,
 				CreatedAt: t.CreatedAt,
@@ -138,15 +148,21 @@ func UpdateTool(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Basic validation
-	if strings.TrimSpace(form.Name) == "" || strings.TrimSpace(form.Content) == "" {
-		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Name and Content cannot be empty"})
+	if strings.TrimSpace(form.Name) == "" {
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Name cannot be empty"})
+		return
+	}
+	if form.Type == "" {
+		form.Type = tool.Type
+	}
+	if form.Auth != nil {
+		tool.Auth = *form.Auth
+	}
+	if !loadToolSource(w, &tool, form) {
 		return
 	}
-
-	// TODO: Implement Python code processing and spec generation as in python/backend/open_webui/routers/tools.py
 
 	tool.Name = form.Name
-	tool.Content = form.Content
 	tool.Meta = form.Meta
 	tool.AccessControl = form.AccessControl
 
@@ -182,3 +198,69 @@ func DeleteTool(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// loadToolSource sets the content and specs of a tool from a form: the code
+// of a code tool, or the OpenAPI document of a tool server fetched from its
+// URL. It answers with an error and returns false when they cannot be loaded.
+func loadToolSource(w http.ResponseWriter, tool *models.Tool, form models.ToolForm) bool {
+	switch form.Type {
+	case models.ToolTypeCode:
+		if strings.TrimSpace(form.Content) == "" {
+			utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Content cannot be empty"})
+			return false
+		}
+		specs, ok := generateToolSpecs(w, form.Content)
+		if !ok {
+			return false
+		}
+		tool.Type, tool.Content, tool.URL, tool.Specs = form.Type, form.Content, "", specs
+	case models.ToolTypeOpenAPI:
+		if strings.TrimSpace(form.URL) == "" {
+			utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "URL cannot be empty"})
+			return false
+		}
+		document, specs, err := services.FetchOpenAPITool(form.URL, tool.Auth)
+		if err != nil {
+			utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Failed to load tool server: " + err.Error()})
+			return false
+		}
+		data, err := json.Marshal(specs)
+		if err != nil {
+			utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate tool specs"})
+			return false
+		}
+		tool.Type, tool.Content, tool.URL, tool.Specs = form.Type, string(document), form.URL, data
+	default:
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Type must be code or openapi"})
+		return false
+	}
+	return true
+}
+
+// generateToolSpecs reads the functions of a tool's code into its Specs,
+// without running it. It answers with an error and returns false when the
+// code cannot be parsed.
+func generateToolSpecs(w http.ResponseWriter, content string) ([]byte, bool) {
+	specs, err := services.GenerateToolSpecs(content)
+	var callErr *services.ToolCallError
+	switch {
+	case errors.Is(err, services.ErrToolExecutionUnavailable):
+		// Tools can be stored without a Python to parse them; they offer no
+		// functions until they are updated with one
+		return []byte("[]"), true
+	case errors.As(err, &callErr), errors.Is(err, services.ErrToolTimeout):
+		utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid tool code: " + err.Error()})
+		return nil, false
+	case err != nil:
+		log.Printf("Failed to generate tool specs: %v", err)
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate tool specs"})
+		return nil, false
+	}
+
+	data, err := json.Marshal(specs)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate tool specs"})
+		return nil, false
+	}
+	return data, true
+}
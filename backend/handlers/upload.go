@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/database"
+	"backend/models"
+	"backend/services"
+	"backend/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Resumable uploads: POST /api/uploads declares the file, PATCH
+// /api/uploads/{id} appends chunks at the offset given in the Upload-Offset
+// header, and POST /api/uploads/{id}/complete turns the upload into a file
+// in the background. After a dropped connection, GET /api/uploads/{id} tells
+// where to resume; once completing, it reports the status and the file.
+
+// CreateUpload starts a resumable upload
+func CreateUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var form models.UploadForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	form.Name = strings.TrimSpace(form.Name)
+	if form.Name == "" || form.Size < 0 {
+		http.Error(w, "name and a non-negative size are required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify folder belongs to user
+	if form.FolderID != nil {
+		var folder models.Folder
+		if result := database.DB.Where("id = ? AND user_id = ?", *form.FolderID, userID).First(&folder); result.Error != nil {
+			http.Error(w, "Folder not found or unauthorized", http.StatusNotFound)
+			return
+		}
+	}
+
+	upload, err := services.CreateUpload(userID, form)
+	switch err {
+	case nil:
+	case services.ErrUploadTooLarge, services.ErrUploadLimit:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case services.ErrQuotaExceeded:
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", "0")
+	utils.RespondWithJSON(w, http.StatusCreated, upload)
+}
+
+// GetUploads lists the user's uploads that have not expired
+func GetUploads(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var uploads []models.Upload
+	if result := database.DB.Where("user_id = ? AND expires_at > now()", userID).Order("created_at").Find(&uploads); result.Error != nil {
+		http.Error(w, "Failed to retrieve uploads", http.StatusInternalServerError)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, uploads)
+}
+
+// GetUpload returns an upload, including the offset to resume from
+func GetUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	uploadID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := services.GetUpload(uint(uploadID), userID)
+	if err != nil {
+		http.Error(w, "Upload not found or unauthorized", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	utils.RespondWithJSON(w, http.StatusOK, upload)
+}
+
+// AppendUpload writes the request body to an upload at the Upload-Offset
+// header. An optional Upload-Checksum header ("sha256 <hex>") is verified
+// before the chunk is kept.
+func AppendUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	uploadID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+
+	var checksum string
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		algorithm, value, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(algorithm, "sha256") || !services.IsBlobKey(strings.ToLower(value)) {
+			http.Error(w, "Upload-Checksum must be \"sha256 <hex digest>\"", http.StatusBadRequest)
+			return
+		}
+		checksum = value
+	}
+
+	upload, err := services.AppendUpload(uint(uploadID), userID, offset, r.Body, checksum)
+	if upload.ID != 0 {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	switch err {
+	case nil:
+	case services.ErrUploadNotFound:
+		http.Error(w, "Upload not found or unauthorized", http.StatusNotFound)
+		return
+	case services.ErrUploadOffsetMismatch, services.ErrUploadBusy, services.ErrUploadClosed:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case services.ErrUploadTooLarge:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case services.ErrUploadChecksum:
+		// 460 Checksum Mismatch, as in the tus checksum extension
+		http.Error(w, err.Error(), 460)
+		return
+	default:
+		http.Error(w, "Failed to write chunk: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, upload)
+}
+
+// CompleteUpload starts turning a fully received upload into a file and
+// answers 202 with the upload; GetUpload then reports the outcome
+func CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	uploadID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := services.CompleteUpload(uint(uploadID), userID)
+	switch err {
+	case nil:
+	case services.ErrUploadNotFound:
+		http.Error(w, "Upload not found or unauthorized", http.StatusNotFound)
+		return
+	case services.ErrUploadIncomplete:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case services.ErrUploadBusy, services.ErrUploadClosed:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Failed to complete upload", http.StatusInternalServerError)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusAccepted, upload)
+}
+
+// DeleteUpload cancels an upload
+func DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	uploadID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+
+	switch err := services.AbortUpload(uint(uploadID), userID); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case services.ErrUploadNotFound:
+		http.Error(w, "Upload not found or unauthorized", http.StatusNotFound)
+	case services.ErrUploadBusy:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Failed to cancel upload", http.StatusInternalServerError)
+	}
+}
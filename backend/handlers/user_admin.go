@@ -7,6 +7,7 @@ import (
 
 	"backend/database"
 	"backend/models"
+	"backend/services"
 	"backend/utils"
 
 	"github.com/go-chi/chi/v5"
@@ -55,6 +56,15 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	user.Name = form.Name
 	user.Email = form.Email
 	user.Role = form.Role
+	// The quota is only changed when given; a negative one clears it back to
+	// the group or role default
+	if form.StorageQuota != nil {
+		if *form.StorageQuota < 0 {
+			user.StorageQuota = nil
+		} else {
+			user.StorageQuota = form.StorageQuota
+		}
+	}
 	// user.ProfileImageURL = form.ProfileImageURL // Add this field to the User model if needed
 
 	if form.Password != "" {
@@ -94,6 +104,28 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetStorageReport lists the users whose files take the most storage, up to
+// ?limit= (20 by default) (admin only)
+func GetStorageReport(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			utils.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	reports, err := services.HeaviestStorageUsers(limit)
+	if err != nil {
+		utils.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to compute storage report"})
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, reports)
+}
+
 // GetCurrentUser retrieves the profile of the currently authenticated user
 func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("userID").(uint)
@@ -1,22 +1,22 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"backend/config"
 	"backend/database"
 	"backend/handlers"
 	"backend/middleware"
-	"backend/models"
 	"backend/routes"
+	"backend/services"
 	"backend/utils"
 
 	"github.com/go-chi/chi/v5"
 	chi_middleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	"github.com/golang-jwt/jwt/v5"
 	socketio "github.com/googollee/go-socket.io"
 )
 
@@ -24,15 +24,33 @@ import (
 type App struct {
 	Router         *chi.Mux
 	SocketIOServer *socketio.Server
+	Presence       *services.PresenceTracker
+	Broadcaster    *services.RoomBroadcaster
+	Hub            *services.Hub
+	Gateway        *handlers.RealtimeGateway
 }
 
-// socketIOServerAdapter adapts *socketio.Server to handlers.SocketIORoomBroadcaster
+// typingEvent is the payload of the Socket.IO "typing" event
+type typingEvent struct {
+	ChatID uint `json:"chat_id"`
+	Typing bool `json:"typing"`
+}
+
+// socketIOServerAdapter delivers broadcast events to the Socket.IO sockets
+// connected to this process. It is registered as a sink of App.Hub, which
+// receives the events App.Broadcaster fans out to every instance.
 type socketIOServerAdapter struct {
 	srv *socketio.Server
 }
 
-func (ad *socketIOServerAdapter) BroadcastToRoom(room string, event string, v interface{}) {
-	ad.srv.BroadcastToRoom("/", room, event, v)
+// Deliver emits an event to a room. Sequenced events carry their sequence
+// number as a second argument so clients can resume with joinChat.
+func (ad *socketIOServerAdapter) Deliver(ev services.BroadcastEvent) {
+	if ev.Seq > 0 {
+		ad.srv.BroadcastToRoom("/", ev.Room, ev.Event, ev.Payload, ev.Seq)
+		return
+	}
+	ad.srv.BroadcastToRoom("/", ev.Room, ev.Event, ev.Payload)
 }
 
 // Initialize initializes the application
@@ -41,23 +59,49 @@ func (a *App) Initialize() {
 	a.Router = chi.NewRouter()
 	a.initializeMiddleware()
 	a.initializeSocketIO()
+	a.initializeBroadcaster()
 	a.initializeRoutes()
+
+	if err := services.InitStorage(); err != nil {
+		log.Fatalf("Failed to set up file storage: %v", err)
+	}
+	go services.MigrateFileBlobs()
+	services.StartUploadJanitor()
+	services.StartFileRetention()
+
+	// Index files added to knowledge bases in the background
+	if err := services.InitKnowledgeIndex(); err != nil {
+		log.Fatalf("Failed to set up the knowledge index: %v", err)
+	}
+	services.SetKnowledgeNotifier(a.Broadcaster)
+	services.StartIngestionWorkers()
+	services.StartWebCrawler()
 }
 
 // Run starts the application
 func (a *App) Run(addr string) {
 	defer a.SocketIOServer.Close()
+	defer a.Broadcaster.Close()
 	log.Fatal(http.ListenAndServe(addr, a.Router))
 }
 
 func (a *App) initializeMiddleware() {
 	a.Router.Use(chi_middleware.Logger)
 	a.Router.Use(chi_middleware.Recoverer)
+	// ALLOWED_ORIGINS also decides which pages may use the token cookie on
+	// the realtime transports
+	allowedOrigins := []string{"*"}
+	if origins := config.Config("ALLOWED_ORIGINS"); origins != "" {
+		allowedOrigins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			allowedOrigins = append(allowedOrigins, strings.TrimSpace(origin))
+		}
+	}
 	a.Router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Upload-Offset", "Upload-Checksum", "Range"},
+		ExposedHeaders:   []string{"Link", "Upload-Offset", "Content-Range"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -65,6 +109,7 @@ func (a *App) initializeMiddleware() {
 
 func (a *App) initializeSocketIO() {
 	a.SocketIOServer = socketio.NewServer(nil)
+	a.Presence = services.NewPresenceTracker()
 
 	a.SocketIOServer.OnConnect("/", func(s socketio.Conn) error {
 		log.Println("Socket.IO connected:", s.ID())
@@ -72,32 +117,28 @@ func (a *App) initializeSocketIO() {
 	})
 
 	a.SocketIOServer.OnEvent("/", "auth", func(s socketio.Conn, tokenString string) {
-		claims := &handlers.Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return handlers.GetJWTKey(), nil
-		})
-
-		if err != nil || !token.Valid {
+		user, err := handlers.UserFromToken(tokenString)
+		if err != nil {
 			s.Emit("authError", "Invalid token")
 			// close the connection for invalid auth
 			_ = s.Close()
 			return
 		}
 
-		var user models.User
-		if result := database.DB.Where("email = ?", claims.Email).First(&user); result.Error != nil {
-			s.Emit("authError", "User not found")
-			_ = s.Close()
-			return
-		}
-
 		// store the user id directly in the socket's context
+		if previous, ok := s.Context().(uint); ok {
+			s.Leave(services.UserRoom(previous))
+		}
 		s.SetContext(user.ID)
+		s.Join(services.UserRoom(user.ID))
 		s.Emit("authenticated", user.ID)
 		log.Printf("Socket %s authenticated for user %d\n", s.ID(), user.ID)
 	})
 
-	a.SocketIOServer.OnEvent("/", "joinChat", func(s socketio.Conn, chatIDStr string) {
+	// joinChat takes the chat ID and, when reconnecting, the last sequence
+	// number the client has seen. Missed events are replayed in order, or
+	// resyncRequired is emitted when they are no longer available.
+	a.SocketIOServer.OnEvent("/", "joinChat", func(s socketio.Conn, chatIDStr string, lastSeq int64) {
 		userID, ok := s.Context().(uint)
 		if !ok {
 			s.Emit("error", "Unauthorized")
@@ -110,15 +151,28 @@ func (a *App) initializeSocketIO() {
 			return
 		}
 
-		var chat models.Chat
-		if result := database.DB.Where("id = ? AND user_id = ?", chatID, userID).First(&chat); result.Error != nil {
+		result, err := a.Gateway.Join(s.ID(), userID, uint(chatID), lastSeq, func(room string) {
+			s.Join(room)
+		})
+		if err != nil {
 			s.Emit("error", "Chat not found or unauthorized")
 			return
 		}
 
-		s.Join(fmt.Sprintf("chat:%d", chatID))
-		log.Printf("Socket %s joined chat %d for user %d\n", s.ID(), chatID, userID)
-		s.Emit("joinedChat", chatID)
+		log.Printf("Socket %s joined chat %d for user %d as %s\n", s.ID(), chatID, userID, result.Role)
+		s.Emit("joinedChat", chatID, result.LatestSeq)
+		if result.ResyncRequired {
+			s.Emit("resyncRequired", chatID, result.LatestSeq)
+		}
+		for _, ev := range result.Missed {
+			s.Emit(ev.Event, ev.Payload, ev.Seq)
+		}
+	})
+
+	a.SocketIOServer.OnEvent("/", "typing", func(s socketio.Conn, ev typingEvent) {
+		if err := a.Gateway.Typing(s.ID(), ev.ChatID, ev.Typing); err != nil {
+			s.Emit("error", "Join the chat before sending typing events")
+		}
 	})
 
 	a.SocketIOServer.OnEvent("/", "leaveChat", func(s socketio.Conn, chatIDStr string) {
@@ -132,13 +186,15 @@ func (a *App) initializeSocketIO() {
 			s.Emit("error", "Invalid chat ID")
 			return
 		}
-		s.Leave(fmt.Sprintf("chat:%d", chatID))
+		s.Leave(services.ChatRoom(uint(chatID)))
 		log.Printf("Socket %s left chat %d for user %d\n", s.ID(), chatID, userID)
 		s.Emit("leftChat", chatID)
+		a.Gateway.Leave(s.ID(), uint(chatID))
 	})
 
 	a.SocketIOServer.OnDisconnect("/", func(s socketio.Conn, reason string) {
 		log.Println("Socket.IO disconnected:", s.ID(), "Reason:", reason)
+		a.Gateway.Disconnect(s.ID())
 	})
 
 	a.SocketIOServer.OnError("/", func(s socketio.Conn, err error) {
@@ -154,6 +210,40 @@ func (a *App) initializeSocketIO() {
 	// adapter instance will be created when wiring routes
 }
 
+// initializeBroadcaster sets up the pub/sub fan-out used for room events so
+// that an event emitted on one backend instance reaches sockets on all of them,
+// and the hub and gateway that serve those events over Socket.IO, WebSocket
+// and SSE. Presence is tracked per instance, so with several replicas each one
+// reports the users connected to it.
+func (a *App) initializeBroadcaster() {
+	pubsub, err := services.NewPubSubFromConfig()
+	if err != nil {
+		log.Fatalf("Failed to create broadcast pub/sub: %v", err)
+	}
+
+	logSize, _ := strconv.Atoi(config.Config("EVENT_LOG_SIZE"))
+	a.Hub = services.NewHub()
+	a.Hub.AddSink((&socketIOServerAdapter{srv: a.SocketIOServer}).Deliver)
+	a.Broadcaster, err = services.NewRoomBroadcaster(pubsub, logSize, a.Hub.Deliver)
+	if err != nil {
+		log.Fatalf("Failed to start broadcaster: %v", err)
+	}
+
+	a.Gateway = &handlers.RealtimeGateway{Hub: a.Hub, Broadcaster: a.Broadcaster, Presence: a.Presence}
+	a.Hub.AddSink(a.Gateway.EvictionSink(func(userID uint, room string) {
+		// Leave after ForEach, which holds the room lock that Leave takes
+		var evicted []socketio.Conn
+		a.SocketIOServer.ForEach("/", room, func(s socketio.Conn) {
+			if id, ok := s.Context().(uint); ok && id == userID {
+				evicted = append(evicted, s)
+			}
+		})
+		for _, s := range evicted {
+			s.Leave(room)
+		}
+	}))
+}
+
 func (a *App) initializeRoutes() {
 	a.Router.Get("/health", a.healthCheck)
 
@@ -161,16 +251,22 @@ func (a *App) initializeRoutes() {
 	a.Router.Post("/api/auth/login", handlers.Login)
 	a.Router.Post("/api/auth/register", handlers.Register)
 
+	// Public, read-only chat share links
+	routes.PublicShareRoutes(a.Router)
+
 	// Mount Socket.IO server
 	a.Router.Handle("/socket.io/*", a.SocketIOServer)
 
+	// Native WebSocket and SSE transports authenticate by token themselves
+	routes.RealtimeRoutes(a.Router, a.Gateway)
+
 	// Protected routes
 	a.Router.Group(func(r chi.Router) {
 		r.Use(middleware.AuthMiddleware)
-		// Wrap server with adapter to match handlers.SocketIORoomBroadcaster
-		adapter := &socketIOServerAdapter{srv: a.SocketIOServer}
-		routes.ChatRoutes(r, adapter)
-		routes.LLMRoutes(r, adapter)
+		// Room events go through the broadcaster so they reach every instance
+		routes.ChatRoutes(r, a.Broadcaster)
+		routes.ShareRoutes(r)
+		routes.LLMRoutes(r, a.Broadcaster)
 		routes.FileRoutes(r)
 		routes.KnowledgeRoutes(r)
 		routes.ModelRoutes(r)
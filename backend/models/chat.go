@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -17,11 +18,55 @@ type Chat struct {
 }
 
 type Message struct {
-	ID        uint           `gorm:"primarykey" json:"id"`
-	ChatID    uint           `gorm:"not null" json:"chat_id"`
-	Role      string         `gorm:"not null" json:"role"` // e.g., "user", "assistant"
-	Content   string         `gorm:"not null" json:"content"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID          uint            `gorm:"primarykey" json:"id"`
+	ChatID      uint            `gorm:"not null" json:"chat_id"`
+	ParentID    *uint           `gorm:"index" json:"parent_id,omitempty"` // Previous message in the branch, nil for the first message
+	UserID      *uint           `gorm:"index" json:"user_id,omitempty"`   // Author of a user message, nil for assistant messages
+	Role        string          `gorm:"not null" json:"role"`             // e.g., "user", "assistant"
+	Content     string          `gorm:"not null" json:"content"`
+	Citations   json.RawMessage `gorm:"type:jsonb" json:"citations,omitempty"`                   // JSONB array of Citation for answers grounded in retrieved context
+	Attachments []Attachment    `gorm:"type:jsonb;serializer:json" json:"attachments,omitempty"` // Files attached to a user message
+	ToolCalls   []ToolCall      `gorm:"type:jsonb;serializer:json" json:"tool_calls,omitempty"`  // Tools an assistant message calls
+	ToolCallID  string          `json:"tool_call_id,omitempty"`                                  // Call a tool message answers
+	ToolName    string          `json:"tool_name,omitempty"`                                     // Function a tool message is the result of
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt  `gorm:"index" json:"-"`
+}
+
+// MessageForm for posting a message to a chat
+type MessageForm struct {
+	Role        string       `json:"role"`
+	Content     string       `json:"content"`
+	ParentID    *uint        `json:"parent_id"`   // Message to reply to, nil continues from the latest message
+	Attachments []Attachment `json:"attachments"` // Only FileID is read
+}
+
+// Attachment types
+const (
+	AttachmentImage    = "image"    // Sent to vision models as an image
+	AttachmentDocument = "document" // Inlined as extracted text or retrieved from
+)
+
+// Attachment references a file attached to a message. Clients only send the
+// FileID; the other fields are filled in from the file when it is attached.
+type Attachment struct {
+	FileID   uint   `json:"file_id"`
+	Type     string `json:"type,omitempty"` // image or document
+	Name     string `json:"name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+}
+
+// ToolCall is a call to a tool function made by a model. Arguments is a JSON
+// object, also for providers that send it encoded as a string.
+type ToolCall struct {
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"` // function
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction is the function a ToolCall calls and its arguments
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
 }
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// ChatExportVersion is the version written into every ChatExport document
+const ChatExportVersion = 1
+
+// ChatExport is the portable JSON document used to back up and move chats
+type ChatExport struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Chats      []ChatExportChat `json:"chats"`
+}
+
+// ChatExportChat is a single chat inside a ChatExport
+type ChatExportChat struct {
+	ID        string              `json:"id"`
+	Title     string              `json:"title"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	Messages  []ChatExportMessage `json:"messages"`
+}
+
+// ChatExportMessage is a single message inside a ChatExportChat. IDs are
+// strings so that messages imported from other tools keep their original
+// identifiers; ParentID links a message to the one it follows in its branch.
+type ChatExportMessage struct {
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatImportReport describes the outcome (or, for a dry run, the expected outcome) of an import
+type ChatImportReport struct {
+	Format   string   `json:"format"`
+	DryRun   bool     `json:"dry_run"`
+	Chats    int      `json:"chats"`
+	Messages int      `json:"messages"`
+	Skipped  int      `json:"skipped"`
+	ChatIDs  []uint   `json:"chat_ids,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
@@ -14,6 +14,9 @@ type File struct {
 	Path      string         `gorm:"not null" json:"path"` // Stored path on the server
 	MimeType  string         `json:"mime_type"`
 	Size      int64          `json:"size"`
+	ContentHash string       `gorm:"index" json:"content_hash"` // Hex SHA-256 of the content
+	SourceURL   string       `json:"source_url,omitempty"`       // Page the file is a snapshot of
+	WebSourceID *uint        `gorm:"index" json:"web_source_id,omitempty"` // Web source that crawled the page
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
@@ -34,3 +37,79 @@ type Folder struct {
 	Files  []File  `gorm:"foreignKey:FolderID" json:"files,omitempty"`
 	Subfolders []Folder `gorm:"foreignKey:ParentID" json:"subfolders,omitempty"`
 }
+
+// QuarantinedFile is an upload rejected by the type allow-list or the
+// malware scan. Its content is kept in storage under Path for review but is
+// never served.
+type QuarantinedFile struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	Name        string    `json:"name"`
+	MimeType    string    `json:"mime_type"` // Detected from the content
+	Size        int64     `json:"size"`
+	ContentHash string    `json:"content_hash"`
+	Path        string    `json:"-"`
+	Reason      string    `json:"reason"`
+	Threat      string    `json:"threat,omitempty"` // Reported by the scanner
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RenameForm for renaming a file or folder
+type RenameForm struct {
+	Name string `json:"name"`
+}
+
+// FileMoveForm for moving a file into a folder, or out of all folders with a
+// null folder_id
+type FileMoveForm struct {
+	FolderID *uint `json:"folder_id"`
+}
+
+// FolderMoveForm for moving a folder under another one, or to the top level
+// with a null parent_id
+type FolderMoveForm struct {
+	ParentID *uint `json:"parent_id"`
+}
+
+// StorageUsage reports how much storage a user's files take
+type StorageUsage struct {
+	TotalBytes int64           `json:"total_bytes"`
+	FileCount  int64           `json:"file_count"`
+	Quota      int64           `json:"quota"` // 0 is unlimited
+	ByFolder   []FolderUsage   `json:"by_folder"`
+	ByMimeType []MimeTypeUsage `json:"by_mime_type"`
+}
+
+// FolderUsage is the storage taken by the files directly in a folder, or
+// outside all folders when FolderID is nil
+type FolderUsage struct {
+	FolderID   *uint  `json:"folder_id"`
+	FolderName string `json:"folder_name"`
+	TotalBytes int64  `json:"total_bytes"`
+	FileCount  int64  `json:"file_count"`
+}
+
+// MimeTypeUsage is the storage taken by the files of a MIME type
+type MimeTypeUsage struct {
+	MimeType   string `json:"mime_type"`
+	TotalBytes int64  `json:"total_bytes"`
+	FileCount  int64  `json:"file_count"`
+}
+
+
+// FileArtifact is content derived from a file, such as a thumbnail or its
+// extracted text, cached in storage under Path. It is regenerated when the
+// file's ContentHash no longer matches SourceHash.
+type FileArtifact struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	FileID     uint      `gorm:"not null;uniqueIndex:idx_file_artifact" json:"file_id"`
+	Kind       string    `gorm:"not null;uniqueIndex:idx_file_artifact" json:"kind"` // text or thumbnail-<size>
+	SourceHash string    `json:"source_hash"`
+	Path       string    `gorm:"not null;index" json:"-"`
+	MimeType   string    `json:"mime_type"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	File File `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Group is a set of users that share settings such as a storage quota
+type Group struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	Name         string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description  string    `json:"description"`
+	StorageQuota *int64    `json:"storage_quota"` // Bytes; nil sets none, 0 is unlimited
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// GroupMember is the membership of a user in a group
+type GroupMember struct {
+	GroupID   uint      `gorm:"primaryKey" json:"group_id"`
+	UserID    uint      `gorm:"primaryKey;index" json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GroupForm for creating or updating a group. UserIDs replaces the members
+// when it is given.
+type GroupForm struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	StorageQuota *int64 `json:"storage_quota"`
+	UserIDs      []uint `json:"user_ids"`
+}
+
+// GroupResponse is a group with the IDs of its members
+type GroupResponse struct {
+	Group
+	UserIDs []uint `json:"user_ids"`
+}
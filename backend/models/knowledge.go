@@ -13,8 +13,13 @@ type Knowledge struct {
 	Name         string         `gorm:"uniqueIndex;not null" json:"name"`
 	Description  string         `json:"description"`
 	CollectionName string       `gorm:"not null" json:"collection_name"` // Name of the vector database collection
-	FileIDs      []byte         `gorm:"type:jsonb" json:"file_ids"`      // JSONB array of file IDs
 	AccessControl []byte        `gorm:"type:jsonb" json:"access_control"` // JSONB object for access control
+	ChunkSize    int            `json:"chunk_size"`    // Characters per chunk, 0 uses the server default
+	ChunkOverlap *int           `json:"chunk_overlap"` // Characters shared by consecutive chunks, null uses the server default
+	TopK         int            `json:"top_k"`               // Chunks retrieved per query, 0 uses the server default
+	RelevanceThreshold float64  `json:"relevance_threshold"` // Minimum vector similarity or reranker score from 0 to 1, 0 uses the server default
+	EmbeddingModel      string  `json:"embedding_model"`      // Embedder that produced the collection's vectors
+	EmbeddingDimensions int     `json:"embedding_dimensions"` // Size of the collection's vectors
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
@@ -22,21 +27,144 @@ type Knowledge struct {
 
 // KnowledgeForm for creating and updating a knowledge base
 type KnowledgeForm struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
+	Name               string  `json:"name" binding:"required"`
+	Description        string  `json:"description"`
+	ChunkSize          int     `json:"chunk_size"`
+	ChunkOverlap       *int    `json:"chunk_overlap"` // null uses the server default, 0 means no overlap
+	TopK               int     `json:"top_k"`
+	RelevanceThreshold float64 `json:"relevance_threshold"`
 }
 
 // KnowledgeResponse for returning knowledge base details
 type KnowledgeResponse struct {
-	ID          uint      `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                 uint      `json:"id"`
+	Name               string    `json:"name"`
+	Description        string    `json:"description"`
+	ChunkSize          int       `json:"chunk_size"`
+	ChunkOverlap       *int      `json:"chunk_overlap"`
+	TopK               int       `json:"top_k"`
+	RelevanceThreshold float64   `json:"relevance_threshold"`
+	EmbeddingModel     string    `json:"embedding_model"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
 }
 
 // KnowledgeUserResponse for returning knowledge base details with associated files
 type KnowledgeUserResponse struct {
 	KnowledgeResponse
-	Files []File `json:"files"`
+	Files []KnowledgeFileResponse `json:"files"`
+}
+
+// KnowledgeQueryForm for querying a knowledge base without calling an LLM
+type KnowledgeQueryForm struct {
+	Query   string `json:"query"`
+	TopK    int    `json:"top_k"`    // Overrides the knowledge base's top-k when positive
+	FileIDs []uint `json:"file_ids"` // Restricts the search to these files when not empty
+}
+
+// KnowledgeQueryResponse lists the chunks retrieved for a query along with the
+// retrieval settings that produced them
+type KnowledgeQueryResponse struct {
+	Query              string     `json:"query"`
+	TopK               int        `json:"top_k"`
+	RelevanceThreshold float64    `json:"relevance_threshold"`
+	HybridSearch       bool       `json:"hybrid_search"`
+	Reranked           bool       `json:"reranked"`
+	EmbeddingModel     string     `json:"embedding_model"`
+	TookMS             int64      `json:"took_ms"`
+	Results            []Citation `json:"results"`
+}
+
+// Ingestion statuses of a file in a knowledge base
+const (
+	IngestionPending    = "pending"
+	IngestionProcessing = "processing"
+	IngestionIndexed    = "indexed"
+	IngestionFailed     = "failed"
+)
+
+// KnowledgeFile is the membership of a file in a knowledge base, along with
+// the state of its ingestion
+type KnowledgeFile struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	KnowledgeID uint       `gorm:"not null;uniqueIndex:idx_knowledge_file" json:"knowledge_id"`
+	FileID      uint       `gorm:"not null;uniqueIndex:idx_knowledge_file;index" json:"file_id"`
+	Status      string     `gorm:"not null;default:'pending'" json:"status"`
+	Error       string     `json:"error,omitempty"`
+	ChunkCount  int        `json:"chunk_count"`
+	IndexedAt   *time.Time `json:"indexed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	Knowledge Knowledge `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	File      File      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// KnowledgeChunk is a chunk of a file's extracted text. StartOffset and
+// EndOffset are character offsets into the extracted text.
+type KnowledgeChunk struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	KnowledgeID uint      `gorm:"not null;index:idx_knowledge_chunk_file" json:"knowledge_id"`
+	FileID      uint      `gorm:"not null;index:idx_knowledge_chunk_file" json:"file_id"`
+	ChunkIndex  int       `gorm:"not null" json:"chunk_index"`
+	Content     string    `gorm:"not null" json:"content"`
+	StartOffset int       `json:"start_offset"`
+	EndOffset   int       `json:"end_offset"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// KnowledgeFileResponse is a file in a knowledge base with its ingestion status
+type KnowledgeFileResponse struct {
+	File
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	ChunkCount int        `json:"chunk_count"`
+	IndexedAt  *time.Time `json:"indexed_at"`
+}
+
+// Ways a web source collects pages
+const (
+	WebSourcePage    = "page"    // The URL alone
+	WebSourceSitemap = "sitemap" // Every page listed in the sitemap at the URL
+	WebSourceCrawl   = "crawl"   // Pages linked from the URL, up to MaxDepth links away
+)
+
+// Crawl statuses of a web source
+const (
+	CrawlPending  = "pending"
+	CrawlRunning  = "crawling"
+	CrawlComplete = "completed"
+	CrawlFailed   = "failed"
+)
+
+// KnowledgeWebSource is a URL, sitemap or site whose pages are snapshotted
+// as files and indexed into a knowledge base
+type KnowledgeWebSource struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	KnowledgeID     uint       `gorm:"not null;index" json:"knowledge_id"`
+	URL             string     `gorm:"not null" json:"url"`
+	Mode            string     `gorm:"not null;default:'page'" json:"mode"`
+	MaxDepth        int        `json:"max_depth"`
+	MaxPages        int        `json:"max_pages"`
+	AllowedDomains  string     `json:"allowed_domains"`  // Comma-separated; empty allows the URL's own host
+	RecrawlInterval int        `json:"recrawl_interval"` // Minutes between scheduled crawls, 0 disables them
+	Status          string     `gorm:"not null;default:'pending'" json:"status"`
+	Error           string     `json:"error,omitempty"`
+	PageCount       int        `json:"page_count"`
+	LastCrawledAt   *time.Time `json:"last_crawled_at"`
+	NextCrawlAt     *time.Time `gorm:"index" json:"next_crawl_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	Knowledge Knowledge `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// KnowledgeWebSourceForm for adding web pages to a knowledge base
+type KnowledgeWebSourceForm struct {
+	URL             string   `json:"url"`
+	Mode            string   `json:"mode"` // page (default), sitemap or crawl
+	MaxDepth        int      `json:"max_depth"`
+	MaxPages        int      `json:"max_pages"`
+	AllowedDomains  []string `json:"allowed_domains"`
+	RecrawlInterval int      `json:"recrawl_interval"`
 }
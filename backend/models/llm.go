@@ -2,9 +2,19 @@ package models
 
 // OllamaChatRequest represents the request body for the Ollama chat API
 type OllamaChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model    string           `json:"model"`
+	Messages []OllamaMessage  `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Tools    []ToolDefinition `json:"tools,omitempty"`
+}
+
+// OllamaMessage is a chat message in the Ollama API, with its images base64 encoded
+type OllamaMessage struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
 }
 
 // OllamaChatResponse represents the response body for the Ollama chat API
@@ -13,13 +23,55 @@ type OllamaChatResponse struct {
 	CreatedAt string  `json:"created_at"`
 	Message   Message `json:"message"`
 	Done      bool    `json:"done"`
+	// Citations lists the retrieved context the answer is grounded in
+	Citations []Citation `json:"citations,omitempty"`
 }
 
 // OpenAIChatRequest represents the request body for the OpenAI chat completions API
 type OpenAIChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model    string           `json:"model"`
+	Messages []OpenAIMessage  `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Tools    []ToolDefinition `json:"tools,omitempty"`
+}
+
+// OpenAIMessage is a chat message in the OpenAI API. Content is a string, or
+// a list of OpenAIContentPart when the message has images.
+type OpenAIMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// OpenAIToolCall is a ToolCall in the OpenAI API, whose arguments are a
+// JSON-encoded string
+type OpenAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// ToolDefinition offers a tool function to a model, in the format shared by
+// the OpenAI and Ollama APIs
+type ToolDefinition struct {
+	Type     string   `json:"type"` // function
+	Function ToolSpec `json:"function"`
+}
+
+// OpenAIContentPart is a text or image_url part of an OpenAI message
+type OpenAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *OpenAIImageURL `json:"image_url,omitempty"`
+}
+
+// OpenAIImageURL is an image given to OpenAI vision models, here always a data URL
+type OpenAIImageURL struct {
+	URL string `json:"url"`
 }
 
 // OpenAIChatResponse represents the response body for the OpenAI chat completions API
@@ -38,4 +90,33 @@ type OpenAIChatResponse struct {
 		CompletionTokens int `json:"completion_tokens"`
 		TotalTokens      int `json:"total_tokens"`
 	} `json:"usage"`
+	// Citations lists the retrieved context the answer is grounded in
+	Citations []Citation `json:"citations,omitempty"`
+}
+
+// OllamaEmbedRequest represents the request body for the Ollama embed API
+type OllamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// OllamaEmbedResponse represents the response body for the Ollama embed API
+type OllamaEmbedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// OpenAIEmbeddingRequest represents the request body for the OpenAI embeddings API
+type OpenAIEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// OpenAIEmbeddingResponse represents the response body for the OpenAI embeddings API
+type OpenAIEmbeddingResponse struct {
+	Model string `json:"model"`
+	Data  []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
 }
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Chat access roles. The owner is the chat's creator and is not stored as a participant.
+const (
+	ChatRoleOwner  = "owner"
+	ChatRoleEditor = "editor"
+	ChatRoleViewer = "viewer"
+)
+
+// ChatParticipant grants another user access to a chat
+type ChatParticipant struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	ChatID    uint           `gorm:"not null;uniqueIndex:idx_chat_participant" json:"chat_id"`
+	UserID    uint           `gorm:"not null;uniqueIndex:idx_chat_participant;index" json:"user_id"`
+	Role      string         `gorm:"not null;default:'viewer'" json:"role"` // "viewer" or "editor"
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	User User `gorm:"foreignKey:UserID" json:"user"`
+}
+
+// ChatParticipantForm for adding a participant or changing their role
+type ChatParticipantForm struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"` // Alternative to UserID when adding a participant
+	Role   string `json:"role" binding:"required"`
+}
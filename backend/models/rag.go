@@ -0,0 +1,36 @@
+package models
+
+// Kinds of sources a chat completion can retrieve context from
+const (
+	RAGSourceCollection = "collection" // A knowledge base
+	RAGSourceFile       = "file"       // A single uploaded file
+)
+
+// RAGSource references a knowledge base or a file to retrieve context from
+type RAGSource struct {
+	Type string `json:"type"` // collection or file
+	ID   uint   `json:"id"`
+}
+
+// Citation is a chunk that was retrieved and given to the model as context.
+// Source is the 1-based number the model uses to cite it, e.g. [1]. Score is
+// the final relevance: the reranker's score when reranking is enabled,
+// otherwise the fused hybrid score, or the cosine similarity for vector-only
+// search. The component scores are kept for tuning retrieval.
+type Citation struct {
+	Source      int     `json:"source"`
+	KnowledgeID uint    `json:"knowledge_id,omitempty"` // 0 when the file was read directly
+	FileID      uint    `json:"file_id"`                // 0 for web search results
+	FileName    string  `json:"file_name"`              // The page title for web search results
+	URL         string  `json:"url,omitempty"`          // Set for web search results
+	ChunkID     uint    `json:"chunk_id,omitempty"`     // 0 when the file was read directly
+	ChunkIndex  int     `json:"chunk_index"`
+	StartOffset int     `json:"start_offset"`
+	EndOffset   int     `json:"end_offset"`
+	Score       float64 `json:"score"`
+	Content     string  `json:"content"`
+
+	VectorScore  float64 `json:"vector_score,omitempty"`  // Cosine similarity, when found by vector search
+	KeywordScore float64 `json:"keyword_score,omitempty"` // Full-text rank, when found by keyword search
+	RerankScore  float64 `json:"rerank_score,omitempty"`  // Reranker score, when reranked
+}
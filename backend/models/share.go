@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ChatShare is a read-only snapshot of a chat published under an unguessable token
+type ChatShare struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	Token     string         `gorm:"uniqueIndex;not null" json:"token"`
+	ChatID    uint           `gorm:"not null;index" json:"chat_id"`
+	UserID    uint           `gorm:"not null;index" json:"user_id"`
+	Title     string         `gorm:"not null" json:"title"`
+	Snapshot  []byte         `gorm:"type:jsonb;not null" json:"-"` // JSONB ChatExportChat frozen at share time
+	ExpiresAt *time.Time     `json:"expires_at"`
+	RevokedAt *time.Time     `json:"revoked_at"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Active reports whether the share can still be viewed at the given time
+func (s *ChatShare) Active(now time.Time) bool {
+	if s.RevokedAt != nil {
+		return false
+	}
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+// ChatShareForm for creating a share link
+type ChatShareForm struct {
+	ExpiresInHours int        `json:"expires_in_hours"` // 0 means the share never expires
+	ExpiresAt      *time.Time `json:"expires_at"`       // Takes precedence over ExpiresInHours
+}
+
+// SharedChatResponse is what unauthenticated viewers of a share link receive
+type SharedChatResponse struct {
+	Title     string              `json:"title"`
+	SharedAt  time.Time           `json:"shared_at"`
+	ExpiresAt *time.Time          `json:"expires_at"`
+	Messages  []ChatExportMessage `json:"messages"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,7 +12,10 @@ type Tool struct {
 	ID        string         `gorm:"primarykey" json:"id"`
 	UserID    uint           `json:"user_id"`
 	Name      string         `gorm:"not null" json:"name"`
-	Content   string         `gorm:"not null" json:"content"` // Python code for the tool
+	Type      string         `gorm:"not null;default:code" json:"type"` // code or openapi
+	Content   string         `gorm:"not null" json:"content"` // Python code for the tool, or the OpenAPI document of a tool server
+	URL       string         `json:"url,omitempty"`          // Where the OpenAPI document of a tool server is fetched from
+	Auth      ToolServerAuth `gorm:"type:jsonb;serializer:json" json:"-"` // Credentials sent to a tool server
 	Specs     []byte         `gorm:"type:jsonb" json:"specs"`     // JSONB object for tool specifications
 	Meta      []byte         `gorm:"type:jsonb" json:"meta"`      // JSONB object for tool metadata
 	AccessControl []byte        `gorm:"type:jsonb" json:"access_control"` // JSONB object for access control
@@ -20,11 +24,37 @@ type Tool struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// Tool types
+const (
+	ToolTypeCode    = "code"    // Python code run in the sandbox
+	ToolTypeOpenAPI = "openapi" // Operations of an HTTP server described by an OpenAPI document
+)
+
+// ToolServerAuth is how requests to a tool server are authenticated
+type ToolServerAuth struct {
+	Type     string `json:"type,omitempty"` // bearer, basic, header or empty for none
+	Token    string `json:"token,omitempty"`    // Bearer token, or the value of Header
+	Header   string `json:"header,omitempty"`   // Header name for the header type, e.g. X-API-Key
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// ToolSpec describes a function of a tool. Tool.Specs holds a JSON array of
+// them; Parameters is a JSON Schema object.
+type ToolSpec struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
 // ToolForm for creating and updating a tool
 type ToolForm struct {
 	ID      string `json:"id" binding:"required"`
 	Name    string `json:"name" binding:"required"`
-	Content string `json:"content" binding:"required"`
+	Type    string `json:"type"` // code (default) or openapi
+	Content string `json:"content"` // Python code, required for code tools
+	URL     string `json:"url"`     // OpenAPI document URL, required for openapi tools
+	Auth    *ToolServerAuth `json:"auth"` // Credentials for a tool server; kept when omitted on update
 	Meta    []byte `gorm:"type:jsonb" json:"meta"`
 	AccessControl []byte `gorm:"type:jsonb" json:"access_control"`
 }
@@ -33,7 +63,9 @@ type ToolForm struct {
 type ToolResponse struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
+	Type      string    `json:"type"`
 	Content   string    `json:"content"`
+	URL       string    `json:"url,omitempty"`
 	Specs     []byte    `json:"specs"`
 	Meta      []byte    `json:"meta"`
 	CreatedAt time.Time `json:"created_at"`
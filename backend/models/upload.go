@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Statuses of a resumable upload
+const (
+	UploadReceiving  = "receiving"
+	UploadProcessing = "processing"
+	UploadComplete   = "completed"
+	UploadFailed     = "failed"
+)
+
+// Upload is a resumable upload. Chunks are staged in file storage until
+// Offset reaches Size, then the upload is completed into a File in the
+// background and keeps reporting its Status until it expires.
+type Upload struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	FolderID    *uint      `json:"folder_id"`
+	Name        string     `gorm:"not null" json:"name"`
+	MimeType    string     `json:"mime_type"`
+	Size        int64      `gorm:"not null" json:"size"`                        // Total size declared when the upload was created
+	Offset      int64      `gorm:"column:upload_offset;not null" json:"offset"` // Bytes received so far ("offset" is reserved in SQL)
+	SHA256      string     `json:"sha256,omitempty"`                            // Optional hex SHA-256 the content must match
+	Status      string     `gorm:"not null;default:receiving" json:"status"`
+	Error       string     `json:"error,omitempty"`   // Why completing failed
+	FileID      *uint      `json:"file_id,omitempty"` // The file the upload became
+	LockedUntil *time.Time `json:"-"`                 // End of the lease of the request or worker writing to the upload
+	LockToken   string     `json:"-"`                 // Identifies the holder of the lease
+	ExpiresAt   time.Time  `gorm:"index" json:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// UploadForm for starting a resumable upload
+type UploadForm struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type"`
+	FolderID *uint  `json:"folder_id"`
+	SHA256   string `json:"sha256"`
+}
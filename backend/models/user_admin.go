@@ -7,6 +7,7 @@ type UserUpdateForm struct {
 	Password        string `json:"password,omitempty"`
 	ProfileImageURL string `json:"profile_image_url"`
 	Role            string `json:"role"`
+	StorageQuota    *int64 `json:"storage_quota"` // Unchanged when omitted; negative clears it
 }
 
 // UserRoleUpdateForm for updating a user's role
@@ -45,3 +46,13 @@ type UserIdNameListResponse struct {
 	} `json:"users"`
 	Total int64 `json:"total"`
 }
+
+// UserStorageReport is a user's storage use, for the admin report
+type UserStorageReport struct {
+	UserID     uint   `json:"user_id"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	TotalBytes int64  `json:"total_bytes"`
+	FileCount  int64  `json:"file_count"`
+	Quota      int64  `json:"quota"` // 0 is unlimited
+}
@@ -12,6 +12,13 @@ func ChatRoutes(r chi.Router, srv handlers.SocketIORoomBroadcaster) {
 
 	r.Post("/api/chats", h.CreateChat)
 	r.Get("/api/chats", h.GetChats)
+	r.Get("/api/chats/export", h.ExportChats)
+	r.Post("/api/chats/import", h.ImportChats)
 	r.Get("/api/chats/{id}/messages", h.GetChatMessages)
 	r.Post("/api/chats/{id}/messages", h.CreateChatMessage)
+	r.Get("/api/chats/{id}/export", h.ExportChat)
+	r.Get("/api/chats/{id}/participants", h.GetChatParticipants)
+	r.Post("/api/chats/{id}/participants", h.AddChatParticipant)
+	r.Put("/api/chats/{id}/participants/{userID}", h.UpdateChatParticipant)
+	r.Delete("/api/chats/{id}/participants/{userID}", h.RemoveChatParticipant)
 }
@@ -14,14 +14,32 @@ func FileRoutes(r chi.Router) {
 
 		// File routes
 		r.Post("/api/files/upload", handlers.UploadFile)
+		r.Get("/api/files/quarantine", handlers.GetQuarantinedFiles)
+		r.Get("/api/files/usage", handlers.GetStorageUsage)
 		r.Get("/api/files/{id}", handlers.GetFile)
 		r.Get("/api/files/{id}/download", handlers.DownloadFile)
+		r.Get("/api/files/{id}/url", handlers.GetFileURL)
+		r.Get("/api/files/{id}/thumbnail", handlers.GetFileThumbnail)
+		r.Get("/api/files/{id}/text", handlers.GetFileText)
+		r.Put("/api/files/{id}", handlers.RenameFile)
+		r.Post("/api/files/{id}/move", handlers.MoveFile)
 		r.Delete("/api/files/{id}", handlers.DeleteFile)
 
+		// Resumable upload routes
+		r.Post("/api/uploads", handlers.CreateUpload)
+		r.Get("/api/uploads", handlers.GetUploads)
+		r.Get("/api/uploads/{id}", handlers.GetUpload)
+		r.Patch("/api/uploads/{id}", handlers.AppendUpload)
+		r.Post("/api/uploads/{id}/complete", handlers.CompleteUpload)
+		r.Delete("/api/uploads/{id}", handlers.DeleteUpload)
+
 		// Folder routes
 		r.Post("/api/folders", handlers.CreateFolder)
 		r.Get("/api/folders", handlers.GetFolders)
+		r.Get("/api/folders/tree", handlers.GetFolderTree)
 		r.Get("/api/folders/{id}", handlers.GetFolderContent)
+		r.Put("/api/folders/{id}", handlers.RenameFolder)
+		r.Post("/api/folders/{id}/move", handlers.MoveFolder)
 		r.Delete("/api/folders/{id}", handlers.DeleteFolder)
 	})
 }
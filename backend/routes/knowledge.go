@@ -20,5 +20,11 @@ func KnowledgeRoutes(r chi.Router) {
 		r.Delete("/api/knowledge/{id}", handlers.DeleteKnowledge)
 		r.Post("/api/knowledge/{id}/file/add", handlers.AddFileToKnowledge)
 		r.Post("/api/knowledge/{id}/file/remove", handlers.RemoveFileFromKnowledge)
+		r.Post("/api/knowledge/{id}/query", handlers.QueryKnowledge)
+		r.Post("/api/knowledge/{id}/reindex", handlers.ReindexKnowledge)
+		r.Post("/api/knowledge/{id}/web", handlers.AddWebSource)
+		r.Get("/api/knowledge/{id}/web", handlers.GetWebSources)
+		r.Post("/api/knowledge/{id}/web/{sourceID}/crawl", handlers.CrawlWebSource)
+		r.Delete("/api/knowledge/{id}/web/{sourceID}", handlers.DeleteWebSource)
 	})
 }
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"backend/handlers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RealtimeRoutes defines the native WebSocket and SSE realtime routes. They
+// accept the token as a query parameter as well, because browser WebSocket
+// and EventSource clients cannot set headers, so they are mounted outside
+// the AuthMiddleware group.
+func RealtimeRoutes(r chi.Router, gateway *handlers.RealtimeGateway) {
+	r.Get("/api/realtime/ws", gateway.ServeWebSocket)
+	r.Get("/api/realtime/chats/{id}/events", gateway.ServeSSE)
+}
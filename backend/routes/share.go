@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"backend/handlers"
+	"backend/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ShareRoutes defines the routes for managing chat share links
+func ShareRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware)
+
+		r.Post("/api/chats/{id}/share", handlers.CreateChatShare)
+		r.Get("/api/chats/{id}/shares", handlers.GetChatShares)
+		r.Delete("/api/shares/{token}", handlers.RevokeChatShare)
+	})
+}
+
+// PublicShareRoutes defines the unauthenticated, read-only share routes.
+// They must be mounted outside the AuthMiddleware group.
+func PublicShareRoutes(r chi.Router) {
+	r.Get("/api/share/{token}", handlers.GetSharedChat)
+}
@@ -13,12 +13,18 @@ func ToolRoutes(r chi.Router) {
 		r.Use(middleware.AuthMiddleware)
 
 		// Tool routes
-		r.Post("/api/tools/create", handlers.CreateTool)
 		r.Get("/api/tools", handlers.GetTools)
 		r.Get("/api/tools/id/{id}", handlers.GetToolByID)
-		r.Put("/api/tools/id/{id}/update", handlers.UpdateTool)
 		r.Delete("/api/tools/id/{id}/delete", handlers.DeleteTool)
 
+		// Tools run code and call servers on the backend's behalf, so only
+		// admins may create or change them
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.AdminMiddleware)
+			r.Post("/api/tools/create", handlers.CreateTool)
+			r.Put("/api/tools/id/{id}/update", handlers.UpdateTool)
+		})
+
 		// TODO: Implement other tool-related endpoints (valves, user valves)
 	})
 }
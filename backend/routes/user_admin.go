@@ -10,17 +10,25 @@ import (
 // UserAdminRoutes defines the routes for user administration functionality
 func UserAdminRoutes(r chi.Router) {
 	r.Group(func(r chi.Router) {
-		// These routes should be protected and only accessible by admins.
-		// For simplicity, we use the general AuthMiddleware. A more robust solution
-		// would involve a specific AdminMiddleware.
 		r.Use(middleware.AuthMiddleware)
 
-		// User administration routes
-		r.Get("/api/users", handlers.GetUsers)
-		r.Put("/api/users/{id}", handlers.UpdateUser)
-		r.Delete("/api/users/{id}", handlers.DeleteUser)
-
 		// Route to get the current user's profile
 		r.Get("/api/user/me", handlers.GetCurrentUser)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.AdminMiddleware)
+
+			// User administration routes
+			r.Get("/api/users", handlers.GetUsers)
+			r.Get("/api/users/storage", handlers.GetStorageReport)
+			r.Put("/api/users/{id}", handlers.UpdateUser)
+			r.Delete("/api/users/{id}", handlers.DeleteUser)
+
+			// User group routes
+			r.Get("/api/groups", handlers.GetGroups)
+			r.Post("/api/groups", handlers.CreateGroup)
+			r.Put("/api/groups/{id}", handlers.UpdateGroup)
+			r.Delete("/api/groups/{id}", handlers.DeleteGroup)
+		})
 	})
 }
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+)
+
+// DefaultAttachmentTextLimit is the number of characters of a document
+// attachment inlined into a message when ATTACHMENT_TEXT_LIMIT is not set.
+// Longer documents are retrieved from instead.
+const DefaultAttachmentTextLimit = 32000
+
+// maxImageAttachmentSize bounds the images sent to vision models
+const maxImageAttachmentSize = 20 << 20 // 20 MB
+
+// DefaultVisionModels are the model name patterns that accept images when
+// VISION_MODELS is not set
+var DefaultVisionModels = []string{
+	"llava*", "bakllava*", "llama3.2-vision*", "llama4*", "minicpm-v*", "moondream*",
+	"qwen2.5vl*", "gemma3*", "gpt-4o*", "gpt-4.1*", "gpt-5*", "o3*", "o4*",
+}
+
+// visionImageTypes are the image types vision models accept
+var visionImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var (
+	// ErrAttachmentNotFound is returned for an attachment whose file does not
+	// exist or belongs to another user
+	ErrAttachmentNotFound = errors.New("attached file not found")
+	// ErrAttachmentNotSupported is returned for files that are neither an
+	// image nor a document text can be extracted from
+	ErrAttachmentNotSupported = errors.New("file type cannot be attached")
+	// ErrVisionNotSupported is returned when images are sent to a model that
+	// does not accept them
+	ErrVisionNotSupported = errors.New("model does not accept images")
+)
+
+// ResolveAttachments checks that the attached files belong to the user and
+// fills in their name, MIME type and attachment type
+func ResolveAttachments(userID uint, attachments []models.Attachment) ([]models.Attachment, error) {
+	resolved := make([]models.Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		var file models.File
+		if result := database.DB.Where("id = ? AND user_id = ?", a.FileID, userID).First(&file); result.Error != nil {
+			return nil, fmt.Errorf("%w: file %d", ErrAttachmentNotFound, a.FileID)
+		}
+
+		attachment := models.Attachment{FileID: file.ID, Name: file.Name, MimeType: file.MimeType}
+		switch {
+		case visionImageTypes[file.MimeType]:
+			if file.Size > maxImageAttachmentSize {
+				return nil, fmt.Errorf("%w: image %q is larger than %d bytes", ErrAttachmentNotSupported, file.Name, maxImageAttachmentSize)
+			}
+			attachment.Type = models.AttachmentImage
+		case documentKind(file.MimeType, file.Name) != "":
+			attachment.Type = models.AttachmentDocument
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrAttachmentNotSupported, file.Name)
+		}
+		resolved = append(resolved, attachment)
+	}
+	return resolved, nil
+}
+
+// HasImageAttachments reports whether any of the messages has an image attached
+func HasImageAttachments(messages []models.Message) bool {
+	for _, m := range messages {
+		for _, a := range m.Attachments {
+			if a.Type == models.AttachmentImage {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ModelSupportsVision reports whether a model accepts images. A model
+// configured in the models table decides with "capabilities": {"vision": ...}
+// in its meta; other models are matched against the VISION_MODELS patterns
+// (comma-separated, e.g. llava*,gpt-4o*), or DefaultVisionModels when unset.
+// Model uses the provider prefixes of chat completions, e.g. "ollama/llava".
+func ModelSupportsVision(model string) bool {
+	name := model
+	if i := strings.Index(model, "/"); i >= 0 {
+		name = model[i+1:]
+	}
+
+	var configured models.Model
+	if result := database.DB.Where("id IN ?", []string{model, name}).First(&configured); result.Error == nil {
+		var meta struct {
+			Capabilities struct {
+				Vision *bool `json:"vision"`
+			} `json:"capabilities"`
+		}
+		if json.Unmarshal(configured.Meta, &meta) == nil && meta.Capabilities.Vision != nil {
+			return *meta.Capabilities.Vision
+		}
+	}
+
+	patterns := DefaultVisionModels
+	if value := config.Config("VISION_MODELS"); value != "" {
+		patterns = strings.Split(value, ",")
+	}
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(strings.TrimSpace(pattern)), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentTextLimit is the number of characters of a document inlined
+// into a message
+func attachmentTextLimit() int {
+	limit, err := strconv.Atoi(config.Config("ATTACHMENT_TEXT_LIMIT"))
+	if err != nil || limit < 0 {
+		return DefaultAttachmentTextLimit
+	}
+	return limit
+}
+
+// AttachmentSources returns the documents attached to the last user message
+// that are too long to inline, as sources to retrieve context from
+func AttachmentSources(messages []models.Message) []models.RAGSource {
+	var sources []models.RAGSource
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "user" {
+			continue
+		}
+		for _, a := range messages[i].Attachments {
+			if a.Type != models.AttachmentDocument {
+				continue
+			}
+			if _, inline := attachmentText(a); !inline {
+				sources = append(sources, models.RAGSource{Type: models.RAGSourceFile, ID: a.FileID})
+			}
+		}
+		break
+	}
+	return sources
+}
+
+// attachmentText returns the extracted text of a document attachment and
+// whether it is short enough to inline
+func attachmentText(a models.Attachment) (string, bool) {
+	var file models.File
+	if result := database.DB.First(&file, a.FileID); result.Error != nil {
+		return "", false
+	}
+	text, err := FileText(file)
+	if err != nil {
+		log.Printf("Failed to extract text of attached file %d: %v", a.FileID, err)
+		return "", false
+	}
+	return text, utf8.RuneCountInString(text) <= attachmentTextLimit()
+}
+
+// attachedImage is an image attachment ready to be sent to a model
+type attachedImage struct {
+	MimeType string
+	Data     string // base64
+}
+
+// renderAttachments returns a message's content with its documents inlined
+// and its images. Without vision, or when an attachment can no longer be
+// read, the model is told about the attachment in the text instead.
+func renderAttachments(m models.Message, vision bool) (string, []attachedImage) {
+	if len(m.Attachments) == 0 {
+		return m.Content, nil
+	}
+
+	var b strings.Builder
+	b.WriteString(m.Content)
+	var images []attachedImage
+	for _, a := range m.Attachments {
+		switch a.Type {
+		case models.AttachmentImage:
+			if !vision {
+				fmt.Fprintf(&b, "\n\n[Attached image %q cannot be shown to this model]", a.Name)
+				continue
+			}
+			image, err := loadAttachedImage(a)
+			if err != nil {
+				log.Printf("Failed to load attached image %d: %v", a.FileID, err)
+				fmt.Fprintf(&b, "\n\n[Attached image %q is no longer available]", a.Name)
+				continue
+			}
+			images = append(images, image)
+		case models.AttachmentDocument:
+			text, inline := attachmentText(a)
+			if !inline {
+				fmt.Fprintf(&b, "\n\n[Attached file %q is too long to include; relevant passages are given as context]", a.Name)
+				continue
+			}
+			fmt.Fprintf(&b, "\n\n<attachment name=%q>\n%s\n</attachment>", a.Name, text)
+		}
+	}
+	return b.String(), images
+}
+
+// loadAttachedImage reads an image attachment and encodes it as base64
+func loadAttachedImage(a models.Attachment) (attachedImage, error) {
+	var file models.File
+	if result := database.DB.First(&file, a.FileID); result.Error != nil {
+		return attachedImage{}, result.Error
+	}
+	rc, err := OpenFileContent(file)
+	if err != nil {
+		return attachedImage{}, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxImageAttachmentSize+1))
+	if err != nil {
+		return attachedImage{}, err
+	}
+	if len(data) > maxImageAttachmentSize {
+		return attachedImage{}, fmt.Errorf("image is larger than %d bytes", maxImageAttachmentSize)
+	}
+	return attachedImage{MimeType: file.MimeType, Data: base64.StdEncoding.EncodeToString(data)}, nil
+}
+
+// OllamaMessages converts messages for the Ollama API, with images as base64
+// images when the model has vision and with their tool calls
+func OllamaMessages(messages []models.Message, vision bool) []models.OllamaMessage {
+	converted := make([]models.OllamaMessage, len(messages))
+	for i, m := range messages {
+		content, images := renderAttachments(m, vision)
+		converted[i] = models.OllamaMessage{Role: m.Role, Content: content, ToolCalls: m.ToolCalls, ToolName: m.ToolName}
+		for _, image := range images {
+			converted[i].Images = append(converted[i].Images, image.Data)
+		}
+	}
+	return converted
+}
+
+// OpenAIMessages converts messages for the OpenAI API, with images as
+// image_url content parts when the model has vision and with their tool calls
+func OpenAIMessages(messages []models.Message, vision bool) []models.OpenAIMessage {
+	converted := make([]models.OpenAIMessage, len(messages))
+	for i, m := range messages {
+		content, images := renderAttachments(m, vision)
+		converted[i] = models.OpenAIMessage{Role: m.Role, Content: content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			var openaiCall models.OpenAIToolCall
+			openaiCall.ID, openaiCall.Type = call.ID, "function"
+			openaiCall.Function.Name, openaiCall.Function.Arguments = call.Function.Name, string(call.Function.Arguments)
+			converted[i].ToolCalls = append(converted[i].ToolCalls, openaiCall)
+		}
+		if len(images) == 0 {
+			continue
+		}
+		parts := []models.OpenAIContentPart{{Type: "text", Text: content}}
+		for _, image := range images {
+			parts = append(parts, models.OpenAIContentPart{
+				Type:     "image_url",
+				ImageURL: &models.OpenAIImageURL{URL: "data:" + image.MimeType + ";base64," + image.Data},
+			})
+		}
+		converted[i].Content = parts
+	}
+	return converted
+}
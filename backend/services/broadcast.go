@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"backend/config"
+	"backend/database"
+)
+
+// BroadcastEvent is a room event fanned out to every backend instance. Seq is
+// a per-room, monotonically increasing sequence number shared by all
+// instances; it is 0 for ephemeral events that are not replayed.
+type BroadcastEvent struct {
+	Room    string          `json:"room"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+	Seq     int64           `json:"seq,omitempty"`
+}
+
+// PubSub carries broadcast events between backend instances. Every subscriber,
+// including the one on the publishing instance, receives each published event.
+type PubSub interface {
+	// Publish sends an event to all subscribers
+	Publish(ctx context.Context, ev BroadcastEvent) error
+	// NextSeq allocates the next sequence number of a room
+	NextSeq(ctx context.Context, room string) (int64, error)
+	// Subscribe registers a handler that is called for every received event
+	Subscribe(handler func(BroadcastEvent)) error
+	// Close stops delivery and releases the underlying connections
+	Close() error
+}
+
+// ephemeralEvents are not sequenced or logged; replaying them after a
+// reconnect would show stale state
+var ephemeralEvents = map[string]bool{
+	"typing":              true,
+	"presence":            true,
+	"knowledgeFileStatus": true,
+	"knowledgeReindex":    true,
+	"chatAccessRevoked":   true,
+}
+
+// RoomBroadcaster implements handlers.SocketIORoomBroadcaster on top of a
+// PubSub: events are published to every instance, and each instance hands
+// the events it receives to its own sockets through deliver. Non-ephemeral
+// events are sequenced and kept in a bounded per-room log for replay.
+type RoomBroadcaster struct {
+	pubsub  PubSub
+	log     *RoomEventLog
+	deliver func(ev BroadcastEvent)
+}
+
+// NewRoomBroadcaster subscribes to pubsub and returns a broadcaster that
+// delivers received events to local sockets through deliver. logSize bounds
+// the number of events kept per room for replay.
+func NewRoomBroadcaster(pubsub PubSub, logSize int, deliver func(ev BroadcastEvent)) (*RoomBroadcaster, error) {
+	b := &RoomBroadcaster{pubsub: pubsub, log: NewRoomEventLog(logSize), deliver: deliver}
+	if err := pubsub.Subscribe(b.receive); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to broadcast events: %w", err)
+	}
+	return b, nil
+}
+
+// BroadcastToRoom publishes an event for a room to every instance. If the
+// event cannot be published it is still delivered to local sockets.
+func (b *RoomBroadcaster) BroadcastToRoom(room string, event string, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshaling %s event for %s: %v", event, room, err)
+		return
+	}
+
+	ev := BroadcastEvent{Room: room, Event: event, Payload: payload}
+	if !ephemeralEvents[event] {
+		if ev.Seq, err = b.pubsub.NextSeq(context.Background(), room); err != nil {
+			log.Printf("Error allocating sequence number for %s event in %s: %v", event, room, err)
+		}
+	}
+
+	if err := b.pubsub.Publish(context.Background(), ev); err != nil {
+		log.Printf("Error publishing %s event for %s, delivering locally only: %v", event, room, err)
+		b.receive(ev)
+	}
+}
+
+// Replay returns the sequenced events of a room after lastSeq. When complete
+// is false some events are no longer available and the client must reload.
+func (b *RoomBroadcaster) Replay(room string, lastSeq int64) (events []BroadcastEvent, complete bool) {
+	return b.log.Since(room, lastSeq)
+}
+
+// LatestSeq returns the highest sequence number this instance has seen for a room
+func (b *RoomBroadcaster) LatestSeq(room string) int64 {
+	return b.log.LatestSeq(room)
+}
+
+func (b *RoomBroadcaster) receive(ev BroadcastEvent) {
+	if ev.Seq > 0 {
+		b.log.Append(ev)
+	}
+	b.deliver(ev)
+}
+
+// Close closes the underlying PubSub
+func (b *RoomBroadcaster) Close() error {
+	return b.pubsub.Close()
+}
+
+// NewPubSubFromConfig creates the PubSub selected by BROADCAST_BACKEND:
+// "memory" (default, single instance), "redis" (REDIS_URL) or "postgres"
+// (LISTEN/NOTIFY on the application database). BROADCAST_CHANNEL overrides
+// the channel name shared by all instances.
+func NewPubSubFromConfig() (PubSub, error) {
+	channel := config.Config("BROADCAST_CHANNEL")
+	if channel == "" {
+		channel = "webui_broadcast"
+	}
+
+	switch backend := config.Config("BROADCAST_BACKEND"); backend {
+	case "", "memory":
+		return NewInProcessPubSub(), nil
+	case "redis":
+		url := config.Config("REDIS_URL")
+		if url == "" {
+			return nil, fmt.Errorf("REDIS_URL is not set")
+		}
+		return NewRedisPubSub(url, channel), nil
+	case "postgres":
+		return NewPostgresPubSub(database.DB, database.DSN(), channel)
+	default:
+		return nil, fmt.Errorf("unknown BROADCAST_BACKEND %q", backend)
+	}
+}
+
+// InProcessPubSub delivers events synchronously to subscribers in the same
+// process. It is the default for single-instance deployments.
+type InProcessPubSub struct {
+	mu       sync.RWMutex
+	handlers []func(BroadcastEvent)
+	closed   bool
+
+	seqMu sync.Mutex
+	seqs  map[string]int64
+}
+
+// NewInProcessPubSub creates an InProcessPubSub
+func NewInProcessPubSub() *InProcessPubSub {
+	return &InProcessPubSub{seqs: make(map[string]int64)}
+}
+
+// NextSeq increments the room's in-memory counter
+func (p *InProcessPubSub) NextSeq(ctx context.Context, room string) (int64, error) {
+	p.seqMu.Lock()
+	defer p.seqMu.Unlock()
+
+	p.seqs[room]++
+	return p.seqs[room], nil
+}
+
+// Publish calls every subscribed handler with the event
+func (p *InProcessPubSub) Publish(ctx context.Context, ev BroadcastEvent) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return fmt.Errorf("pubsub is closed")
+	}
+	for _, handler := range p.handlers {
+		handler(ev)
+	}
+	return nil
+}
+
+// Subscribe registers a handler
+func (p *InProcessPubSub) Subscribe(handler func(BroadcastEvent)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("pubsub is closed")
+	}
+	p.handlers = append(p.handlers, handler)
+	return nil
+}
+
+// Close stops delivery to all handlers
+func (p *InProcessPubSub) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.handlers = nil
+	return nil
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// pgNotifyMaxPayload keeps NOTIFY payloads under PostgreSQL's 8000 byte limit.
+// Larger events are stored in broadcast_overflow and only their ID is sent.
+const pgNotifyMaxPayload = 7900
+
+// overflowPrefix marks a NOTIFY payload that references a broadcast_overflow row
+const overflowPrefix = "@"
+
+// PostgresPubSub fans broadcast events out through PostgreSQL LISTEN/NOTIFY
+type PostgresPubSub struct {
+	db      *gorm.DB
+	dsn     string
+	channel string
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewPostgresPubSub creates a PostgresPubSub. Events are published with db
+// and received on a dedicated connection opened from dsn.
+func NewPostgresPubSub(db *gorm.DB, dsn string, channel string) (*PostgresPubSub, error) {
+	if result := db.Exec(`CREATE TABLE IF NOT EXISTS broadcast_overflow (
+		id BIGSERIAL PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); result.Error != nil {
+		return nil, fmt.Errorf("failed to create broadcast_overflow table: %w", result.Error)
+	}
+	if result := db.Exec(`CREATE TABLE IF NOT EXISTS broadcast_room_seq (
+		room TEXT PRIMARY KEY,
+		seq BIGINT NOT NULL
+	)`); result.Error != nil {
+		return nil, fmt.Errorf("failed to create broadcast_room_seq table: %w", result.Error)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &PostgresPubSub{db: db, dsn: dsn, channel: channel, ctx: ctx, cancel: cancel}, nil
+}
+
+// Publish sends the event with pg_notify, spilling large events to broadcast_overflow
+func (p *PostgresPubSub) Publish(ctx context.Context, ev BroadcastEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast event: %w", err)
+	}
+
+	payload := string(data)
+	if len(data) > pgNotifyMaxPayload {
+		var id int64
+		if result := p.db.WithContext(ctx).Raw("INSERT INTO broadcast_overflow (payload) VALUES (?) RETURNING id", payload).Scan(&id); result.Error != nil {
+			return fmt.Errorf("failed to store large broadcast event: %w", result.Error)
+		}
+		// Listeners fetch overflow rows right away, so old ones can go
+		p.db.WithContext(ctx).Exec("DELETE FROM broadcast_overflow WHERE created_at < now() - interval '5 minutes'")
+		payload = overflowPrefix + strconv.FormatInt(id, 10)
+	}
+
+	if result := p.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", p.channel, payload); result.Error != nil {
+		return fmt.Errorf("failed to notify: %w", result.Error)
+	}
+	return nil
+}
+
+// NextSeq increments the room's counter in broadcast_room_seq, shared by all instances
+func (p *PostgresPubSub) NextSeq(ctx context.Context, room string) (int64, error) {
+	var seq int64
+	result := p.db.WithContext(ctx).Raw(`INSERT INTO broadcast_room_seq (room, seq) VALUES (?, 1)
+		ON CONFLICT (room) DO UPDATE SET seq = broadcast_room_seq.seq + 1
+		RETURNING seq`, room).Scan(&seq)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to increment sequence: %w", result.Error)
+	}
+	return seq, nil
+}
+
+// Subscribe checks that a listening connection can be opened, then listens
+// in the background, reconnecting with backoff until Close is called
+func (p *PostgresPubSub) Subscribe(handler func(BroadcastEvent)) error {
+	conn, err := p.listen()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		backoff := time.Second
+		for {
+			if conn != nil {
+				err = p.receive(conn, handler)
+				conn.Close(context.Background())
+				conn = nil
+			}
+			if p.ctx.Err() != nil {
+				return
+			}
+			log.Printf("Postgres broadcast subscription lost, retrying in %s: %v", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-p.ctx.Done():
+				return
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			if conn, err = p.listen(); err == nil {
+				backoff = time.Second
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *PostgresPubSub) listen() (*pgx.Conn, error) {
+	conn, err := pgx.Connect(p.ctx, p.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open listen connection: %w", err)
+	}
+	if _, err := conn.Exec(p.ctx, "LISTEN "+pgx.Identifier{p.channel}.Sanitize()); err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("failed to listen on %s: %w", p.channel, err)
+	}
+	return conn, nil
+}
+
+func (p *PostgresPubSub) receive(conn *pgx.Conn, handler func(BroadcastEvent)) error {
+	for {
+		notification, err := conn.WaitForNotification(p.ctx)
+		if err != nil {
+			return err
+		}
+
+		data := notification.Payload
+		if strings.HasPrefix(data, overflowPrefix) {
+			id, err := strconv.ParseInt(strings.TrimPrefix(data, overflowPrefix), 10, 64)
+			if err != nil {
+				log.Printf("Discarding malformed broadcast overflow reference %q", data)
+				continue
+			}
+			if err := conn.QueryRow(p.ctx, "SELECT payload FROM broadcast_overflow WHERE id = $1", id).Scan(&data); err != nil {
+				log.Printf("Failed to load broadcast overflow event %d: %v", id, err)
+				continue
+			}
+		}
+
+		var ev BroadcastEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			log.Printf("Discarding malformed broadcast event from Postgres: %v", err)
+			continue
+		}
+		handler(ev)
+	}
+}
+
+// Close stops listening
+func (p *PostgresPubSub) Close() error {
+	p.cancel()
+	return nil
+}
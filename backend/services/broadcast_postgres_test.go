@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// newTestPostgresPubSub connects to the database in TEST_DATABASE_DSN, e.g.
+// "host=localhost user=postgres password=postgres dbname=test sslmode=disable",
+// and skips the test when it is not set
+func newTestPostgresPubSub(t *testing.T) *PostgresPubSub {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn))
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	channel := fmt.Sprintf("test_broadcast_%d", time.Now().UnixNano())
+	p, err := NewPostgresPubSub(db, dsn, channel)
+	if err != nil {
+		t.Fatalf("NewPostgresPubSub: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func subscribeTestEvents(t *testing.T, p PubSub) <-chan BroadcastEvent {
+	t.Helper()
+	events := make(chan BroadcastEvent, 16)
+	if err := p.Subscribe(func(ev BroadcastEvent) { events <- ev }); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return events
+}
+
+func receiveTestEvent(t *testing.T, events <-chan BroadcastEvent) BroadcastEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for broadcast event")
+		return BroadcastEvent{}
+	}
+}
+
+func TestPostgresPubSubDeliversEvents(t *testing.T) {
+	p := newTestPostgresPubSub(t)
+	events := subscribeTestEvents(t, p)
+
+	sent := BroadcastEvent{Room: "chat:1", Event: "message", Payload: []byte(`{"content":"hello"}`), Seq: 3}
+	if err := p.Publish(context.Background(), sent); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	got := receiveTestEvent(t, events)
+	if got.Room != sent.Room || got.Event != sent.Event || got.Seq != sent.Seq || string(got.Payload) != string(sent.Payload) {
+		t.Errorf("received %+v, want %+v", got, sent)
+	}
+}
+
+func TestPostgresPubSubDeliversLargeEventsThroughOverflow(t *testing.T) {
+	p := newTestPostgresPubSub(t)
+	events := subscribeTestEvents(t, p)
+
+	content := strings.Repeat("x", 2*pgNotifyMaxPayload)
+	sent := BroadcastEvent{Room: "chat:1", Event: "message", Payload: []byte(`{"content":"` + content + `"}`)}
+	if err := p.Publish(context.Background(), sent); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	got := receiveTestEvent(t, events)
+	if string(got.Payload) != string(sent.Payload) {
+		t.Errorf("received payload of %d bytes, want %d bytes", len(got.Payload), len(sent.Payload))
+	}
+}
+
+func TestPostgresPubSubNextSeq(t *testing.T) {
+	p := newTestPostgresPubSub(t)
+	room := fmt.Sprintf("chat:%d", time.Now().UnixNano())
+
+	for want := int64(1); want <= 3; want++ {
+		seq, err := p.NextSeq(context.Background(), room)
+		if err != nil {
+			t.Fatalf("NextSeq: %v", err)
+		}
+		if seq != want {
+			t.Errorf("NextSeq = %d, want %d", seq, want)
+		}
+	}
+}
+
+func TestPostgresPubSubStopsDeliveryOnClose(t *testing.T) {
+	p := newTestPostgresPubSub(t)
+	events := subscribeTestEvents(t, p)
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// Publishing uses the shared pool, which stays open; the listener does not
+	if err := p.Publish(context.Background(), BroadcastEvent{Room: "chat:1", Event: "message"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("received %+v after Close", ev)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisPubSub fans broadcast events out through a Redis PUBLISH/SUBSCRIBE channel
+type RedisPubSub struct {
+	pool    *redis.Pool
+	channel string
+
+	mu     sync.Mutex
+	subs   map[*redis.PubSubConn]bool // Open subscription connections, closed by Close
+	closed bool
+}
+
+// NewRedisPubSub creates a RedisPubSub connecting to url (redis://...)
+func NewRedisPubSub(url string, channel string) *RedisPubSub {
+	return &RedisPubSub{
+		channel: channel,
+		subs:    make(map[*redis.PubSubConn]bool),
+		pool: &redis.Pool{
+			MaxIdle:     4,
+			IdleTimeout: 4 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(url)
+			},
+		},
+	}
+}
+
+// Publish sends the event on the Redis channel
+func (p *RedisPubSub) Publish(ctx context.Context, ev BroadcastEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast event: %w", err)
+	}
+
+	conn, err := p.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("PUBLISH", p.channel, data); err != nil {
+		return fmt.Errorf("failed to publish to Redis: %w", err)
+	}
+	return nil
+}
+
+// NextSeq increments the room's counter in Redis, shared by all instances
+func (p *RedisPubSub) NextSeq(ctx context.Context, room string) (int64, error) {
+	conn, err := p.pool.GetContext(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get Redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	seq, err := redis.Int64(conn.Do("INCR", p.channel+":seq:"+room))
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment sequence in Redis: %w", err)
+	}
+	return seq, nil
+}
+
+// Subscribe starts a background subscription that reconnects with backoff
+// until Close is called
+func (p *RedisPubSub) Subscribe(handler func(BroadcastEvent)) error {
+	// Fail fast on a bad configuration instead of retrying forever
+	conn := p.pool.Get()
+	_, err := conn.Do("PING")
+	conn.Close()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	go func() {
+		backoff := time.Second
+		for {
+			err := p.receive(handler)
+			if p.isClosed() {
+				return
+			}
+			log.Printf("Redis broadcast subscription lost, retrying in %s: %v", backoff, err)
+			time.Sleep(backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *RedisPubSub) receive(handler func(BroadcastEvent)) error {
+	// A connection of its own rather than one of the pool: closing a pooled
+	// connection unsubscribes and waits for the reply, which this loop would
+	// consume, while closing this one ends Receive right away
+	conn, err := p.pool.Dial()
+	if err != nil {
+		return err
+	}
+	psc := &redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.subs[psc] = true
+	p.mu.Unlock()
+	// A lost subscription is replaced by a new one on reconnect
+	defer func() {
+		p.mu.Lock()
+		delete(p.subs, psc)
+		p.mu.Unlock()
+	}()
+
+	if err := psc.Subscribe(p.channel); err != nil {
+		return err
+	}
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			var ev BroadcastEvent
+			if err := json.Unmarshal(v.Data, &ev); err != nil {
+				log.Printf("Discarding malformed broadcast event from Redis: %v", err)
+				continue
+			}
+			handler(ev)
+		case error:
+			return v
+		}
+	}
+}
+
+func (p *RedisPubSub) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Close ends the subscription and closes the connection pool
+func (p *RedisPubSub) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	subs := p.subs
+	p.subs = make(map[*redis.PubSubConn]bool)
+	p.mu.Unlock()
+
+	for psc := range subs {
+		psc.Close()
+	}
+	return p.pool.Close()
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis speaks enough of the Redis protocol for RedisPubSub: PING,
+// PUBLISH, SUBSCRIBE, the commands the pool sends when closing a subscribed
+// connection, and INCR. dropSubscribers closes every subscribed
+// connection to simulate a lost subscription.
+type fakeRedis struct {
+	listener net.Listener
+
+	mu          sync.Mutex
+	subscribers map[net.Conn]bool
+	counters    map[string]int64
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	r := &fakeRedis{listener: listener, subscribers: make(map[net.Conn]bool), counters: make(map[string]int64)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) URL() string {
+	return "redis://" + r.listener.Addr().String()
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer func() {
+		r.mu.Lock()
+		delete(r.subscribers, conn)
+		r.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(reader)
+		if err != nil {
+			return
+		}
+
+		r.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			io.WriteString(conn, "+PONG\r\n")
+		case "SUBSCRIBE":
+			r.subscribers[conn] = true
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+			// Sent by the pool when a subscribed connection is closed
+			delete(r.subscribers, conn)
+			kind := strings.ToLower(args[0])
+			fmt.Fprintf(conn, "*3\r\n$%d\r\n%s\r\n$-1\r\n:0\r\n", len(kind), kind)
+		case "ECHO":
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(args[1]), args[1])
+		case "PUBLISH":
+			for sub := range r.subscribers {
+				fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2])
+			}
+			fmt.Fprintf(conn, ":%d\r\n", len(r.subscribers))
+		case "INCR":
+			r.counters[args[1]]++
+			fmt.Fprintf(conn, ":%d\r\n", r.counters[args[1]])
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command %q\r\n", args[0])
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *fakeRedis) subscriberCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.subscribers)
+}
+
+func (r *fakeRedis) dropSubscribers() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for conn := range r.subscribers {
+		conn.Close()
+		delete(r.subscribers, conn)
+	}
+}
+
+// readRESPCommand reads a command sent as an array of bulk strings
+func readRESPCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("malformed command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("malformed argument %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisPubSubDeliversEvents(t *testing.T) {
+	server := newFakeRedis(t)
+	p := NewRedisPubSub(server.URL(), "test")
+	defer p.Close()
+	events := subscribeTestEvents(t, p)
+	waitFor(t, "subscription", func() bool { return server.subscriberCount() == 1 })
+
+	sent := BroadcastEvent{Room: "chat:1", Event: "message", Payload: []byte(`{"content":"hello"}`), Seq: 7}
+	if err := p.Publish(context.Background(), sent); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	got := receiveTestEvent(t, events)
+	if got.Room != sent.Room || got.Event != sent.Event || got.Seq != sent.Seq || string(got.Payload) != string(sent.Payload) {
+		t.Errorf("received %+v, want %+v", got, sent)
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		if seq, err := p.NextSeq(context.Background(), "chat:1"); err != nil || seq != want {
+			t.Errorf("NextSeq = %d, %v, want %d", seq, err, want)
+		}
+	}
+}
+
+func TestRedisPubSubReplacesLostSubscriptions(t *testing.T) {
+	server := newFakeRedis(t)
+	p := NewRedisPubSub(server.URL(), "test")
+	defer p.Close()
+	events := subscribeTestEvents(t, p)
+	waitFor(t, "subscription", func() bool { return server.subscriberCount() == 1 })
+
+	// The first retry waits a second, the second one two
+	for i := 0; i < 2; i++ {
+		server.dropSubscribers()
+		waitFor(t, "resubscription", func() bool { return server.subscriberCount() == 1 })
+	}
+
+	p.mu.Lock()
+	open := len(p.subs)
+	p.mu.Unlock()
+	if open != 1 {
+		t.Errorf("RedisPubSub tracks %d subscription connections after reconnecting, want 1", open)
+	}
+
+	if err := p.Publish(context.Background(), BroadcastEvent{Room: "chat:1", Event: "message"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	receiveTestEvent(t, events)
+}
+
+func TestRedisPubSubCloseEndsSubscription(t *testing.T) {
+	server := newFakeRedis(t)
+	p := NewRedisPubSub(server.URL(), "test")
+	subscribeTestEvents(t, p)
+	waitFor(t, "subscription", func() bool { return server.subscriberCount() == 1 })
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitFor(t, "the subscription to close", func() bool { return server.subscriberCount() == 0 })
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/models"
+)
+
+// NewChatExport wraps exported chats in a versioned ChatExport document
+func NewChatExport(chats []models.ChatExportChat) models.ChatExport {
+	return models.ChatExport{
+		Version:    models.ChatExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Chats:      chats,
+	}
+}
+
+// ExportChat converts a chat and its messages, including every branch, into the export format
+func ExportChat(chat models.Chat, messages []models.Message) models.ChatExportChat {
+	exported := models.ChatExportChat{
+		ID:        strconv.FormatUint(uint64(chat.ID), 10),
+		Title:     chat.Title,
+		CreatedAt: chat.CreatedAt,
+		UpdatedAt: chat.UpdatedAt,
+		Messages:  make([]models.ChatExportMessage, 0, len(messages)),
+	}
+
+	for _, m := range messages {
+		em := models.ChatExportMessage{
+			ID:        strconv.FormatUint(uint64(m.ID), 10),
+			Role:      m.Role,
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt,
+		}
+		if m.ParentID != nil {
+			em.ParentID = strconv.FormatUint(uint64(*m.ParentID), 10)
+		}
+		exported.Messages = append(exported.Messages, em)
+	}
+
+	return exported
+}
+
+// RemapChatExportIDs replaces the chat and message IDs of an exported chat
+// with IDs local to it ("1", "2", ... in message order), keeping the branch
+// structure, so that a copy given to others does not reveal database IDs.
+// Parents that are not in the chat are dropped.
+func RemapChatExportIDs(chat models.ChatExportChat) models.ChatExportChat {
+	localIDs := make(map[string]string, len(chat.Messages))
+	for i, m := range chat.Messages {
+		localIDs[m.ID] = strconv.Itoa(i + 1)
+	}
+
+	remapped := chat
+	remapped.ID = ""
+	remapped.Messages = make([]models.ChatExportMessage, len(chat.Messages))
+	for i, m := range chat.Messages {
+		m.ID = localIDs[m.ID]
+		m.ParentID = localIDs[m.ParentID]
+		remapped.Messages[i] = m
+	}
+	return remapped
+}
+
+// RenderChatMarkdown renders an exported chat as a Markdown document. Messages
+// are written in creation order; a message that does not follow the one
+// rendered right before it is marked as a branch of its parent.
+func RenderChatMarkdown(chat models.ChatExportChat) string {
+	var b strings.Builder
+
+	title := chat.Title
+	if strings.TrimSpace(title) == "" {
+		title = "Untitled chat"
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	if !chat.CreatedAt.IsZero() {
+		fmt.Fprintf(&b, "_Created %s_\n\n", chat.CreatedAt.UTC().Format(time.RFC3339))
+	}
+
+	previousID := ""
+	for _, m := range chat.Messages {
+		fmt.Fprintf(&b, "## %s\n\n", roleHeading(m.Role))
+		if m.ParentID != "" && m.ParentID != previousID {
+			fmt.Fprintf(&b, "> Branch from message %s\n\n", m.ParentID)
+		}
+		b.WriteString(strings.TrimSpace(m.Content))
+		b.WriteString("\n\n")
+		previousID = m.ID
+	}
+
+	return b.String()
+}
+
+// WriteChatExportZip writes a ZIP bundle holding chats.json plus one Markdown file per chat
+func WriteChatExportZip(w io.Writer, export models.ChatExport) error {
+	zw := zip.NewWriter(w)
+
+	jsonFile, err := zw.Create("chats.json")
+	if err != nil {
+		return fmt.Errorf("failed to create chats.json in bundle: %w", err)
+	}
+	encoder := json.NewEncoder(jsonFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return fmt.Errorf("failed to write chats.json to bundle: %w", err)
+	}
+
+	for _, chat := range export.Chats {
+		mdFile, err := zw.Create(fmt.Sprintf("markdown/%s.md", ChatExportFilename(chat)))
+		if err != nil {
+			return fmt.Errorf("failed to create markdown file in bundle: %w", err)
+		}
+		if _, err := io.WriteString(mdFile, RenderChatMarkdown(chat)); err != nil {
+			return fmt.Errorf("failed to write markdown file to bundle: %w", err)
+		}
+	}
+
+	return zw.Close()
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ChatExportFilename returns a filesystem-safe base name (without extension) for an exported chat
+func ChatExportFilename(chat models.ChatExportChat) string {
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(chat.Title), "-"), "-")
+	if len(slug) > 60 {
+		slug = strings.Trim(slug[:60], "-")
+	}
+	if slug == "" {
+		slug = "chat"
+	}
+	if chat.ID == "" {
+		return slug
+	}
+	return chat.ID + "-" + slug
+}
+
+func roleHeading(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "system":
+		return "System"
+	case "tool":
+		return "Tool"
+	default:
+		return role
+	}
+}
@@ -0,0 +1,493 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"backend/models"
+)
+
+// Supported chat import formats
+const (
+	ChatImportFormatAuto      = "auto"
+	ChatImportFormatNative    = "webui-go"
+	ChatImportFormatChatGPT   = "chatgpt"
+	ChatImportFormatOpenWebUI = "openwebui"
+)
+
+var importableRoles = map[string]bool{
+	"system":    true,
+	"user":      true,
+	"assistant": true,
+	"tool":      true,
+}
+
+// ParseChatImport decodes an export document into chats. When format is empty
+// or "auto" the format is detected from the document's shape. The returned
+// format is the one that was actually used.
+func ParseChatImport(data []byte, format string) ([]models.ChatExportChat, string, error) {
+	if format == "" || format == ChatImportFormatAuto {
+		detected, err := DetectChatImportFormat(data)
+		if err != nil {
+			return nil, "", err
+		}
+		format = detected
+	}
+
+	var (
+		chats []models.ChatExportChat
+		err   error
+	)
+	switch format {
+	case ChatImportFormatNative:
+		chats, err = parseNativeImport(data)
+	case ChatImportFormatChatGPT:
+		chats, err = parseChatGPTImport(data)
+	case ChatImportFormatOpenWebUI:
+		chats, err = parseOpenWebUIImport(data)
+	default:
+		return nil, format, fmt.Errorf("unsupported import format %q", format)
+	}
+	if err != nil {
+		return nil, format, err
+	}
+	return chats, format, nil
+}
+
+// DetectChatImportFormat guesses which tool produced an export document
+func DetectChatImportFormat(data []byte) (string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return "", fmt.Errorf("import document is empty")
+	}
+
+	var sample map[string]json.RawMessage
+	switch trimmed[0] {
+	case '{':
+		if err := json.Unmarshal(trimmed, &sample); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+	case '[':
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		if len(items) == 0 {
+			return "", fmt.Errorf("import document contains no chats")
+		}
+		sample = items[0]
+	default:
+		return "", fmt.Errorf("import document must be a JSON object or array")
+	}
+
+	switch {
+	case sample["chats"] != nil || sample["version"] != nil:
+		return ChatImportFormatNative, nil
+	case sample["mapping"] != nil:
+		return ChatImportFormatChatGPT, nil
+	case sample["chat"] != nil || sample["history"] != nil:
+		return ChatImportFormatOpenWebUI, nil
+	case sample["messages"] != nil:
+		return ChatImportFormatNative, nil
+	}
+	return "", fmt.Errorf("could not detect import format")
+}
+
+// ValidateChatImport checks imported chats and orders each chat's messages so
+// that parents always come before their replies. Chats that cannot be imported
+// are dropped and reported as errors; recoverable problems are reported as warnings.
+func ValidateChatImport(chats []models.ChatExportChat) (valid []models.ChatExportChat, errs []string, warnings []string) {
+	for i, chat := range chats {
+		label := fmt.Sprintf("chat %d", i+1)
+		if chat.Title != "" {
+			label = fmt.Sprintf("chat %d (%q)", i+1, chat.Title)
+		}
+
+		if strings.TrimSpace(chat.Title) == "" {
+			chat.Title = "Imported chat"
+		}
+
+		if len(chat.Messages) == 0 {
+			errs = append(errs, fmt.Sprintf("%s: has no messages", label))
+			continue
+		}
+
+		kept := make([]models.ChatExportMessage, 0, len(chat.Messages))
+		seen := make(map[string]bool, len(chat.Messages))
+		for j, m := range chat.Messages {
+			if m.ID == "" {
+				m.ID = fmt.Sprintf("import-%d", j)
+			}
+			if seen[m.ID] {
+				warnings = append(warnings, fmt.Sprintf("%s: duplicate message id %q skipped", label, m.ID))
+				continue
+			}
+			if !importableRoles[m.Role] {
+				warnings = append(warnings, fmt.Sprintf("%s: message %q has unsupported role %q and was skipped", label, m.ID, m.Role))
+				continue
+			}
+			seen[m.ID] = true
+			kept = append(kept, m)
+		}
+
+		ordered, orderWarnings := orderMessagesParentFirst(kept)
+		for _, w := range orderWarnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", label, w))
+		}
+		if len(ordered) == 0 {
+			errs = append(errs, fmt.Sprintf("%s: has no importable messages", label))
+			continue
+		}
+
+		chat.Messages = ordered
+		valid = append(valid, chat)
+	}
+	return valid, errs, warnings
+}
+
+// orderMessagesParentFirst returns messages in depth-first tree order, keeping
+// the original order among siblings. Messages whose parent is missing become
+// roots; messages that are only reachable through a cycle are dropped.
+func orderMessagesParentFirst(messages []models.ChatExportMessage) ([]models.ChatExportMessage, []string) {
+	var warnings []string
+
+	byID := make(map[string]models.ChatExportMessage, len(messages))
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+
+	children := make(map[string][]string)
+	var roots []string
+	for _, m := range messages {
+		if m.ParentID == "" {
+			roots = append(roots, m.ID)
+			continue
+		}
+		if _, ok := byID[m.ParentID]; !ok {
+			warnings = append(warnings, fmt.Sprintf("message %q references missing parent %q", m.ID, m.ParentID))
+			m.ParentID = ""
+			byID[m.ID] = m
+			roots = append(roots, m.ID)
+			continue
+		}
+		children[m.ParentID] = append(children[m.ParentID], m.ID)
+	}
+
+	ordered := make([]models.ChatExportMessage, 0, len(messages))
+	visited := make(map[string]bool, len(messages))
+	var visit func(id string)
+	visit = func(id string) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		ordered = append(ordered, byID[id])
+		for _, child := range children[id] {
+			visit(child)
+		}
+	}
+	for _, id := range roots {
+		visit(id)
+	}
+
+	if dropped := len(messages) - len(ordered); dropped > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d message(s) form a parent cycle and were skipped", dropped))
+	}
+	return ordered, warnings
+}
+
+func parseNativeImport(data []byte) ([]models.ChatExportChat, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var chats []models.ChatExportChat
+		if err := json.Unmarshal(trimmed, &chats); err != nil {
+			return nil, fmt.Errorf("invalid chat export: %w", err)
+		}
+		return chats, nil
+	}
+
+	var export models.ChatExport
+	if err := json.Unmarshal(trimmed, &export); err != nil {
+		return nil, fmt.Errorf("invalid chat export: %w", err)
+	}
+	if export.Version > models.ChatExportVersion {
+		return nil, fmt.Errorf("chat export version %d is newer than supported version %d", export.Version, models.ChatExportVersion)
+	}
+	return export.Chats, nil
+}
+
+// chatGPTConversation mirrors an entry of ChatGPT's conversations.json
+type chatGPTConversation struct {
+	ID         string                 `json:"id"`
+	Title      string                 `json:"title"`
+	CreateTime float64                `json:"create_time"`
+	UpdateTime float64                `json:"update_time"`
+	Mapping    map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	Content struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+	CreateTime float64 `json:"create_time"`
+}
+
+func parseChatGPTImport(data []byte) ([]models.ChatExportChat, error) {
+	var conversations []chatGPTConversation
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var single chatGPTConversation
+		if err := json.Unmarshal(trimmed, &single); err != nil {
+			return nil, fmt.Errorf("invalid ChatGPT export: %w", err)
+		}
+		conversations = append(conversations, single)
+	} else if err := json.Unmarshal(trimmed, &conversations); err != nil {
+		return nil, fmt.Errorf("invalid ChatGPT export: %w", err)
+	}
+
+	chats := make([]models.ChatExportChat, 0, len(conversations))
+	for _, conv := range conversations {
+		chat := models.ChatExportChat{
+			ID:        conv.ID,
+			Title:     conv.Title,
+			CreatedAt: unixSeconds(conv.CreateTime),
+			UpdatedAt: unixSeconds(conv.UpdateTime),
+		}
+
+		// ChatGPT trees contain structural nodes without a visible message
+		// (the root, hidden system prompts). They are skipped and their
+		// children are attached to the nearest visible ancestor.
+		visibleAncestor := func(id string) string {
+			for depth := 0; id != "" && depth <= len(conv.Mapping); depth++ {
+				node, ok := conv.Mapping[id]
+				if !ok {
+					return ""
+				}
+				if chatGPTText(node.Message) != "" {
+					return id
+				}
+				id = node.Parent
+			}
+			return ""
+		}
+
+		var walk func(id string, depth int)
+		visited := make(map[string]bool)
+		walk = func(id string, depth int) {
+			node, ok := conv.Mapping[id]
+			if !ok || visited[id] || depth > len(conv.Mapping) {
+				return
+			}
+			visited[id] = true
+			if text := chatGPTText(node.Message); text != "" {
+				chat.Messages = append(chat.Messages, models.ChatExportMessage{
+					ID:        id,
+					ParentID:  visibleAncestor(node.Parent),
+					Role:      node.Message.Author.Role,
+					Content:   text,
+					CreatedAt: unixSeconds(node.Message.CreateTime),
+				})
+			}
+			for _, child := range node.Children {
+				walk(child, depth+1)
+			}
+		}
+		// Walk from the roots oldest first; mapping is a JSON object, so its
+		// order carries no meaning and Go would iterate it randomly
+		var roots []string
+		for id, node := range conv.Mapping {
+			if _, hasParent := conv.Mapping[node.Parent]; node.Parent == "" || !hasParent {
+				roots = append(roots, id)
+			}
+		}
+		sortByCreateTime(roots, func(id string) float64 {
+			if m := conv.Mapping[id].Message; m != nil {
+				return m.CreateTime
+			}
+			return 0
+		})
+		for _, id := range roots {
+			walk(id, 0)
+		}
+
+		chats = append(chats, chat)
+	}
+	return chats, nil
+}
+
+func chatGPTText(m *chatGPTMessage) string {
+	if m == nil {
+		return ""
+	}
+	var parts []string
+	for _, raw := range m.Content.Parts {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil && strings.TrimSpace(s) != "" {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 && strings.TrimSpace(m.Content.Text) != "" {
+		parts = append(parts, m.Content.Text)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// openWebUIChat mirrors an entry of an Open WebUI chat export
+type openWebUIChat struct {
+	ID        string  `json:"id"`
+	Title     string  `json:"title"`
+	CreatedAt float64 `json:"created_at"`
+	UpdatedAt float64 `json:"updated_at"`
+	Chat      struct {
+		Title   string `json:"title"`
+		History struct {
+			Messages  map[string]openWebUIMessage `json:"messages"`
+			CurrentID string                      `json:"currentId"`
+		} `json:"history"`
+		Messages []openWebUIMessage `json:"messages"`
+	} `json:"chat"`
+}
+
+type openWebUIMessage struct {
+	ID          string   `json:"id"`
+	ParentID    *string  `json:"parentId"`
+	ChildrenIDs []string `json:"childrenIds"`
+	Role        string   `json:"role"`
+	Content     string   `json:"content"`
+	Timestamp   float64  `json:"timestamp"`
+}
+
+func parseOpenWebUIImport(data []byte) ([]models.ChatExportChat, error) {
+	var items []openWebUIChat
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var single openWebUIChat
+		if err := json.Unmarshal(trimmed, &single); err != nil {
+			return nil, fmt.Errorf("invalid Open WebUI export: %w", err)
+		}
+		items = append(items, single)
+	} else if err := json.Unmarshal(trimmed, &items); err != nil {
+		return nil, fmt.Errorf("invalid Open WebUI export: %w", err)
+	}
+
+	chats := make([]models.ChatExportChat, 0, len(items))
+	for _, item := range items {
+		title := item.Title
+		if title == "" {
+			title = item.Chat.Title
+		}
+		chat := models.ChatExportChat{
+			ID:        item.ID,
+			Title:     title,
+			CreatedAt: unixSeconds(item.CreatedAt),
+			UpdatedAt: unixSeconds(item.UpdatedAt),
+		}
+
+		history := item.Chat.History.Messages
+		if len(history) > 0 {
+			// Walk the history tree from its roots so siblings keep the order
+			// given by childrenIds; ValidateChatImport handles the rest.
+			visited := make(map[string]bool, len(history))
+			var walk func(id string)
+			walk = func(id string) {
+				m, ok := history[id]
+				if !ok || visited[id] {
+					return
+				}
+				visited[id] = true
+				chat.Messages = append(chat.Messages, openWebUIToExport(id, m))
+				for _, child := range m.ChildrenIDs {
+					walk(child)
+				}
+			}
+			ids := make([]string, 0, len(history))
+			for id := range history {
+				ids = append(ids, id)
+			}
+			sortByCreateTime(ids, func(id string) float64 { return history[id].Timestamp })
+			for _, id := range ids {
+				if m := history[id]; m.ParentID == nil || *m.ParentID == "" {
+					walk(id)
+				}
+			}
+			for _, id := range ids {
+				if !visited[id] {
+					visited[id] = true
+					chat.Messages = append(chat.Messages, openWebUIToExport(id, history[id]))
+				}
+			}
+		} else {
+			previous := ""
+			for i, m := range item.Chat.Messages {
+				id := m.ID
+				if id == "" {
+					id = fmt.Sprintf("message-%d", i)
+				}
+				em := openWebUIToExport(id, m)
+				if m.ParentID == nil {
+					em.ParentID = previous
+				}
+				chat.Messages = append(chat.Messages, em)
+				previous = id
+			}
+		}
+
+		chats = append(chats, chat)
+	}
+	return chats, nil
+}
+
+func openWebUIToExport(id string, m openWebUIMessage) models.ChatExportMessage {
+	em := models.ChatExportMessage{
+		ID:        id,
+		Role:      m.Role,
+		Content:   m.Content,
+		CreatedAt: unixSeconds(m.Timestamp),
+	}
+	if m.ParentID != nil {
+		em.ParentID = *m.ParentID
+	}
+	return em
+}
+
+// sortByCreateTime sorts message IDs by their creation time, then by ID, so
+// that messages read from a JSON object are imported in a stable order
+func sortByCreateTime(ids []string, createTime func(id string) float64) {
+	sort.Slice(ids, func(i, j int) bool {
+		ti, tj := createTime(ids[i]), createTime(ids[j])
+		if ti != tj {
+			return ti < tj
+		}
+		return ids[i] < ids[j]
+	})
+}
+
+// unixSeconds converts a Unix timestamp in seconds (or milliseconds, as some
+// exporters write) to a time. Zero yields the zero time.
+func unixSeconds(ts float64) time.Time {
+	if ts <= 0 {
+		return time.Time{}
+	}
+	if ts > 1e12 {
+		ts /= 1000
+	}
+	sec, frac := math.Modf(ts)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/models"
+)
+
+// messageTree summarizes messages as "id<parent role: content" in order
+func messageTree(messages []models.ChatExportMessage) []string {
+	out := make([]string, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, m.ID+"<"+m.ParentID+" "+m.Role+": "+m.Content)
+	}
+	return out
+}
+
+const chatGPTBranchingExport = `[{
+	"id": "conv-1",
+	"title": "Greetings",
+	"create_time": 1700000000.5,
+	"update_time": 1700000100,
+	"mapping": {
+		"u2": {"id": "u2", "parent": "a1", "children": [], "message": {"author": {"role": "user"}, "content": {"content_type": "text", "parts": ["Bye"]}, "create_time": 1700000003}},
+		"a2": {"id": "a2", "parent": "u1", "children": [], "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Hey there"]}, "create_time": 1700000002}},
+		"root": {"id": "root", "parent": null, "children": ["sys"], "message": null},
+		"sys": {"id": "sys", "parent": "root", "children": ["u1"], "message": {"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]}}},
+		"u1": {"id": "u1", "parent": "sys", "children": ["a1", "a2"], "message": {"author": {"role": "user"}, "content": {"content_type": "text", "parts": ["Hi"]}, "create_time": 1700000000}},
+		"a1": {"id": "a1", "parent": "u1", "children": ["u2"], "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Hello", "there"]}, "create_time": 1700000001}}
+	}
+}]`
+
+const openWebUIBranchingExport = `[{
+	"id": "chat-1",
+	"created_at": 1700000000,
+	"updated_at": 1700000100000,
+	"chat": {
+		"title": "Greetings",
+		"history": {
+			"currentId": "m4",
+			"messages": {
+				"m4": {"id": "m4", "parentId": "m2", "childrenIds": [], "role": "user", "content": "Bye", "timestamp": 1700000003},
+				"m3": {"id": "m3", "parentId": "m1", "childrenIds": [], "role": "assistant", "content": "Hey", "timestamp": 1700000002},
+				"m2": {"id": "m2", "parentId": "m1", "childrenIds": ["m4"], "role": "assistant", "content": "Hello", "timestamp": 1700000001},
+				"m1": {"id": "m1", "parentId": null, "childrenIds": ["m2", "m3"], "role": "user", "content": "Hi", "timestamp": 1700000000}
+			}
+		}
+	}
+}]`
+
+const openWebUIListExport = `{
+	"id": "chat-2",
+	"title": "Flat",
+	"chat": {
+		"messages": [
+			{"id": "first", "role": "user", "content": "Hi"},
+			{"role": "assistant", "content": "Hello"}
+		]
+	}
+}`
+
+func TestDetectChatImportFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "native export", data: `{"version": 1, "chats": []}`, want: ChatImportFormatNative},
+		{name: "native chat list", data: `[{"title": "a", "messages": []}]`, want: ChatImportFormatNative},
+		{name: "ChatGPT", data: chatGPTBranchingExport, want: ChatImportFormatChatGPT},
+		{name: "Open WebUI history", data: openWebUIBranchingExport, want: ChatImportFormatOpenWebUI},
+		{name: "Open WebUI single chat", data: openWebUIListExport, want: ChatImportFormatOpenWebUI},
+		{name: "empty", data: "  ", wantErr: true},
+		{name: "empty array", data: "[]", wantErr: true},
+		{name: "not an object", data: "42", wantErr: true},
+		{name: "unknown shape", data: `{"foo": 1}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectChatImportFormat([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("format = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("format = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseChatImport(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		format      string
+		wantFormat  string
+		wantTitle   string
+		wantCreated time.Time
+		wantUpdated time.Time
+		want        []string
+	}{
+		{
+			// Hidden nodes are skipped, their children attach to the nearest
+			// visible ancestor and each branch is kept whole
+			name:        "ChatGPT branching conversation",
+			data:        chatGPTBranchingExport,
+			wantFormat:  ChatImportFormatChatGPT,
+			wantTitle:   "Greetings",
+			wantCreated: time.Unix(1700000000, 5e8).UTC(),
+			wantUpdated: time.Unix(1700000100, 0).UTC(),
+			want: []string{
+				"u1< user: Hi",
+				"a1<u1 assistant: Hello\nthere",
+				"u2<a1 user: Bye",
+				"a2<u1 assistant: Hey there",
+			},
+		},
+		{
+			name:        "Open WebUI branching history",
+			data:        openWebUIBranchingExport,
+			wantFormat:  ChatImportFormatOpenWebUI,
+			wantTitle:   "Greetings",
+			wantCreated: time.Unix(1700000000, 0).UTC(),
+			wantUpdated: time.Unix(1700000100, 0).UTC(),
+			want: []string{
+				"m1< user: Hi",
+				"m2<m1 assistant: Hello",
+				"m4<m2 user: Bye",
+				"m3<m1 assistant: Hey",
+			},
+		},
+		{
+			name:       "Open WebUI message list",
+			data:       openWebUIListExport,
+			format:     ChatImportFormatOpenWebUI,
+			wantFormat: ChatImportFormatOpenWebUI,
+			wantTitle:  "Flat",
+			want: []string{
+				"first< user: Hi",
+				"message-1<first assistant: Hello",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chats, format, err := ParseChatImport([]byte(tt.data), tt.format)
+			if err != nil {
+				t.Fatalf("ParseChatImport: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("format = %q, want %q", format, tt.wantFormat)
+			}
+			if len(chats) != 1 {
+				t.Fatalf("got %d chats, want 1", len(chats))
+			}
+			chat := chats[0]
+			if chat.Title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", chat.Title, tt.wantTitle)
+			}
+			if !chat.CreatedAt.Equal(tt.wantCreated) || !chat.UpdatedAt.Equal(tt.wantUpdated) {
+				t.Errorf("times = %v, %v, want %v, %v", chat.CreatedAt, chat.UpdatedAt, tt.wantCreated, tt.wantUpdated)
+			}
+			if got := messageTree(chat.Messages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := ParseChatImport([]byte(`{"version": 99, "chats": []}`), ""); err == nil {
+		t.Error("an export from a newer version was accepted")
+	}
+	if _, _, err := ParseChatImport([]byte(`[]`), "slack"); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+}
+
+func TestValidateChatImportOrdersMessages(t *testing.T) {
+	msg := func(id, parent, role string) models.ChatExportMessage {
+		return models.ChatExportMessage{ID: id, ParentID: parent, Role: role, Content: id}
+	}
+	tests := []struct {
+		name         string
+		messages     []models.ChatExportMessage
+		want         []string
+		wantWarnings []string
+		wantErr      bool
+	}{
+		{
+			name:     "replies before their parents",
+			messages: []models.ChatExportMessage{msg("b", "a", "assistant"), msg("c", "b", "user"), msg("a", "", "user")},
+			want:     []string{"a< user: a", "b<a assistant: b", "c<b user: c"},
+		},
+		{
+			name: "branches keep sibling order",
+			messages: []models.ChatExportMessage{
+				msg("q", "", "user"), msg("r2", "q", "assistant"), msg("f", "r1", "user"), msg("r1", "q", "assistant"),
+			},
+			want: []string{"q< user: q", "r2<q assistant: r2", "r1<q assistant: r1", "f<r1 user: f"},
+		},
+		{
+			name:         "missing parent becomes a root",
+			messages:     []models.ChatExportMessage{msg("a", "gone", "user")},
+			want:         []string{"a< user: a"},
+			wantWarnings: []string{`references missing parent "gone"`},
+		},
+		{
+			name:         "cycle is dropped",
+			messages:     []models.ChatExportMessage{msg("r", "", "user"), msg("x", "y", "user"), msg("y", "x", "assistant")},
+			want:         []string{"r< user: r"},
+			wantWarnings: []string{"2 message(s) form a parent cycle"},
+		},
+		{
+			name:         "unsupported roles and duplicates are skipped",
+			messages:     []models.ChatExportMessage{msg("a", "", "user"), msg("a", "", "assistant"), msg("b", "a", "function")},
+			want:         []string{"a< user: a"},
+			wantWarnings: []string{`duplicate message id "a"`, `unsupported role "function"`},
+		},
+		{
+			name:     "missing IDs are assigned",
+			messages: []models.ChatExportMessage{{Role: "user", Content: "hi"}},
+			want:     []string{"import-0< user: hi"},
+		},
+		{
+			name:     "no importable messages",
+			messages: []models.ChatExportMessage{msg("a", "", "function")},
+			wantErr:  true,
+		},
+		{
+			name:    "no messages",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, errs, warnings := ValidateChatImport([]models.ChatExportChat{{Messages: tt.messages}})
+			if tt.wantErr {
+				if len(valid) != 0 || len(errs) != 1 {
+					t.Errorf("valid = %d chats, errors = %q, want the chat rejected", len(valid), errs)
+				}
+				return
+			}
+			if len(errs) != 0 || len(valid) != 1 {
+				t.Fatalf("valid = %d chats, errors = %q", len(valid), errs)
+			}
+			if valid[0].Title != "Imported chat" {
+				t.Errorf("title = %q, want the default title", valid[0].Title)
+			}
+			if got := messageTree(valid[0].Messages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q\nwant %q", got, tt.want)
+			}
+			if len(warnings) != len(tt.wantWarnings) {
+				t.Fatalf("warnings = %q, want %d", warnings, len(tt.wantWarnings))
+			}
+			for i, want := range tt.wantWarnings {
+				if !strings.Contains(warnings[i], want) {
+					t.Errorf("warning %q lacks %q", warnings[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"backend/config"
+)
+
+// Default chunking parameters, in characters
+const (
+	DefaultChunkSize    = 1000
+	DefaultChunkOverlap = 100
+)
+
+// TextChunk is a piece of extracted text. Start and End are character (rune)
+// offsets into the extracted text; End is exclusive.
+type TextChunk struct {
+	Index   int
+	Content string
+	Start   int
+	End     int
+}
+
+// ChunkSettings resolves chunking parameters. A per-knowledge size wins when
+// positive and a per-knowledge overlap when set, including to 0; otherwise
+// CHUNK_SIZE and CHUNK_OVERLAP apply, then the defaults.
+func ChunkSettings(size int, chunkOverlap *int) (int, int) {
+	if size <= 0 {
+		size, _ = strconv.Atoi(config.Config("CHUNK_SIZE"))
+	}
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	overlap := DefaultChunkOverlap
+	if chunkOverlap != nil && *chunkOverlap >= 0 {
+		overlap = *chunkOverlap
+	} else if v, err := strconv.Atoi(config.Config("CHUNK_OVERLAP")); err == nil && v >= 0 {
+		overlap = v
+	}
+	if overlap >= size {
+		overlap = size / 5
+	}
+	return size, overlap
+}
+
+// ChunkText splits text into chunks of at most size characters, consecutive
+// chunks sharing about overlap characters. Chunks end at the strongest break
+// available in their second half: a paragraph, a line, a sentence or a word.
+func ChunkText(text string, size int, overlap int) []TextChunk {
+	runes := []rune(text)
+	n := len(runes)
+	if size <= 0 || n == 0 {
+		return nil
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	var chunks []TextChunk
+	for pos := 0; pos < n; {
+		end := pos + size
+		if end >= n {
+			end = n
+		} else {
+			end = chunkBreak(runes, pos+size/2, end)
+		}
+
+		start, stop := pos, end
+		for start < stop && unicode.IsSpace(runes[start]) {
+			start++
+		}
+		for stop > start && unicode.IsSpace(runes[stop-1]) {
+			stop--
+		}
+		if stop > start {
+			chunks = append(chunks, TextChunk{
+				Index:   len(chunks),
+				Content: string(runes[start:stop]),
+				Start:   start,
+				End:     stop,
+			})
+		}
+
+		if end >= n {
+			break
+		}
+		next := end - overlap
+		if next <= pos {
+			next = end
+		}
+		// Start the overlap on a word boundary
+		for i := next; i < end; i++ {
+			if unicode.IsSpace(runes[i]) {
+				next = i + 1
+				break
+			}
+			if i-next > 20 {
+				break
+			}
+		}
+		pos = next
+	}
+	return chunks
+}
+
+// chunkBreak returns the best position in [min, max] to end a chunk
+func chunkBreak(runes []rune, min int, max int) int {
+	window := string(runes[min:max])
+	for _, sep := range []string{"\n\n", "\n", ". ", "? ", "! ", "; ", " "} {
+		if i := strings.LastIndex(window, sep); i >= 0 {
+			return min + len([]rune(window[:i])) + len([]rune(sep))
+		}
+	}
+	return max
+}
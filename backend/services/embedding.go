@@ -0,0 +1,266 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"backend/config"
+	"backend/models"
+)
+
+// Embedder turns texts into vectors. Name identifies the engine and model so
+// that vectors produced by different embedders are never mixed in a collection.
+type Embedder interface {
+	Name() string
+	Embed(texts []string) ([][]float32, error)
+}
+
+// DefaultEmbeddingBatchSize is the number of texts sent per embedding request
+// when EMBEDDING_BATCH_SIZE is not set
+const DefaultEmbeddingBatchSize = 32
+
+// NewEmbedderFromConfig creates the embedder selected by EMBEDDING_ENGINE:
+// ollama, openai or hash. Without EMBEDDING_ENGINE, Ollama is used when
+// OLLAMA_BASE_URL is set, then OpenAI when OPENAI_API_KEY is set, and the
+// deterministic hash embedder otherwise.
+func NewEmbedderFromConfig() (Embedder, error) {
+	engine := config.Config("EMBEDDING_ENGINE")
+	if engine == "" {
+		switch {
+		case config.Config("OLLAMA_BASE_URL") != "":
+			engine = "ollama"
+		case config.Config("OPENAI_API_KEY") != "":
+			engine = "openai"
+		default:
+			engine = "hash"
+		}
+	}
+	model := config.Config("EMBEDDING_MODEL")
+
+	switch engine {
+	case "ollama":
+		baseURL := config.Config("OLLAMA_BASE_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("OLLAMA_BASE_URL is not set")
+		}
+		if model == "" {
+			model = "nomic-embed-text"
+		}
+		return &OllamaEmbedder{BaseURL: baseURL, Model: model}, nil
+	case "openai":
+		baseURL := config.Config("OPENAI_API_BASE_URL")
+		apiKey := config.Config("OPENAI_API_KEY")
+		if baseURL == "" {
+			return nil, fmt.Errorf("OPENAI_API_BASE_URL is not set")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY is not set")
+		}
+		if model == "" {
+			model = "text-embedding-3-small"
+		}
+		return &OpenAIEmbedder{BaseURL: baseURL, APIKey: apiKey, Model: model}, nil
+	case "hash":
+		dimensions, _ := strconv.Atoi(config.Config("EMBEDDING_DIMENSIONS"))
+		log.Printf("Using the built-in hash embedder; set EMBEDDING_ENGINE for semantic search")
+		return NewHashEmbedder(dimensions), nil
+	default:
+		return nil, fmt.Errorf("unknown EMBEDDING_ENGINE %q", engine)
+	}
+}
+
+// EmbedBatched embeds texts in batches of EMBEDDING_BATCH_SIZE
+func EmbedBatched(embedder Embedder, texts []string) ([][]float32, error) {
+	batchSize, _ := strconv.Atoi(config.Config("EMBEDDING_BATCH_SIZE"))
+	if batchSize <= 0 {
+		batchSize = DefaultEmbeddingBatchSize
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		end := start + batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := embedder.Embed(texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(batch), end-start)
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// OllamaEmbedder embeds texts with the Ollama /api/embed endpoint
+type OllamaEmbedder struct {
+	BaseURL string
+	Model   string
+}
+
+// Name implements Embedder
+func (e *OllamaEmbedder) Name() string {
+	return "ollama:" + e.Model
+}
+
+// Embed implements Embedder
+func (e *OllamaEmbedder) Embed(texts []string) ([][]float32, error) {
+	var response models.OllamaEmbedResponse
+	err := postJSON(fmt.Sprintf("%s/api/embed", e.BaseURL), "", models.OllamaEmbedRequest{Model: e.Model, Input: texts}, &response)
+	if err != nil {
+		return nil, fmt.Errorf("Ollama embedding failed: %w", err)
+	}
+	return response.Embeddings, nil
+}
+
+// OpenAIEmbedder embeds texts with the OpenAI /v1/embeddings endpoint
+type OpenAIEmbedder struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+}
+
+// Name implements Embedder
+func (e *OpenAIEmbedder) Name() string {
+	return "openai:" + e.Model
+}
+
+// Embed implements Embedder
+func (e *OpenAIEmbedder) Embed(texts []string) ([][]float32, error) {
+	var response models.OpenAIEmbeddingResponse
+	err := postJSON(fmt.Sprintf("%s/v1/embeddings", e.BaseURL), e.APIKey, models.OpenAIEmbeddingRequest{Model: e.Model, Input: texts}, &response)
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI embedding failed: %w", err)
+	}
+
+	// The data entries carry their input index and are not guaranteed to be in order
+	vectors := make([][]float32, len(texts))
+	for _, d := range response.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("OpenAI embedding returned out-of-range index %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("OpenAI embedding is missing a vector for input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// embeddingClient bounds requests to embedding and reranking engines so that
+// an engine that stops responding cannot hold up ingestion workers forever
+var embeddingClient = &http.Client{Timeout: 2 * time.Minute}
+
+// postJSON sends a JSON request with an optional bearer token and decodes the JSON response
+func postJSON(url string, apiKey string, request interface{}, response interface{}) error {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	}
+
+	resp, err := embeddingClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("non-200 status: %d - %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// DefaultHashEmbeddingDimensions is the vector size of the hash embedder when
+// EMBEDDING_DIMENSIONS is not set
+const DefaultHashEmbeddingDimensions = 256
+
+// HashEmbedder is a deterministic embedder that hashes words into a fixed
+// number of buckets. It needs no external service, which makes it suitable for
+// tests and offline setups, but it only captures word overlap, not meaning.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+// NewHashEmbedder creates a hash embedder producing vectors of the given size
+func NewHashEmbedder(dimensions int) *HashEmbedder {
+	if dimensions <= 0 {
+		dimensions = DefaultHashEmbeddingDimensions
+	}
+	return &HashEmbedder{Dimensions: dimensions}
+}
+
+// Name implements Embedder
+func (e *HashEmbedder) Name() string {
+	return fmt.Sprintf("hash:%d", e.Dimensions)
+}
+
+// Embed implements Embedder
+func (e *HashEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.Dimensions)
+		for _, word := range Tokenize(text) {
+			h := fnv.New64a()
+			h.Write([]byte(word))
+			sum := h.Sum64()
+			// The top bit picks the sign so that colliding words tend to cancel out
+			if sum>>63 == 1 {
+				vector[sum%uint64(e.Dimensions)]--
+			} else {
+				vector[sum%uint64(e.Dimensions)]++
+			}
+		}
+		vectors[i] = normalizeVector(vector)
+	}
+	return vectors, nil
+}
+
+// Tokenize lower-cases text and splits it into words of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func normalizeVector(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultEventLogSize is the number of events kept per room when no size is configured
+const DefaultEventLogSize = 500
+
+const (
+	// eventLogMaxRooms bounds the number of rooms with logged events; the
+	// rooms that have been idle longest are dropped first
+	eventLogMaxRooms = 10000
+	// eventLogIdleTTL is how long the events of a room without new events
+	// are kept. Clients reconnecting after that reload the chat.
+	eventLogIdleTTL = time.Hour
+	// eventLogPruneInterval is how often idle rooms are looked for
+	eventLogPruneInterval = time.Minute
+)
+
+// RoomEventLog keeps the most recent sequenced events of each room so that
+// reconnecting clients can catch up on what they missed
+type RoomEventLog struct {
+	mu       sync.Mutex
+	size     int
+	maxRooms int
+	rooms    map[string]*roomEvents
+	pruned   time.Time
+	now      func() time.Time
+}
+
+// roomEvents are the logged events of one room
+type roomEvents struct {
+	events  []BroadcastEvent // ordered by Seq
+	updated time.Time
+}
+
+// NewRoomEventLog creates a log keeping at most size events per room
+func NewRoomEventLog(size int) *RoomEventLog {
+	if size <= 0 {
+		size = DefaultEventLogSize
+	}
+	return &RoomEventLog{size: size, maxRooms: eventLogMaxRooms, rooms: make(map[string]*roomEvents), now: time.Now}
+}
+
+// Append records an event. Events from different instances can arrive
+// slightly out of order, so the event is inserted at its sequence position.
+func (l *RoomEventLog) Append(ev BroadcastEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	room, ok := l.rooms[ev.Room]
+	if !ok {
+		room = &roomEvents{}
+		l.rooms[ev.Room] = room
+	}
+	room.updated = now
+
+	events := room.events
+	i := sort.Search(len(events), func(i int) bool { return events[i].Seq >= ev.Seq })
+	if i < len(events) && events[i].Seq == ev.Seq {
+		return // Already logged
+	}
+	events = append(events, BroadcastEvent{})
+	copy(events[i+1:], events[i:])
+	events[i] = ev
+
+	if len(events) > l.size {
+		events = append([]BroadcastEvent(nil), events[len(events)-l.size:]...)
+	}
+	room.events = events
+
+	l.pruneLocked(now)
+}
+
+// pruneLocked drops rooms idle for longer than eventLogIdleTTL, then the
+// least recently updated rooms while there are more than maxRooms
+func (l *RoomEventLog) pruneLocked(now time.Time) {
+	if now.Sub(l.pruned) >= eventLogPruneInterval {
+		l.pruned = now
+		for name, room := range l.rooms {
+			if now.Sub(room.updated) > eventLogIdleTTL {
+				delete(l.rooms, name)
+			}
+		}
+	}
+	for len(l.rooms) > l.maxRooms {
+		oldest := ""
+		for name, room := range l.rooms {
+			if oldest == "" || room.updated.Before(l.rooms[oldest].updated) {
+				oldest = name
+			}
+		}
+		delete(l.rooms, oldest)
+	}
+}
+
+// Since returns the events of a room with a sequence number above lastSeq.
+// complete is false when the log cannot prove it holds every missed event:
+// older events were already evicted, or the client claims a sequence number
+// this instance has never seen. The client should then reload the chat.
+func (l *RoomEventLog) Since(room string, lastSeq int64) (events []BroadcastEvent, complete bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var logged []BroadcastEvent
+	if r, ok := l.rooms[room]; ok {
+		logged = r.events
+	}
+	if len(logged) == 0 {
+		return nil, lastSeq == 0
+	}
+	if lastSeq < logged[0].Seq-1 || lastSeq > logged[len(logged)-1].Seq {
+		return nil, false
+	}
+
+	i := sort.Search(len(logged), func(i int) bool { return logged[i].Seq > lastSeq })
+	return append([]BroadcastEvent(nil), logged[i:]...), true
+}
+
+// LatestSeq returns the highest sequence number logged for a room, or 0
+func (l *RoomEventLog) LatestSeq(room string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.rooms[room]
+	if !ok || len(r.events) == 0 {
+		return 0
+	}
+	return r.events[len(r.events)-1].Seq
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRoomEventLogReplaysMissedEvents(t *testing.T) {
+	l := NewRoomEventLog(3)
+	for _, seq := range []int64{1, 3, 2, 4} {
+		l.Append(BroadcastEvent{Room: "chat:1", Event: "message", Seq: seq})
+	}
+
+	events, complete := l.Since("chat:1", 2)
+	if !complete || len(events) != 2 || events[0].Seq != 3 || events[1].Seq != 4 {
+		t.Errorf("Since(2) = %+v, %v, want events 3 and 4", events, complete)
+	}
+	if _, complete := l.Since("chat:1", 0); complete {
+		t.Error("Since(0) is complete although event 1 was evicted")
+	}
+	if seq := l.LatestSeq("chat:1"); seq != 4 {
+		t.Errorf("LatestSeq = %d, want 4", seq)
+	}
+}
+
+func TestRoomEventLogDropsIdleRooms(t *testing.T) {
+	now := time.Now()
+	l := NewRoomEventLog(10)
+	l.now = func() time.Time { return now }
+
+	l.Append(BroadcastEvent{Room: "chat:1", Event: "message", Seq: 1})
+	now = now.Add(eventLogIdleTTL + eventLogPruneInterval)
+	l.Append(BroadcastEvent{Room: "chat:2", Event: "message", Seq: 1})
+
+	if _, ok := l.rooms["chat:1"]; ok {
+		t.Error("idle room chat:1 was kept")
+	}
+	if _, complete := l.Since("chat:1", 1); complete {
+		t.Error("a client of a dropped room is not asked to resync")
+	}
+	if seq := l.LatestSeq("chat:2"); seq != 1 {
+		t.Errorf("LatestSeq(chat:2) = %d, want 1", seq)
+	}
+}
+
+func TestRoomEventLogBoundsRooms(t *testing.T) {
+	now := time.Now()
+	l := NewRoomEventLog(10)
+	l.now = func() time.Time { return now }
+	l.maxRooms = 3
+
+	for i := 1; i <= 5; i++ {
+		now = now.Add(time.Second)
+		l.Append(BroadcastEvent{Room: fmt.Sprintf("chat:%d", i), Event: "message", Seq: 1})
+	}
+
+	if len(l.rooms) != 3 {
+		t.Fatalf("log holds %d rooms, want 3", len(l.rooms))
+	}
+	for i := 3; i <= 5; i++ {
+		if _, ok := l.rooms[fmt.Sprintf("chat:%d", i)]; !ok {
+			t.Errorf("recent room chat:%d was dropped", i)
+		}
+	}
+}
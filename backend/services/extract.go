@@ -0,0 +1,421 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxExtractSize bounds the number of bytes read from a file for text extraction
+const maxExtractSize = 200 << 20 // 200 MB
+
+// ExtractText returns the plain text of a file. The document type is taken
+// from the MIME type, falling back to the file name's extension. Plain text,
+// Markdown, HTML, PDF, DOCX, CSV and JSON are supported.
+func ExtractText(path string, mimeType string, name string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxExtractSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return ExtractTextFromBytes(data, mimeType, name)
+}
+
+// ExtractTextFromBytes is ExtractText for content already in memory
+func ExtractTextFromBytes(data []byte, mimeType string, name string) (string, error) {
+	var (
+		text string
+		err  error
+	)
+	switch documentKind(mimeType, name) {
+	case "text", "markdown":
+		text, err = decodeText(data)
+	case "html":
+		text = HTMLToText(string(data))
+	case "pdf":
+		text, err = extractPDFText(data)
+	case "docx":
+		text, err = extractDOCXText(data)
+	case "csv":
+		text, err = extractCSVText(data)
+	case "json":
+		text, err = extractJSONText(data)
+	default:
+		return "", fmt.Errorf("unsupported file type %q", mimeType)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	text = normalizeWhitespace(text)
+	if text == "" {
+		return "", fmt.Errorf("no extractable text found")
+	}
+	return text, nil
+}
+
+// documentKind maps a MIME type or file extension to an extractor
+func documentKind(mimeType string, name string) string {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+	switch mimeType {
+	case "text/markdown", "text/x-markdown":
+		return "markdown"
+	case "text/html", "application/xhtml+xml":
+		return "html"
+	case "application/pdf":
+		return "pdf"
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return "docx"
+	case "text/csv", "application/csv":
+		return "csv"
+	case "application/json":
+		return "json"
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return "markdown"
+	case ".html", ".htm":
+		return "html"
+	case ".pdf":
+		return "pdf"
+	case ".docx":
+		return "docx"
+	case ".csv":
+		return "csv"
+	case ".json":
+		return "json"
+	case ".txt", ".text", ".log", ".rst":
+		return "text"
+	}
+
+	if strings.HasPrefix(mimeType, "text/") {
+		return "text"
+	}
+	return ""
+}
+
+func decodeText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("file is not valid UTF-8 text")
+	}
+	return string(data), nil
+}
+
+var (
+	htmlDropBlocks = regexp.MustCompile(`(?is)<(script|style|noscript|template|svg|head)\b[^>]*>.*?</(script|style|noscript|template|svg|head)>`)
+	htmlComments   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlBreaks     = regexp.MustCompile(`(?i)<(br|hr)\b[^>]*>`)
+	htmlBlockEnds  = regexp.MustCompile(`(?i)</?(p|div|section|article|header|footer|nav|aside|main|h[1-6]|li|ul|ol|tr|table|blockquote|pre|dd|dt|dl|figure|figcaption)\b[^>]*>`)
+	htmlCells      = regexp.MustCompile(`(?i)</t[dh]\s*>`)
+	htmlTags       = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// HTMLToText reduces an HTML document to its readable text, keeping block
+// elements on separate lines
+func HTMLToText(doc string) string {
+	doc = htmlComments.ReplaceAllString(doc, "")
+	doc = htmlDropBlocks.ReplaceAllString(doc, "")
+	doc = htmlBreaks.ReplaceAllString(doc, "\n")
+	doc = htmlCells.ReplaceAllString(doc, "\t")
+	doc = htmlBlockEnds.ReplaceAllString(doc, "\n\n")
+	doc = htmlTags.ReplaceAllString(doc, "")
+	return html.UnescapeString(doc)
+}
+
+var (
+	trailingSpace = regexp.MustCompile(`[ \t\r\f\v]+\n`)
+	manyNewlines  = regexp.MustCompile(`\n{3,}`)
+	manySpaces    = regexp.MustCompile(`[ \t\f\v]{2,}`)
+)
+
+func normalizeWhitespace(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\u00a0", " ")
+	text = manySpaces.ReplaceAllString(text, " ")
+	text = trailingSpace.ReplaceAllString(text, "\n")
+	text = manyNewlines.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+func extractDOCXText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid DOCX file: %w", err)
+	}
+
+	var document *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			document = f
+			break
+		}
+	}
+	if document == nil {
+		return "", fmt.Errorf("invalid DOCX file: word/document.xml not found")
+	}
+
+	rc, err := document.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open DOCX document: %w", err)
+	}
+	defer rc.Close()
+
+	var b strings.Builder
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxExtractSize))
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse DOCX document: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				b.WriteString("\t")
+			case "br", "cr":
+				b.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				b.WriteString("\n\n")
+			case "tc":
+				b.WriteString("\t")
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// extractCSVText renders each row as "header: value" pairs so that the
+// meaning of a value survives chunking
+func extractCSVText(data []byte) (string, error) {
+	text, err := decodeText(data)
+	if err != nil {
+		return "", err
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return "", nil
+	}
+
+	header := records[0]
+	var b strings.Builder
+	for _, row := range records[1:] {
+		for i, value := range row {
+			if strings.TrimSpace(value) == "" {
+				continue
+			}
+			if i < len(header) && header[i] != "" {
+				fmt.Fprintf(&b, "%s: %s\n", header[i], value)
+			} else {
+				fmt.Fprintf(&b, "%s\n", value)
+			}
+		}
+		b.WriteString("\n")
+	}
+	if len(records) == 1 {
+		b.WriteString(strings.Join(header, ", "))
+	}
+	return b.String(), nil
+}
+
+// extractJSONText pretty-prints JSON so that keys and values are searchable
+// and chunk boundaries fall on lines
+func extractJSONText(data []byte) (string, error) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, bytes.TrimSpace(data), "", "  "); err != nil {
+		return "", fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return out.String(), nil
+}
+
+var (
+	pdfStreamRe = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfTextOps  = regexp.MustCompile(`(?s)\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>|\[|\]|T\*|Tj|TJ|Td|TD|Tm|'|"|ET|BT|-?\d*\.?\d+`)
+)
+
+// extractPDFText extracts text from the content streams of a PDF. It handles
+// uncompressed and Flate-compressed streams with simple font encodings, which
+// covers most text-based PDFs; scanned documents yield no text.
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("%PDF")) {
+		return "", fmt.Errorf("invalid PDF file")
+	}
+
+	var b strings.Builder
+	for _, loc := range pdfStreamRe.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		stream := data[start : start+end]
+
+		// Skip images, fonts and other binary streams
+		if bytes.Contains(dict, []byte("/Image")) || bytes.Contains(dict, []byte("/FontFile")) || bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			zr, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			decoded, err := io.ReadAll(io.LimitReader(zr, maxExtractSize))
+			zr.Close()
+			if err != nil && len(decoded) == 0 {
+				continue
+			}
+			stream = decoded
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue // Unsupported filter
+		}
+
+		if bytes.Contains(stream, []byte("BT")) {
+			b.WriteString(pdfContentText(stream))
+			b.WriteString("\n\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// pdfContentText interprets the text operators of a content stream
+func pdfContentText(stream []byte) string {
+	var (
+		b       strings.Builder
+		pending []string
+		inText  bool
+	)
+	for _, tok := range pdfTextOps.FindAll(stream, -1) {
+		s := string(tok)
+		switch {
+		case s == "BT":
+			inText = true
+			pending = pending[:0]
+		case s == "ET":
+			inText = false
+			b.WriteString("\n")
+		case !inText:
+			continue
+		case strings.HasPrefix(s, "("):
+			pending = append(pending, pdfLiteralString(s[1:len(s)-1]))
+		case strings.HasPrefix(s, "<"):
+			pending = append(pending, pdfHexString(s[1:len(s)-1]))
+		case s == "Tj" || s == "TJ":
+			b.WriteString(strings.Join(pending, ""))
+			pending = pending[:0]
+		case s == "'" || s == `"`:
+			b.WriteString("\n")
+			b.WriteString(strings.Join(pending, ""))
+			pending = pending[:0]
+		case s == "T*" || s == "Td" || s == "TD" || s == "Tm":
+			b.WriteString("\n")
+		case s == "[" || s == "]":
+		default:
+			// A large negative kerning adjustment inside TJ is a word gap
+			if len(pending) > 0 && strings.HasPrefix(s, "-") && len(s) > 3 {
+				pending = append(pending, " ")
+			}
+		}
+	}
+	return b.String()
+}
+
+func pdfLiteralString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'b', 'f':
+		case '\n':
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v := 0
+			j := i
+			for ; j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7'; j++ {
+				v = v*8 + int(s[j]-'0')
+			}
+			b.WriteRune(rune(v))
+			i = j - 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func pdfHexString(s string) string {
+	s = strings.Join(strings.Fields(s), "")
+	if len(s)%2 == 1 {
+		s += "0"
+	}
+	raw := make([]byte, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		var v byte
+		fmt.Sscanf(s[i:i+2], "%02x", &v)
+		raw = append(raw, v)
+	}
+	// Two-byte (UTF-16BE) strings are common for hex-encoded text
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		runes := make([]rune, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			runes = append(runes, rune(raw[i])<<8|rune(raw[i+1]))
+		}
+		return string(runes)
+	}
+	var b strings.Builder
+	for _, c := range raw {
+		b.WriteRune(rune(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"strconv"
+
+	"backend/database"
+	"backend/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// Thumbnail sizes that can be requested, as the longest side in pixels.
+// Other sizes are rounded up to the next one so the cache stays small.
+var ThumbnailSizes = []int{64, 128, 256, 512}
+
+const (
+	// maxThumbnailSource bounds the size of images read for thumbnails
+	maxThumbnailSource = 50 << 20 // 50 MB
+	// maxThumbnailPixels bounds the dimensions of images decoded for
+	// thumbnails, against decompression bombs
+	maxThumbnailPixels = 50_000_000
+)
+
+// ErrNoPreview is returned for files of a type that has no thumbnail or text
+var ErrNoPreview = errors.New("no preview is available for this file type")
+
+// FileThumbnail returns a thumbnail of an image file no larger than size
+// pixels on its longest side, and its MIME type
+func FileThumbnail(file models.File, size int) ([]byte, string, error) {
+	size = ThumbnailSize(size)
+	return cachedArtifact(file, "thumbnail-"+strconv.Itoa(size), func() ([]byte, string, error) {
+		return generateThumbnail(file, size)
+	})
+}
+
+// ThumbnailSize rounds a requested size up to a supported thumbnail size
+func ThumbnailSize(size int) int {
+	for _, s := range ThumbnailSizes {
+		if size <= s {
+			return s
+		}
+	}
+	return ThumbnailSizes[len(ThumbnailSizes)-1]
+}
+
+// FileText returns the plain text extracted from a document
+func FileText(file models.File) (string, error) {
+	data, _, err := cachedArtifact(file, "text", func() ([]byte, string, error) {
+		if documentKind(file.MimeType, file.Name) == "" {
+			return nil, "", ErrNoPreview
+		}
+		text, err := ExtractFileText(file)
+		if err != nil {
+			return nil, "", err
+		}
+		return []byte(text), "text/plain; charset=utf-8", nil
+	})
+	return string(data), err
+}
+
+// cachedArtifact returns an artifact of a file from the cache, generating
+// and storing it when it is missing or was derived from older content
+func cachedArtifact(file models.File, kind string, generate func() ([]byte, string, error)) ([]byte, string, error) {
+	// Files without a content hash cannot be told apart from their older
+	// versions, so their artifacts are not cached
+	if file.ContentHash == "" {
+		return generate()
+	}
+
+	var artifact models.FileArtifact
+	found := database.DB.Where("file_id = ? AND kind = ?", file.ID, kind).First(&artifact).Error == nil
+	if found && artifact.SourceHash == file.ContentHash {
+		data, err := ReadBlob(artifact.Path, maxExtractSize)
+		if err == nil {
+			return data, artifact.MimeType, nil
+		}
+		log.Printf("Cached %s of file %d is unreadable, regenerating: %v", kind, file.ID, err)
+	}
+
+	data, mimeType, err := generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Artifacts are keyed by the content they derive from, so files with
+	// the same content share them
+	key := file.ContentHash + "-" + kind
+	if err := fileStorage.Put(key, bytes.NewReader(data), int64(len(data)), mimeType); err != nil {
+		log.Printf("Failed to cache %s of file %d: %v", kind, file.ID, err)
+		return data, mimeType, nil
+	}
+	previous := artifact.Path
+	artifact = models.FileArtifact{
+		FileID:     file.ID,
+		Kind:       kind,
+		SourceHash: file.ContentHash,
+		Path:       key,
+		MimeType:   mimeType,
+		Size:       int64(len(data)),
+	}
+	result := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}, {Name: "kind"}},
+		DoUpdates: clause.AssignmentColumns([]string{"source_hash", "path", "mime_type", "size", "updated_at"}),
+	}).Create(&artifact)
+	if result.Error != nil {
+		log.Printf("Failed to record cached %s of file %d: %v", kind, file.ID, result.Error)
+	}
+	if found && previous != key {
+		deleteArtifactBlob(previous)
+	}
+	return data, mimeType, nil
+}
+
+// DeleteFileArtifacts drops the cached artifacts of a file, for example
+// when its content changed or it is being purged
+func DeleteFileArtifacts(fileID uint) {
+	var artifacts []models.FileArtifact
+	database.DB.Where("file_id = ?", fileID).Find(&artifacts)
+	if len(artifacts) == 0 {
+		return
+	}
+	database.DB.Where("file_id = ?", fileID).Delete(&models.FileArtifact{})
+	for _, artifact := range artifacts {
+		deleteArtifactBlob(artifact.Path)
+	}
+}
+
+// deleteArtifactBlob removes a cached artifact unless another file's
+// artifact shares it
+func deleteArtifactBlob(key string) {
+	var count int64
+	database.DB.Model(&models.FileArtifact{}).Where("path = ?", key).Count(&count)
+	if count > 0 {
+		return
+	}
+	if err := fileStorage.Delete(key); err != nil {
+		log.Printf("Failed to delete cached artifact %s: %v", key, err)
+	}
+}
+
+// generateThumbnail decodes a PNG, JPEG or GIF image and scales it down.
+// JPEG sources give JPEG thumbnails, others PNG to keep transparency.
+func generateThumbnail(file models.File, size int) ([]byte, string, error) {
+	var format string
+	switch file.MimeType {
+	case "image/png":
+		format = "png"
+	case "image/jpeg":
+		format = "jpeg"
+	case "image/gif":
+		format = "gif"
+	default:
+		return nil, "", ErrNoPreview
+	}
+	if file.Size > maxThumbnailSource {
+		return nil, "", fmt.Errorf("image is too large for a thumbnail")
+	}
+
+	rc, err := OpenFileContent(file)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, maxThumbnailSource))
+	rc.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	dimensions, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image: %w", err)
+	}
+	if dimensions.Width*dimensions.Height > maxThumbnailPixels {
+		return nil, "", fmt.Errorf("image is too large for a thumbnail")
+	}
+
+	var src image.Image
+	switch format {
+	case "png":
+		src, err = png.Decode(bytes.NewReader(data))
+	case "jpeg":
+		src, err = jpeg.Decode(bytes.NewReader(data))
+	case "gif":
+		src, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	var out bytes.Buffer
+	thumb := scaleDown(src, size)
+	if format == "jpeg" {
+		err = jpeg.Encode(&out, thumb, &jpeg.Options{Quality: 85})
+		return out.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(&out, thumb)
+	return out.Bytes(), "image/png", err
+}
+
+// scaleDown resizes an image so that its longest side is at most size,
+// averaging the source pixels covered by each thumbnail pixel. Smaller
+// images keep their size.
+func scaleDown(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, h*size/w
+		} else {
+			tw, th = w*size/h, size
+		}
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+
+	"backend/database"
+	"backend/models"
+
+	"gorm.io/gorm"
+)
+
+// StoreBlob streams content into file storage under the hex SHA-256 of the
+// content, which it returns as the key along with the size. Content that is
+// already stored is not uploaded again.
+func StoreBlob(r io.Reader, contentType string) (string, int64, error) {
+	// The key is only known once everything was read, so spool to disk first
+	tmp, err := os.CreateTemp("", "blob-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return "", 0, err
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	if err := putBlobFile(key, tmp, size, contentType); err != nil {
+		return "", 0, err
+	}
+	return key, size, nil
+}
+
+// StoreBlobFile stores the content of a local file the same way as
+// StoreBlob, without copying it first. It returns the key and size.
+func StoreBlobFile(f *os.File, contentType string) (string, int64, error) {
+	key, size, err := hashLocalFile(f)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := putBlobFile(key, f, size, contentType); err != nil {
+		return "", 0, err
+	}
+	return key, size, nil
+}
+
+// hashLocalFile returns the hex SHA-256 and size of a local file
+func hashLocalFile(f *os.File) (string, int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// putBlobFile uploads a local file under key unless the blob already exists
+func putBlobFile(key string, f *os.File, size int64, contentType string) error {
+	if _, err := fileStorage.Size(key); err == nil {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return fileStorage.Put(key, f, size, contentType)
+}
+
+// OpenFileContent opens the content of a file, from file storage or, for
+// files uploaded before blob storage existed, from its local path
+func OpenFileContent(file models.File) (io.ReadCloser, error) {
+	return OpenFileRange(file, 0, -1)
+}
+
+// OpenFileRange opens length bytes of a file's content starting at offset. A
+// negative length reads to the end.
+func OpenFileRange(file models.File, offset int64, length int64) (io.ReadCloser, error) {
+	if IsBlobKey(file.Path) {
+		return fileStorage.GetRange(file.Path, offset, length)
+	}
+
+	f, err := os.Open(file.Path)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// ExtractFileText returns the plain text of a stored file
+func ExtractFileText(file models.File) (string, error) {
+	rc, err := OpenFileContent(file)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxExtractSize))
+	if err != nil {
+		return "", err
+	}
+	return ExtractTextFromBytes(data, file.MimeType, file.Name)
+}
+
+// FileBlobInUse reports whether any file other than excludeID is stored at
+// path. Deleted files count until they are purged.
+func FileBlobInUse(path string, excludeID uint) bool {
+	var count int64
+	database.DB.Unscoped().Model(&models.File{}).Where("path = ? AND id <> ?", path, excludeID).Count(&count)
+	return count > 0
+}
+
+// lockBlob takes a lock on a storage key until the end of the transaction.
+// Deleting a blob and making files refer to it both hold it, so that a blob
+// found in storage is not deleted before the file sharing it is saved.
+func lockBlob(tx *gorm.DB, key string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "blob:"+key).Error
+}
+
+// referBlob runs fn, which makes a file refer to the blob stored under key,
+// while holding the blob's lock. It fails with ErrBlobNotFound when the blob
+// was deleted after it was stored because the last file sharing it was
+// purged in the meantime.
+func referBlob(key string, fn func(tx *gorm.DB) error) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlob(tx, key); err != nil {
+			return err
+		}
+		if _, err := fileStorage.Size(key); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// CreateBlobFile creates a file whose Path is the key of a stored blob,
+// provided it fits in its owner's storage quota
+func CreateBlobFile(file *models.File) error {
+	return referBlob(file.Path, func(tx *gorm.DB) error {
+		if err := reserveStorage(tx, file.UserID, file.Size); err != nil {
+			return err
+		}
+		return tx.Create(file).Error
+	})
+}
+
+// DeleteFileBlob removes the content of a file unless another file shares it
+func DeleteFileBlob(file models.File) error {
+	if !IsBlobKey(file.Path) {
+		if FileBlobInUse(file.Path, file.ID) {
+			return nil
+		}
+		if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlob(tx, file.Path); err != nil {
+			return err
+		}
+		if FileBlobInUse(file.Path, file.ID) {
+			return nil
+		}
+		return fileStorage.Delete(file.Path)
+	})
+}
+
+// MigrateFileBlobs moves files stored at local paths, as uploads were before
+// blob storage existed, into file storage under their content hash. Local
+// copies are removed once no file refers to them.
+func MigrateFileBlobs() {
+	var files []models.File
+	if result := database.DB.Where("path NOT SIMILAR TO '[0-9a-f]{64}' OR content_hash = '' OR content_hash IS NULL").Find(&files); result.Error != nil {
+		log.Printf("Failed to list files to migrate to blob storage: %v", result.Error)
+		return
+	}
+
+	for _, file := range files {
+		rc, err := OpenFileContent(file)
+		if err != nil {
+			log.Printf("Failed to open file %d for migration: %v", file.ID, err)
+			continue
+		}
+		key, size, err := StoreBlob(rc, file.MimeType)
+		rc.Close()
+		if err != nil {
+			log.Printf("Failed to migrate file %d to blob storage: %v", file.ID, err)
+			continue
+		}
+
+		err = referBlob(key, func(tx *gorm.DB) error {
+			return tx.Model(&file).UpdateColumns(map[string]interface{}{"path": key, "content_hash": key, "size": size}).Error
+		})
+		if err != nil {
+			log.Printf("Failed to move file %d to blob storage: %v", file.ID, err)
+			continue
+		}
+		if file.Path != key && !IsBlobKey(file.Path) {
+			var remaining int64
+			database.DB.Model(&models.File{}).Where("path = ?", file.Path).Count(&remaining)
+			if remaining == 0 {
+				os.Remove(file.Path)
+			}
+		}
+	}
+}
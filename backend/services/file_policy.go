@@ -0,0 +1,261 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+)
+
+var (
+	// ErrFileTypeNotAllowed is returned for content whose detected type is
+	// not in the uploader's allow-list
+	ErrFileTypeNotAllowed = errors.New("file type is not allowed")
+	// ErrFileInfected is returned for content the malware scanner flagged
+	ErrFileInfected = errors.New("file failed the malware scan")
+	// ErrFileScanFailed is returned when the scanner could not be reached or
+	// failed. Files are not accepted without a scan.
+	ErrFileScanFailed = errors.New("malware scan is unavailable")
+)
+
+// FileRejectedError reports why an upload was rejected and, when its content
+// was quarantined, the QuarantinedFile recording it
+type FileRejectedError struct {
+	Err          error
+	MimeType     string
+	Threat       string
+	QuarantineID uint
+}
+
+func (e *FileRejectedError) Error() string {
+	switch e.Err {
+	case ErrFileTypeNotAllowed:
+		return fmt.Sprintf("file type %s is not allowed", e.MimeType)
+	case ErrFileInfected:
+		return fmt.Sprintf("file failed the malware scan: %s", e.Threat)
+	}
+	return e.Err.Error()
+}
+
+func (e *FileRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// FilePolicy limits what a user may upload
+type FilePolicy struct {
+	AllowedTypes []string // MIME types or patterns such as text/*; empty allows all
+	MaxSize      int64
+}
+
+// Allows reports whether the policy accepts a MIME type
+func (p FilePolicy) Allows(mimeType string) bool {
+	if len(p.AllowedTypes) == 0 {
+		return true
+	}
+	for _, pattern := range p.AllowedTypes {
+		if pattern == "*/*" || pattern == mimeType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mimeType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleFilePolicy returns the upload policy of a role: UPLOAD_ALLOWED_TYPES_<ROLE>
+// and UPLOAD_MAX_SIZE_<ROLE>, falling back to UPLOAD_ALLOWED_TYPES and
+// UPLOAD_MAX_SIZE
+func RoleFilePolicy(role string) FilePolicy {
+	suffix := "_" + strings.ToUpper(role)
+
+	allowed := config.Config("UPLOAD_ALLOWED_TYPES" + suffix)
+	if allowed == "" {
+		allowed = config.Config("UPLOAD_ALLOWED_TYPES")
+	}
+	var policy FilePolicy
+	for _, t := range strings.Split(allowed, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			policy.AllowedTypes = append(policy.AllowedTypes, t)
+		}
+	}
+
+	policy.MaxSize = UploadMaxSize()
+	if n, err := strconv.ParseInt(config.Config("UPLOAD_MAX_SIZE"+suffix), 10, 64); err == nil && n > 0 {
+		policy.MaxSize = n
+	}
+	return policy
+}
+
+// UserFilePolicy returns the upload policy of a user's role
+func UserFilePolicy(userID uint) FilePolicy {
+	var user models.User
+	database.DB.Select("role").First(&user, userID)
+	return RoleFilePolicy(user.Role)
+}
+
+// extensionTypes covers common document types that the system MIME table
+// may not know
+var extensionTypes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".csv":      "text/csv",
+	".json":     "application/json",
+	".docx":     "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx":     "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx":     "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":      "application/vnd.oasis.opendocument.text",
+	".epub":     "application/epub+zip",
+}
+
+// DetectMimeType determines the type of content from its first bytes. The
+// file name's extension only refines generic results, for example text
+// into Markdown or a ZIP archive into DOCX, and never turns binary content
+// into text. The client's claimed type is not trusted.
+func DetectMimeType(head []byte, name string) string {
+	sniffed := strings.SplitN(http.DetectContentType(head), ";", 2)[0]
+
+	ext := strings.ToLower(filepath.Ext(name))
+	byExt := extensionTypes[ext]
+	if byExt == "" {
+		byExt = strings.SplitN(mime.TypeByExtension(ext), ";", 2)[0]
+	}
+	if byExt == "" {
+		return sniffed
+	}
+
+	switch sniffed {
+	case "text/plain":
+		if isTextType(byExt) {
+			return byExt
+		}
+	case "text/xml":
+		if strings.HasSuffix(byExt, "+xml") || strings.HasSuffix(byExt, "/xml") {
+			return byExt
+		}
+	case "application/zip":
+		if strings.HasPrefix(byExt, "application/vnd.") || strings.HasSuffix(byExt, "+zip") {
+			return byExt
+		}
+	case "application/octet-stream":
+		if !isTextType(byExt) {
+			return byExt
+		}
+	}
+	return sniffed
+}
+
+func isTextType(mimeType string) bool {
+	switch mimeType {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml", "application/yaml":
+		return true
+	}
+	return strings.HasPrefix(mimeType, "text/") || strings.HasSuffix(mimeType, "+json") || strings.HasSuffix(mimeType, "+xml")
+}
+
+// StoreUpload spools uploaded content to disk and stores it with
+// StoreUploadFile
+func StoreUpload(userID uint, r io.Reader, name string) (string, int64, string, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return "", 0, "", err
+	}
+	return StoreUploadFile(userID, tmp, name)
+}
+
+// StoreUploadFile checks a user's upload against their FilePolicy, their
+// storage quota and the malware scanner, then stores it in file storage. It
+// returns the storage key, the size and the detected MIME type. Rejected
+// content is reported with a *FileRejectedError, and quarantined when its
+// type or the scan was the reason.
+func StoreUploadFile(userID uint, f *os.File, name string) (string, int64, string, error) {
+	policy := UserFilePolicy(userID)
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, "", err
+	}
+	if info.Size() > policy.MaxSize {
+		return "", 0, "", &FileRejectedError{Err: ErrUploadTooLarge}
+	}
+	if err := CheckStorageQuota(userID, info.Size()); err == ErrQuotaExceeded {
+		return "", 0, "", &FileRejectedError{Err: err}
+	} else if err != nil {
+		return "", 0, "", err
+	}
+
+	head := make([]byte, 512)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, "", err
+	}
+	mimeType := DetectMimeType(head[:n], name)
+	if !policy.Allows(mimeType) {
+		return "", 0, "", quarantineUpload(userID, f, name, &FileRejectedError{Err: ErrFileTypeNotAllowed, MimeType: mimeType})
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, "", err
+	}
+	threat, err := UploadScanner().Scan(f)
+	if err != nil {
+		log.Printf("Malware scan of upload %q failed: %v", name, err)
+		return "", 0, "", &FileRejectedError{Err: ErrFileScanFailed, MimeType: mimeType}
+	}
+	if threat != "" {
+		return "", 0, "", quarantineUpload(userID, f, name, &FileRejectedError{Err: ErrFileInfected, MimeType: mimeType, Threat: threat})
+	}
+
+	key, size, err := StoreBlobFile(f, mimeType)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return key, size, mimeType, nil
+}
+
+// quarantineUpload moves rejected content into storage under a quarantine
+// key, which is never served, records it and returns the rejection
+func quarantineUpload(userID uint, f *os.File, name string, rejection *FileRejectedError) error {
+	hash, size, err := hashLocalFile(f)
+	if err != nil {
+		return err
+	}
+	key := "quarantine-" + hash
+	if err := putBlobFile(key, f, size, "application/octet-stream"); err != nil {
+		log.Printf("Failed to quarantine upload %q: %v", name, err)
+		return rejection
+	}
+
+	quarantined := models.QuarantinedFile{
+		UserID:      userID,
+		Name:        name,
+		MimeType:    rejection.MimeType,
+		Size:        size,
+		ContentHash: hash,
+		Path:        key,
+		Reason:      rejection.Error(),
+		Threat:      rejection.Threat,
+	}
+	if result := database.DB.Create(&quarantined); result.Error != nil {
+		log.Printf("Failed to record quarantined upload %q: %v", name, result.Error)
+		return rejection
+	}
+	log.Printf("Quarantined upload %q of user %d: %s", name, userID, quarantined.Reason)
+	rejection.QuarantineID = quarantined.ID
+	return rejection
+}
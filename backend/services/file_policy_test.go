@@ -0,0 +1,36 @@
+package services
+
+import "testing"
+
+func TestDetectMimeType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	zip := []byte("PK\x03\x04\x14\x00\x06\x00")
+	binary := []byte{0x00, 0x01, 0x02, 0x03, 0xfe, 0xff}
+	tests := []struct {
+		name string
+		head []byte
+		file string
+		want string
+	}{
+		{name: "text refined into Markdown", head: []byte("# Title\n"), file: "notes.md", want: "text/markdown"},
+		{name: "text refined into CSV", head: []byte("a,b\n1,2\n"), file: "data.CSV", want: "text/csv"},
+		{name: "text refined into JSON", head: []byte(`{"a": 1}`), file: "data.json", want: "application/json"},
+		{name: "text without extension", head: []byte("hello"), file: "README", want: "text/plain"},
+		{name: "empty content", head: nil, file: "empty.md", want: "text/markdown"},
+		{name: "XML refined into SVG", head: []byte(`<?xml version="1.0"?><svg/>`), file: "logo.svg", want: "image/svg+xml"},
+		{name: "ZIP refined into DOCX", head: zip, file: "report.docx", want: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{name: "ZIP with a text extension", head: zip, file: "notes.md", want: "application/zip"},
+		{name: "unknown binary refined by extension", head: binary, file: "book.epub", want: "application/epub+zip"},
+		{name: "binary never becomes text", head: binary, file: "data.json", want: "application/octet-stream"},
+		{name: "image with a text extension", head: png, file: "image.txt", want: "image/png"},
+		{name: "PDF with a text extension", head: []byte("%PDF-1.7\n"), file: "paper.md", want: "application/pdf"},
+		{name: "HTML with a Markdown extension", head: []byte("<html><body>hi</body></html>"), file: "page.md", want: "text/html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectMimeType(tt.head, tt.file); got != tt.want {
+				t.Errorf("DetectMimeType(%q, %q) = %q, want %q", tt.head, tt.file, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+)
+
+// FileScanner checks uploaded content for malware before it becomes a file.
+// Scan returns the name of the threat found, or "" when the content is clean.
+type FileScanner interface {
+	Scan(r io.Reader) (threat string, err error)
+}
+
+var (
+	fileScannerOnce sync.Once
+	fileScanner     FileScanner
+)
+
+// UploadScanner returns the scanner selected by FILE_SCAN_ENGINE: "clamav"
+// (CLAMAV_ADDRESS) or, by default, a scanner that accepts everything
+func UploadScanner() FileScanner {
+	fileScannerOnce.Do(func() {
+		switch config.Config("FILE_SCAN_ENGINE") {
+		case "clamav":
+			fileScanner = NewClamAVScanner(config.Config("CLAMAV_ADDRESS"))
+		default:
+			fileScanner = NoopScanner{}
+		}
+	})
+	return fileScanner
+}
+
+// SetUploadScanner replaces the scanner used for uploads
+func SetUploadScanner(scanner FileScanner) {
+	fileScannerOnce.Do(func() {})
+	fileScanner = scanner
+}
+
+// NoopScanner accepts all content
+type NoopScanner struct{}
+
+// Scan implements FileScanner
+func (NoopScanner) Scan(r io.Reader) (string, error) {
+	return "", nil
+}
+
+// ClamAVScanner streams content to a clamd daemon with the INSTREAM command
+type ClamAVScanner struct {
+	Network string // tcp or unix
+	Address string
+	Timeout time.Duration
+}
+
+// clamAVChunkSize is the size of the chunks sent to clamd
+const clamAVChunkSize = 64 << 10
+
+// NewClamAVScanner creates a scanner for a clamd address such as
+// tcp://localhost:3310 or unix:///var/run/clamav/clamd.ctl. An empty
+// address means localhost:3310.
+func NewClamAVScanner(address string) *ClamAVScanner {
+	s := &ClamAVScanner{Network: "tcp", Address: "localhost:3310", Timeout: 10 * time.Minute}
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		s.Network, s.Address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		s.Address = strings.TrimPrefix(address, "tcp://")
+	case address != "":
+		s.Address = address
+	}
+	return s
+}
+
+// Scan implements FileScanner
+func (s *ClamAVScanner) Scan(r io.Reader) (string, error) {
+	conn, err := net.DialTimeout(s.Network, s.Address, 10*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if s.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("failed to send to clamd: %w", err)
+	}
+	buf := make([]byte, clamAVChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return "", fmt.Errorf("failed to send to clamd: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return "", fmt.Errorf("failed to send to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		} else if readErr != nil {
+			return "", readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", fmt.Errorf("failed to send to clamd: %w", err)
+	}
+
+	// The reply is "stream: OK", "stream: <threat> FOUND" or "<message> ERROR"
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(reply, "stream:"), "FOUND")), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd accepts one INSTREAM scan, records the content streamed to it
+// and answers with reply
+func fakeClamd(t *testing.T, reply string) (address string, received <-chan []byte) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	content := make(chan []byte, 1)
+	go func() {
+		defer close(content)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		command := make([]byte, len("zINSTREAM\x00"))
+		if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+			t.Errorf("command = %q, %v", command, err)
+			return
+		}
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+				t.Errorf("reading chunk size: %v", err)
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if size > clamAVChunkSize {
+				t.Errorf("chunk of %d bytes exceeds %d", size, clamAVChunkSize)
+			}
+			if _, err := io.CopyN(&data, conn, int64(size)); err != nil {
+				t.Errorf("reading chunk: %v", err)
+				return
+			}
+		}
+		content <- data.Bytes()
+		conn.Write([]byte(reply + "\x00"))
+	}()
+	return listener.Addr().String(), content
+}
+
+func TestClamAVScannerScan(t *testing.T) {
+	tests := []struct {
+		name       string
+		reply      string
+		wantThreat string
+		wantErr    string
+	}{
+		{name: "clean", reply: "stream: OK"},
+		{name: "infected", reply: "stream: Eicar-Test-Signature FOUND", wantThreat: "Eicar-Test-Signature"},
+		{name: "error", reply: "INSTREAM size limit exceeded. ERROR", wantErr: "size limit exceeded"},
+	}
+	// Larger than one chunk, so that the content is streamed in several
+	content := bytes.Repeat([]byte("scan me "), clamAVChunkSize/4)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, received := fakeClamd(t, tt.reply)
+			threat, err := NewClamAVScanner("tcp://" + address).Scan(bytes.NewReader(content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Scan error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if threat != tt.wantThreat {
+				t.Errorf("threat = %q, want %q", threat, tt.wantThreat)
+			}
+			if got := <-received; !bytes.Equal(got, content) {
+				t.Errorf("clamd received %d bytes, want the %d scanned", len(got), len(content))
+			}
+		})
+	}
+}
+
+func TestClamAVScannerUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	if _, err := NewClamAVScanner(address).Scan(strings.NewReader("content")); err == nil {
+		t.Error("Scan succeeded without clamd")
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"backend/database"
+	"backend/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrFolderNotFound is returned for a folder that does not exist or
+	// belongs to another user
+	ErrFolderNotFound = errors.New("folder not found")
+	// ErrFolderCycle is returned when moving a folder into itself or one of
+	// its descendants
+	ErrFolderCycle = errors.New("a folder cannot be moved into itself or one of its subfolders")
+)
+
+// FolderBreadcrumb is one step of the path from the root to a folder
+type FolderBreadcrumb struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// FolderDeleteCount is what deleting a folder recursively removes
+type FolderDeleteCount struct {
+	Folders int `json:"folders"`
+	Files   int `json:"files"`
+}
+
+// FolderBreadcrumbs returns the path from the top-level folder down to a
+// folder, both included
+func FolderBreadcrumbs(folderID uint, userID uint) ([]FolderBreadcrumb, error) {
+	var crumbs []FolderBreadcrumb
+	result := database.DB.Raw(`WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, name, 0 AS depth FROM folders
+			WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+			UNION
+			SELECT f.id, f.parent_id, f.name, a.depth + 1 FROM folders f
+			JOIN ancestors a ON f.id = a.parent_id
+			WHERE f.user_id = ? AND f.deleted_at IS NULL
+		)
+		SELECT id, name FROM ancestors ORDER BY depth DESC`, folderID, userID, userID).Scan(&crumbs)
+	return crumbs, result.Error
+}
+
+// folderDescendantIDs returns a folder and all folders below it
+func folderDescendantIDs(tx *gorm.DB, folderID uint, userID uint) ([]uint, error) {
+	var ids []uint
+	result := tx.Raw(`WITH RECURSIVE tree AS (
+			SELECT id FROM folders WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+			UNION
+			SELECT f.id FROM folders f JOIN tree t ON f.parent_id = t.id
+			WHERE f.user_id = ? AND f.deleted_at IS NULL
+		)
+		SELECT id FROM tree`, folderID, userID, userID).Scan(&ids)
+	return ids, result.Error
+}
+
+// FolderTree returns a user's folders as a tree, read with a single
+// recursive query. With rootID only that folder's subtree is returned, and
+// with withFiles the files of every folder are included.
+func FolderTree(userID uint, rootID *uint, withFiles bool) ([]models.Folder, error) {
+	start := "parent_id IS NULL"
+	args := []interface{}{userID}
+	if rootID != nil {
+		start = "id = ?"
+		args = append(args, *rootID)
+	}
+	args = append(args, userID)
+
+	var folders []models.Folder
+	result := database.DB.Raw(fmt.Sprintf(`WITH RECURSIVE tree AS (
+			SELECT * FROM folders WHERE user_id = ? AND %s AND deleted_at IS NULL
+			UNION ALL
+			SELECT f.* FROM folders f JOIN tree t ON f.parent_id = t.id
+			WHERE f.user_id = ? AND f.deleted_at IS NULL
+		)
+		SELECT * FROM tree ORDER BY name`, start), args...).Scan(&folders)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if rootID != nil && len(folders) == 0 {
+		return nil, ErrFolderNotFound
+	}
+
+	filesByFolder := make(map[uint][]models.File)
+	if withFiles && len(folders) > 0 {
+		ids := make([]uint, len(folders))
+		for i, f := range folders {
+			ids[i] = f.ID
+		}
+		var files []models.File
+		if result := database.DB.Where("user_id = ? AND folder_id IN ?", userID, ids).Order("name").Find(&files); result.Error != nil {
+			return nil, result.Error
+		}
+		for _, file := range files {
+			filesByFolder[*file.FolderID] = append(filesByFolder[*file.FolderID], file)
+		}
+	}
+
+	// Attach children to their parents. Folders whose parent is outside the
+	// result, like the requested root, become roots of the tree.
+	children := make(map[uint][]int)
+	index := make(map[uint]int, len(folders))
+	for i, f := range folders {
+		index[f.ID] = i
+	}
+	var roots []int
+	for i, f := range folders {
+		if parent := f.ParentID; parent != nil && (rootID == nil || f.ID != *rootID) {
+			if _, ok := index[*parent]; ok {
+				children[*parent] = append(children[*parent], i)
+				continue
+			}
+		}
+		roots = append(roots, i)
+	}
+
+	var build func(i int) models.Folder
+	build = func(i int) models.Folder {
+		folder := folders[i]
+		folder.Files = filesByFolder[folder.ID]
+		folder.Subfolders = nil
+		for _, child := range children[folder.ID] {
+			folder.Subfolders = append(folder.Subfolders, build(child))
+		}
+		return folder
+	}
+	tree := make([]models.Folder, 0, len(roots))
+	for _, i := range roots {
+		tree = append(tree, build(i))
+	}
+	return tree, nil
+}
+
+// MoveFolder moves a folder under parentID, or to the top level when
+// parentID is nil. Moves of the same user are serialized so that two
+// concurrent moves cannot create a cycle.
+func MoveFolder(folderID uint, userID uint, parentID *uint) (models.Folder, error) {
+	var folder models.Folder
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", folderLockSpace, userID).Error; err != nil {
+			return err
+		}
+		if result := tx.Where("id = ? AND user_id = ?", folderID, userID).First(&folder); result.Error != nil {
+			return ErrFolderNotFound
+		}
+
+		if parentID != nil {
+			var parent models.Folder
+			if result := tx.Where("id = ? AND user_id = ?", *parentID, userID).First(&parent); result.Error != nil {
+				return ErrFolderNotFound
+			}
+			descendants, err := folderDescendantIDs(tx, folderID, userID)
+			if err != nil {
+				return err
+			}
+			for _, id := range descendants {
+				if id == *parentID {
+					return ErrFolderCycle
+				}
+			}
+		}
+
+		folder.ParentID = parentID
+		return tx.Model(&folder).Update("parent_id", parentID).Error
+	})
+	return folder, err
+}
+
+// folderLockSpace namespaces the advisory locks taken on a user's folder tree
+const folderLockSpace = 0x464f4c44 // "FOLD"
+
+// DeleteFolderTree deletes a folder with all of its subfolders and files,
+// purging the files from knowledge bases. Their content stays in storage
+// until the retention job purges it. With dryRun nothing is deleted and only
+// the counts are returned.
+func DeleteFolderTree(folderID uint, userID uint, dryRun bool) (FolderDeleteCount, error) {
+	var count FolderDeleteCount
+	var files []models.File
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", folderLockSpace, userID).Error; err != nil {
+			return err
+		}
+		folderIDs, err := folderDescendantIDs(tx, folderID, userID)
+		if err != nil {
+			return err
+		}
+		if len(folderIDs) == 0 {
+			return ErrFolderNotFound
+		}
+		if result := tx.Where("user_id = ? AND folder_id IN ?", userID, folderIDs).Find(&files); result.Error != nil {
+			return result.Error
+		}
+		count = FolderDeleteCount{Folders: len(folderIDs), Files: len(files)}
+		if dryRun {
+			return nil
+		}
+
+		if len(files) > 0 {
+			if result := tx.Delete(&files); result.Error != nil {
+				return result.Error
+			}
+		}
+		return tx.Where("id IN ?", folderIDs).Delete(&models.Folder{}).Error
+	})
+	if err != nil || dryRun {
+		return count, err
+	}
+
+	// The files are gone from the tree; purge their chunks and vectors
+	for _, file := range files {
+		if err := RemoveFileFromKnowledges(file.ID); err != nil {
+			log.Printf("Failed to remove file %d from knowledge bases: %v", file.ID, err)
+		}
+	}
+	return count, nil
+}
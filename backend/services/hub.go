@@ -0,0 +1,156 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// chatRoomPrefix prefixes the name of every chat room
+const chatRoomPrefix = "chat:"
+
+// ChatRoom returns the room name used for a chat's events
+func ChatRoom(chatID uint) string {
+	return fmt.Sprintf("%s%d", chatRoomPrefix, chatID)
+}
+
+// ParseChatRoom returns the chat ID of a chat room name
+func ParseChatRoom(room string) (uint, bool) {
+	if !strings.HasPrefix(room, chatRoomPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(room, chatRoomPrefix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// UserRoom returns the room name used for events addressed to one user, such
+// as knowledge base indexing progress. Every authenticated connection of the
+// user joins it.
+func UserRoom(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// ParseUserRoom returns the user ID of a user room name
+func ParseUserRoom(room string) (uint, bool) {
+	if !strings.HasPrefix(room, "user:") {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(room, "user:"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// RealtimeEvent is the envelope sent to WebSocket and SSE clients. Type is the
+// Socket.IO event name and Data is the same payload Socket.IO clients receive.
+type RealtimeEvent struct {
+	Type   string          `json:"type"`
+	ChatID uint            `json:"chat_id,omitempty"`
+	Seq    int64           `json:"seq,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// NewRealtimeEvent converts a broadcast event into the realtime envelope
+func NewRealtimeEvent(ev BroadcastEvent) RealtimeEvent {
+	chatID, _ := ParseChatRoom(ev.Room)
+	return RealtimeEvent{Type: ev.Event, ChatID: chatID, Seq: ev.Seq, Data: ev.Payload}
+}
+
+// RealtimeClient is a connection of a native realtime transport
+type RealtimeClient interface {
+	// Send queues an event without blocking. It returns false if the client
+	// cannot keep up, in which case the client is expected to disconnect.
+	Send(ev RealtimeEvent) bool
+}
+
+// Hub delivers broadcast events received on this instance to every local
+// transport: native WebSocket/SSE clients subscribed to a room, and sinks such
+// as the Socket.IO server, which tracks its own room membership.
+type Hub struct {
+	mu    sync.RWMutex
+	rooms map[string]map[string]RealtimeClient // room -> client ID -> client
+	sinks []func(BroadcastEvent)
+}
+
+// NewHub creates an empty Hub
+func NewHub() *Hub {
+	return &Hub{rooms: make(map[string]map[string]RealtimeClient)}
+}
+
+// AddSink registers a function that receives every delivered event
+func (h *Hub) AddSink(sink func(BroadcastEvent)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sinks = append(h.sinks, sink)
+}
+
+// Subscribe adds a client to a room
+func (h *Hub) Subscribe(room string, clientID string, client RealtimeClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clients, ok := h.rooms[room]
+	if !ok {
+		clients = make(map[string]RealtimeClient)
+		h.rooms[room] = clients
+	}
+	clients[clientID] = client
+}
+
+// Unsubscribe removes a client from a room
+func (h *Hub) Unsubscribe(room string, clientID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unsubscribeLocked(room, clientID)
+}
+
+// UnsubscribeAll removes a client from every room
+func (h *Hub) UnsubscribeAll(clientID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for room := range h.rooms {
+		h.unsubscribeLocked(room, clientID)
+	}
+}
+
+func (h *Hub) unsubscribeLocked(room string, clientID string) {
+	clients, ok := h.rooms[room]
+	if !ok {
+		return
+	}
+	delete(clients, clientID)
+	if len(clients) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// Deliver hands an event to every sink and to the native clients in its room
+func (h *Hub) Deliver(ev BroadcastEvent) {
+	h.mu.RLock()
+	sinks := h.sinks
+	clients := make([]RealtimeClient, 0, len(h.rooms[ev.Room]))
+	for _, c := range h.rooms[ev.Room] {
+		clients = append(clients, c)
+	}
+	h.mu.RUnlock()
+
+	for _, sink := range sinks {
+		sink(ev)
+	}
+	if len(clients) == 0 {
+		return
+	}
+	realtimeEvent := NewRealtimeEvent(ev)
+	for _, c := range clients {
+		c.Send(realtimeEvent)
+	}
+}
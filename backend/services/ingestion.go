@@ -0,0 +1,329 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultIngestionWorkers is the number of ingestion workers started when
+// INGESTION_WORKERS is not set
+const DefaultIngestionWorkers = 2
+
+// ingestionQueueSize bounds the jobs waiting for a worker. Files that do not
+// fit stay pending and are queued again from the database later.
+const ingestionQueueSize = 1024
+
+// ingestionJob asks for a file to be (re)indexed into a knowledge base, or
+// for the knowledge base's vector collection to be created when FileID is 0
+type ingestionJob struct {
+	KnowledgeID uint
+	FileID      uint
+}
+
+var (
+	ingestionQueue chan ingestionJob
+	ingestionOnce  sync.Once
+	// ingestionBacklog is set when a job did not fit in the queue
+	ingestionBacklog atomic.Bool
+)
+
+var (
+	// ErrFileInKnowledge is returned when adding a file a knowledge base already contains
+	ErrFileInKnowledge = errors.New("file already exists in knowledge base")
+	// ErrFileNotInKnowledge is returned for a file a knowledge base does not contain
+	ErrFileNotInKnowledge = errors.New("file not found in knowledge base")
+)
+
+// StartIngestionWorkers starts the background workers that extract, chunk
+// and embed files added to knowledge bases. Files left pending or processing
+// by a previous run are queued again, and collections missing from the vector
+// store are rebuilt. Calling it more than once has no effect.
+func StartIngestionWorkers() {
+	ingestionOnce.Do(func() {
+		workers, _ := strconv.Atoi(config.Config("INGESTION_WORKERS"))
+		if workers <= 0 {
+			workers = DefaultIngestionWorkers
+		}
+
+		ingestionQueue = make(chan ingestionJob, ingestionQueueSize)
+		for i := 0; i < workers; i++ {
+			go func() {
+				for job := range ingestionQueue {
+					if job.FileID == 0 {
+						prepareKnowledgeCollection(job.KnowledgeID)
+						continue
+					}
+					IngestFile(job.KnowledgeID, job.FileID)
+					if len(ingestionQueue) == 0 && ingestionBacklog.CompareAndSwap(true, false) {
+						queuePendingIngestions()
+					}
+				}
+			}()
+		}
+
+		var unfinished []models.KnowledgeFile
+		database.DB.Where("status IN ?", []string{models.IngestionPending, models.IngestionProcessing}).Find(&unfinished)
+		for _, kf := range unfinished {
+			EnqueueIngestion(kf.KnowledgeID, kf.FileID)
+		}
+
+		go SyncKnowledgeCollections()
+	})
+}
+
+// AddToKnowledge adds a file to a knowledge base and queues it for indexing.
+// Concurrent adds of the same file are resolved by the database: all but one
+// get ErrFileInKnowledge.
+func AddToKnowledge(knowledgeID uint, fileID uint) error {
+	kf := models.KnowledgeFile{KnowledgeID: knowledgeID, FileID: fileID, Status: models.IngestionPending}
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&kf)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrFileInKnowledge
+	}
+	return EnqueueIngestion(knowledgeID, fileID)
+}
+
+// KnowledgeFileIDs returns the IDs of the files in a knowledge base in the
+// order they were added
+func KnowledgeFileIDs(knowledgeID uint) ([]uint, error) {
+	var fileIDs []uint
+	err := database.DB.Model(&models.KnowledgeFile{}).
+		Where("knowledge_id = ?", knowledgeID).
+		Order("created_at, id").
+		Pluck("file_id", &fileIDs).Error
+	return fileIDs, err
+}
+
+// EnqueueIngestion marks a file as pending in a knowledge base and queues it
+// for indexing. Without running workers the file is indexed synchronously.
+func EnqueueIngestion(knowledgeID uint, fileID uint) error {
+	if err := setIngestionStatus(knowledgeID, fileID, models.IngestionPending, ""); err != nil {
+		return err
+	}
+
+	if ingestionQueue == nil {
+		IngestFile(knowledgeID, fileID)
+		return nil
+	}
+	queueIngestion(ingestionJob{KnowledgeID: knowledgeID, FileID: fileID})
+	return nil
+}
+
+// EnqueueKnowledgeCollection queues the creation of a new knowledge base's
+// vector collection, which needs a call to the embedder. Without running
+// workers, or when the queue is full, the collection is created by the first
+// ingestion instead.
+func EnqueueKnowledgeCollection(knowledgeID uint) {
+	if ingestionQueue == nil {
+		return
+	}
+	select {
+	case ingestionQueue <- ingestionJob{KnowledgeID: knowledgeID}:
+	default:
+	}
+}
+
+// prepareKnowledgeCollection creates the vector collection of a knowledge base
+func prepareKnowledgeCollection(knowledgeID uint) {
+	knowledge := models.Knowledge{ID: knowledgeID}
+	if _, err := EnsureKnowledgeCollection(&knowledge); err != nil {
+		log.Printf("Failed to create the collection of knowledge %d: %v", knowledgeID, err)
+	}
+}
+
+// queueIngestion hands a job to the workers without waiting. When the queue
+// is full the file is left pending and the backlog flag set, so that a worker
+// queues the pending files again once it has drained the queue.
+func queueIngestion(job ingestionJob) {
+	select {
+	case ingestionQueue <- job:
+	default:
+		ingestionBacklog.Store(true)
+	}
+}
+
+// queuePendingIngestions queues the files still pending in any knowledge base
+func queuePendingIngestions() {
+	var pending []models.KnowledgeFile
+	if result := database.DB.Where("status = ?", models.IngestionPending).Order("updated_at").Find(&pending); result.Error != nil {
+		log.Printf("Failed to load pending knowledge files: %v", result.Error)
+		ingestionBacklog.Store(true)
+		return
+	}
+	for _, kf := range pending {
+		queueIngestion(ingestionJob{KnowledgeID: kf.KnowledgeID, FileID: kf.FileID})
+	}
+}
+
+// IngestFile extracts, chunks and embeds a file, replacing any chunks and
+// vectors it already has in the knowledge base, and records the outcome as the file's status
+func IngestFile(knowledgeID uint, fileID uint) {
+	if err := setIngestionStatus(knowledgeID, fileID, models.IngestionProcessing, ""); err != nil {
+		log.Printf("Ingestion of file %d into knowledge %d: %v", fileID, knowledgeID, err)
+		return
+	}
+
+	count, err := ingestFile(knowledgeID, fileID)
+	if err != nil {
+		log.Printf("Ingestion of file %d into knowledge %d failed: %v", fileID, knowledgeID, err)
+		setIngestionStatus(knowledgeID, fileID, models.IngestionFailed, err.Error())
+		return
+	}
+
+	now := time.Now()
+	result := database.DB.Model(&models.KnowledgeFile{}).
+		Where("knowledge_id = ? AND file_id = ?", knowledgeID, fileID).
+		Updates(map[string]interface{}{
+			"status":      models.IngestionIndexed,
+			"error":       "",
+			"chunk_count": count,
+			"indexed_at":  &now,
+		})
+	if result.Error == nil && result.RowsAffected == 0 {
+		// Removed from the knowledge base while it was being indexed
+		var knowledge models.Knowledge
+		if database.DB.First(&knowledge, knowledgeID).Error == nil {
+			RemoveFromKnowledge(knowledge, fileID)
+		}
+		return
+	}
+	notifyIngestion(knowledgeID, fileID, models.IngestionIndexed, count, "")
+}
+
+func ingestFile(knowledgeID uint, fileID uint) (int, error) {
+	var knowledge models.Knowledge
+	if result := database.DB.First(&knowledge, knowledgeID); result.Error != nil {
+		return 0, result.Error
+	}
+	var file models.File
+	if result := database.DB.First(&file, fileID); result.Error != nil {
+		return 0, result.Error
+	}
+
+	text, err := ExtractFileText(file)
+	if err != nil {
+		return 0, err
+	}
+
+	size, overlap := ChunkSettings(knowledge.ChunkSize, knowledge.ChunkOverlap)
+	chunks := ChunkText(text, size, overlap)
+
+	rows := make([]models.KnowledgeChunk, 0, len(chunks))
+	for _, c := range chunks {
+		rows = append(rows, models.KnowledgeChunk{
+			KnowledgeID: knowledgeID,
+			FileID:      fileID,
+			ChunkIndex:  c.Index,
+			Content:     c.Content,
+			StartOffset: c.Start,
+			EndOffset:   c.End,
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteKnowledgeChunks(tx, knowledgeID, fileID); err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rows, 200).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := embedKnowledgeChunks(&knowledge, fileID, rows); err != nil {
+		return 0, fmt.Errorf("embedding failed: %w", err)
+	}
+	return len(rows), nil
+}
+
+// DeleteKnowledgeChunks removes the chunks of a file from a knowledge base.
+// A fileID of 0 removes every chunk of the knowledge base.
+func DeleteKnowledgeChunks(tx *gorm.DB, knowledgeID uint, fileID uint) error {
+	query := tx.Where("knowledge_id = ?", knowledgeID)
+	if fileID != 0 {
+		query = query.Where("file_id = ?", fileID)
+	}
+	return query.Delete(&models.KnowledgeChunk{}).Error
+}
+
+// RemoveFromKnowledge removes a file from a knowledge base along with its
+// chunks and vectors. A fileID of 0 removes all of them along with the
+// knowledge base's vector collection.
+func RemoveFromKnowledge(knowledge models.Knowledge, fileID uint) error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteKnowledgeChunks(tx, knowledge.ID, fileID); err != nil {
+			return err
+		}
+		query := tx.Where("knowledge_id = ?", knowledge.ID)
+		if fileID != 0 {
+			query = query.Where("file_id = ?", fileID)
+		}
+		return query.Delete(&models.KnowledgeFile{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	forgetReindexFile(knowledge.ID, fileID)
+
+	if fileID == 0 {
+		return DeleteKnowledgeCollection(knowledge)
+	}
+	if knowledgeVectors == nil {
+		return nil
+	}
+	return knowledgeVectors.DeleteFile(knowledge.CollectionName, fileID)
+}
+
+// RemoveFileFromKnowledges removes a file from every knowledge base that
+// contains it, purging its chunks and vectors
+func RemoveFileFromKnowledges(fileID uint) error {
+	var knowledges []models.Knowledge
+	err := database.DB.
+		Joins("JOIN knowledge_files ON knowledge_files.knowledge_id = knowledges.id").
+		Where("knowledge_files.file_id = ?", fileID).
+		Find(&knowledges).Error
+	if err != nil {
+		return err
+	}
+
+	for _, knowledge := range knowledges {
+		if err := RemoveFromKnowledge(knowledge, fileID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setIngestionStatus updates the status of a file in a knowledge base. It
+// never adds the file, so a file removed while queued stays removed.
+func setIngestionStatus(knowledgeID uint, fileID uint, status string, message string) error {
+	result := database.DB.Model(&models.KnowledgeFile{}).
+		Where("knowledge_id = ? AND file_id = ?", knowledgeID, fileID).
+		Updates(map[string]interface{}{"status": status, "error": message})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrFileNotInKnowledge
+	}
+	notifyIngestion(knowledgeID, fileID, status, 0, message)
+	return nil
+}
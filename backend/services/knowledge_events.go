@@ -0,0 +1,202 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"backend/database"
+	"backend/models"
+)
+
+// KnowledgeNotifier delivers knowledge base indexing events to a room. It is
+// satisfied by RoomBroadcaster.
+type KnowledgeNotifier interface {
+	BroadcastToRoom(room string, event string, v interface{})
+}
+
+// ErrReindexInProgress is returned when a knowledge base is already being reindexed
+var ErrReindexInProgress = errors.New("knowledge base is already being reindexed")
+
+// Reindex states reported by knowledgeReindex events
+const (
+	ReindexStarted   = "started"
+	ReindexProgress  = "progress"
+	ReindexCompleted = "completed"
+	ReindexFailed    = "failed"
+)
+
+// KnowledgeFileStatusEvent is sent to the owner's user room whenever the
+// ingestion status of a file in one of their knowledge bases changes
+type KnowledgeFileStatusEvent struct {
+	KnowledgeID uint   `json:"knowledge_id"`
+	FileID      uint   `json:"file_id"`
+	Status      string `json:"status"`
+	ChunkCount  int    `json:"chunk_count"`
+	Error       string `json:"error,omitempty"`
+}
+
+// KnowledgeReindexEvent reports the progress of a reindex to the owner's user room
+type KnowledgeReindexEvent struct {
+	KnowledgeID uint   `json:"knowledge_id"`
+	Status      string `json:"status"`
+	Total       int    `json:"total"`
+	Done        int    `json:"done"`
+	Failed      int    `json:"failed"`
+	Error       string `json:"error,omitempty"`
+}
+
+// reindexProgress tracks the files of a running reindex that have not
+// finished indexing yet
+type reindexProgress struct {
+	userID  uint
+	pending map[uint]bool
+	event   KnowledgeReindexEvent
+}
+
+var (
+	knowledgeNotifier KnowledgeNotifier
+	reindexMu         sync.Mutex
+	reindexes         = map[uint]*reindexProgress{}
+)
+
+// SetKnowledgeNotifier sets where knowledge base indexing events are sent.
+// Without a notifier no events are sent.
+func SetKnowledgeNotifier(n KnowledgeNotifier) {
+	knowledgeNotifier = n
+}
+
+// ReindexKnowledge rebuilds the vector collection of a knowledge base in the
+// background and indexes all of its files again. It returns the number of
+// files queued; progress is reported with knowledgeReindex events.
+func ReindexKnowledge(knowledge models.Knowledge) (int, error) {
+	fileIDs, err := KnowledgeFileIDs(knowledge.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	progress := &reindexProgress{
+		userID:  knowledge.UserID,
+		pending: make(map[uint]bool, len(fileIDs)),
+		event:   KnowledgeReindexEvent{KnowledgeID: knowledge.ID, Status: ReindexStarted, Total: len(fileIDs)},
+	}
+	for _, id := range fileIDs {
+		progress.pending[id] = true
+	}
+
+	reindexMu.Lock()
+	if _, running := reindexes[knowledge.ID]; running {
+		reindexMu.Unlock()
+		return 0, ErrReindexInProgress
+	}
+	if len(fileIDs) > 0 {
+		reindexes[knowledge.ID] = progress
+	}
+	reindexMu.Unlock()
+
+	notifyKnowledge(knowledge.UserID, "knowledgeReindex", progress.event)
+
+	go func() {
+		err := RebuildKnowledgeCollection(knowledge)
+		if err == nil && len(fileIDs) > 0 {
+			return
+		}
+
+		reindexMu.Lock()
+		delete(reindexes, knowledge.ID)
+		reindexMu.Unlock()
+
+		event := progress.event
+		event.Status = ReindexCompleted
+		if err != nil {
+			log.Printf("Reindex of knowledge %d failed: %v", knowledge.ID, err)
+			event.Status = ReindexFailed
+			event.Error = err.Error()
+		}
+		notifyKnowledge(knowledge.UserID, "knowledgeReindex", event)
+	}()
+	return len(fileIDs), nil
+}
+
+// notifyIngestion reports a file status change and, when the knowledge base
+// is being reindexed and the file has finished, the reindex progress
+func notifyIngestion(knowledgeID uint, fileID uint, status string, chunkCount int, message string) {
+	reindexMu.Lock()
+	progress := reindexes[knowledgeID]
+	var reindexEvent *KnowledgeReindexEvent
+	if progress != nil && progress.pending[fileID] && (status == models.IngestionIndexed || status == models.IngestionFailed) {
+		delete(progress.pending, fileID)
+		if status == models.IngestionFailed {
+			progress.event.Failed++
+		} else {
+			progress.event.Done++
+		}
+		progress.event.Status = ReindexProgress
+		if len(progress.pending) == 0 {
+			progress.event.Status = ReindexCompleted
+			delete(reindexes, knowledgeID)
+		}
+		event := progress.event
+		reindexEvent = &event
+	}
+	reindexMu.Unlock()
+	if knowledgeNotifier == nil {
+		return
+	}
+
+	var userID uint
+	if progress != nil {
+		userID = progress.userID
+	} else {
+		var knowledge models.Knowledge
+		if result := database.DB.Select("user_id").First(&knowledge, knowledgeID); result.Error != nil {
+			return
+		}
+		userID = knowledge.UserID
+	}
+
+	notifyKnowledge(userID, "knowledgeFileStatus", KnowledgeFileStatusEvent{
+		KnowledgeID: knowledgeID,
+		FileID:      fileID,
+		Status:      status,
+		ChunkCount:  chunkCount,
+		Error:       message,
+	})
+	if reindexEvent != nil {
+		notifyKnowledge(userID, "knowledgeReindex", *reindexEvent)
+	}
+}
+
+func notifyKnowledge(userID uint, event string, v interface{}) {
+	if knowledgeNotifier != nil {
+		knowledgeNotifier.BroadcastToRoom(UserRoom(userID), event, v)
+	}
+}
+
+// forgetReindexFile stops waiting for a file removed from a knowledge base
+// during a reindex. A fileID of 0 abandons the reindex altogether.
+func forgetReindexFile(knowledgeID uint, fileID uint) {
+	reindexMu.Lock()
+	progress := reindexes[knowledgeID]
+	if progress == nil || (fileID != 0 && !progress.pending[fileID]) {
+		reindexMu.Unlock()
+		return
+	}
+	if fileID == 0 {
+		delete(reindexes, knowledgeID)
+		reindexMu.Unlock()
+		return
+	}
+	delete(progress.pending, fileID)
+	progress.event.Total--
+	if len(progress.pending) > 0 {
+		reindexMu.Unlock()
+		return
+	}
+	delete(reindexes, knowledgeID)
+	progress.event.Status = ReindexCompleted
+	event := progress.event
+	reindexMu.Unlock()
+
+	notifyKnowledge(progress.userID, "knowledgeReindex", event)
+}
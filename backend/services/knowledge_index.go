@@ -0,0 +1,217 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"backend/database"
+	"backend/models"
+)
+
+var (
+	knowledgeEmbedder Embedder
+	knowledgeVectors  VectorStore
+
+	// collectionMu serializes collection creation so that concurrent ingestion
+	// workers do not recreate the same collection
+	collectionMu sync.Mutex
+)
+
+// InitKnowledgeIndex sets up the embedder and vector store used to index
+// knowledge bases, as selected by the EMBEDDING_* and VECTOR_STORE settings
+func InitKnowledgeIndex() error {
+	embedder, err := NewEmbedderFromConfig()
+	if err != nil {
+		return err
+	}
+	store, err := NewVectorStoreFromConfig()
+	if err != nil {
+		return err
+	}
+	SetKnowledgeIndex(embedder, store)
+	return nil
+}
+
+// SetKnowledgeIndex replaces the embedder and vector store used to index
+// knowledge bases
+func SetKnowledgeIndex(embedder Embedder, store VectorStore) {
+	knowledgeEmbedder = embedder
+	knowledgeVectors = store
+}
+
+// KnowledgeEmbedder returns the embedder used to index knowledge bases
+func KnowledgeEmbedder() Embedder {
+	return knowledgeEmbedder
+}
+
+// KnowledgeVectors returns the vector store holding knowledge base embeddings
+func KnowledgeVectors() VectorStore {
+	return knowledgeVectors
+}
+
+// KnowledgeCollectionName derives a knowledge base's collection name from its
+// name. The ID suffix keeps names that slugify alike apart.
+func KnowledgeCollectionName(name string, id uint) string {
+	return fmt.Sprintf("%s-%d", strings.ToLower(strings.ReplaceAll(name, " ", "-")), id)
+}
+
+// EnsureKnowledgeCollection makes sure a knowledge base has a collection
+// built with the current embedder. When the collection is missing or was
+// built by another embedder it is (re)created empty, the knowledge row is
+// updated and true is returned: the knowledge base's files must be indexed again.
+func EnsureKnowledgeCollection(knowledge *models.Knowledge) (bool, error) {
+	if knowledgeEmbedder == nil || knowledgeVectors == nil {
+		return false, fmt.Errorf("knowledge index is not initialized")
+	}
+
+	collectionMu.Lock()
+	defer collectionMu.Unlock()
+
+	// Another worker may have rebuilt the collection meanwhile
+	if result := database.DB.First(knowledge, knowledge.ID); result.Error != nil {
+		return false, result.Error
+	}
+	exists, err := knowledgeVectors.HasCollection(knowledge.CollectionName)
+	if err != nil {
+		return false, err
+	}
+	if exists && knowledge.EmbeddingModel == knowledgeEmbedder.Name() {
+		return false, nil
+	}
+
+	// Embed a probe to learn the vector size of the current embedder
+	probe, err := knowledgeEmbedder.Embed([]string{knowledge.Name})
+	if err != nil {
+		return false, err
+	}
+	if len(probe) != 1 || len(probe[0]) == 0 {
+		return false, fmt.Errorf("embedder %s returned no vector", knowledgeEmbedder.Name())
+	}
+	if err := knowledgeVectors.CreateCollection(knowledge.CollectionName, len(probe[0])); err != nil {
+		return false, err
+	}
+
+	knowledge.EmbeddingModel = knowledgeEmbedder.Name()
+	knowledge.EmbeddingDimensions = len(probe[0])
+	result := database.DB.Model(knowledge).Updates(map[string]interface{}{
+		"embedding_model":      knowledge.EmbeddingModel,
+		"embedding_dimensions": knowledge.EmbeddingDimensions,
+	})
+	return true, result.Error
+}
+
+// RebuildKnowledgeCollection recreates a knowledge base's collection with
+// the current embedder and queues all of its files for indexing
+func RebuildKnowledgeCollection(knowledge models.Knowledge) error {
+	if knowledgeVectors == nil {
+		return fmt.Errorf("knowledge index is not initialized")
+	}
+	// Clearing the model forces EnsureKnowledgeCollection to recreate it
+	knowledge.EmbeddingModel = ""
+	if err := database.DB.Model(&knowledge).Update("embedding_model", "").Error; err != nil {
+		return err
+	}
+	if _, err := EnsureKnowledgeCollection(&knowledge); err != nil {
+		return err
+	}
+	return requeueKnowledgeFiles(knowledge, 0)
+}
+
+// DeleteKnowledgeCollection removes a knowledge base's vectors
+func DeleteKnowledgeCollection(knowledge models.Knowledge) error {
+	if knowledgeVectors == nil {
+		return nil
+	}
+	return knowledgeVectors.DeleteCollection(knowledge.CollectionName)
+}
+
+// SyncKnowledgeCollections rebuilds the collections that are missing from the
+// vector store, e.g. after a restart with the in-memory store, or that were
+// built with another embedder
+func SyncKnowledgeCollections() {
+	var knowledges []models.Knowledge
+	if result := database.DB.Find(&knowledges); result.Error != nil {
+		log.Printf("Failed to load knowledge bases: %v", result.Error)
+		return
+	}
+
+	for _, k := range knowledges {
+		// Older knowledge bases may share slug-only collection names
+		if !strings.HasSuffix(k.CollectionName, fmt.Sprintf("-%d", k.ID)) {
+			k.CollectionName = KnowledgeCollectionName(k.Name, k.ID)
+			database.DB.Model(&k).Update("collection_name", k.CollectionName)
+		}
+
+		rebuilt, err := EnsureKnowledgeCollection(&k)
+		if err != nil {
+			log.Printf("Failed to prepare the collection of knowledge %d: %v", k.ID, err)
+			continue
+		}
+		if rebuilt {
+			if err := requeueKnowledgeFiles(k, 0); err != nil {
+				log.Printf("Failed to queue the files of knowledge %d: %v", k.ID, err)
+			}
+		}
+	}
+}
+
+// embedKnowledgeChunks embeds a file's chunks and stores the vectors in the
+// knowledge base's collection, replacing the file's previous vectors
+func embedKnowledgeChunks(knowledge *models.Knowledge, fileID uint, chunks []models.KnowledgeChunk) error {
+	rebuilt, err := EnsureKnowledgeCollection(knowledge)
+	if err != nil {
+		return err
+	}
+	if rebuilt {
+		// The other files' vectors were dropped with the old collection
+		if err := requeueKnowledgeFiles(*knowledge, fileID); err != nil {
+			return err
+		}
+	}
+
+	if err := knowledgeVectors.DeleteFile(knowledge.CollectionName, fileID); err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	texts := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		texts = append(texts, c.Content)
+	}
+	vectors, err := EmbedBatched(knowledgeEmbedder, texts)
+	if err != nil {
+		return err
+	}
+
+	records := make([]VectorRecord, 0, len(chunks))
+	for i, c := range chunks {
+		if len(vectors[i]) != knowledge.EmbeddingDimensions {
+			return fmt.Errorf("embedder returned %d dimensions, collection expects %d", len(vectors[i]), knowledge.EmbeddingDimensions)
+		}
+		records = append(records, VectorRecord{ChunkID: c.ID, FileID: fileID, Vector: vectors[i]})
+	}
+	return knowledgeVectors.Upsert(knowledge.CollectionName, records)
+}
+
+// requeueKnowledgeFiles queues every file of a knowledge base for indexing,
+// except skipFileID
+func requeueKnowledgeFiles(knowledge models.Knowledge, skipFileID uint) error {
+	fileIDs, err := KnowledgeFileIDs(knowledge.ID)
+	if err != nil {
+		return err
+	}
+	for _, fileID := range fileIDs {
+		if fileID == skipFileID {
+			continue
+		}
+		// A file removed in the meantime is simply skipped
+		if err := EnqueueIngestion(knowledge.ID, fileID); err != nil && err != ErrFileNotInKnowledge {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"backend/config"
 	"backend/models"
@@ -86,3 +87,28 @@ func CallOpenAI(request models.OpenAIChatRequest) (*models.OpenAIChatResponse, e
 
 	return &response, nil
 }
+
+// CompleteText sends messages to a chat model and returns the reply. Model
+// uses the provider prefixes of chat completions, e.g. "ollama/llama3".
+func CompleteText(model string, messages []models.Message) (string, error) {
+	vision := HasImageAttachments(messages) && ModelSupportsVision(model)
+	switch {
+	case strings.HasPrefix(model, "ollama/"):
+		res, err := CallOllama(models.OllamaChatRequest{Model: strings.TrimPrefix(model, "ollama/"), Messages: OllamaMessages(messages, vision)})
+		if err != nil {
+			return "", err
+		}
+		return res.Message.Content, nil
+	case strings.HasPrefix(model, "openai/"):
+		res, err := CallOpenAI(models.OpenAIChatRequest{Model: strings.TrimPrefix(model, "openai/"), Messages: OpenAIMessages(messages, vision)})
+		if err != nil {
+			return "", err
+		}
+		if len(res.Choices) == 0 {
+			return "", fmt.Errorf("model returned no choices")
+		}
+		return res.Choices[0].Message.Content, nil
+	default:
+		return "", fmt.Errorf("unsupported model %q", model)
+	}
+}
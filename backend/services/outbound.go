@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// maxOutboundRedirects is how many redirects a request to a user-supplied URL
+// may follow, as many as net/http follows by default
+const maxOutboundRedirects = 10
+
+// ErrPrivateAddress is returned when a request to a user-supplied URL would
+// reach an address that is not publicly routable
+var ErrPrivateAddress = errors.New("address is not publicly routable")
+
+// ErrRedirectNotAllowed is returned when a user-supplied URL redirects to a
+// host it may not reach
+var ErrRedirectNotAllowed = errors.New("redirect is not allowed")
+
+// allowPrivateAddresses turns the address check off. Tests set it to reach
+// their servers, which listen on loopback.
+var allowPrivateAddresses bool
+
+// nonPublicNetworks are the ranges not covered by the net.IP predicates that
+// must not be reached either: "this network", carrier-grade NAT and
+// benchmarking
+var nonPublicNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "198.18.0.0/15"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// publicIP reports whether an IP address is publicly routable: not loopback,
+// private, link-local (which includes cloud metadata endpoints), multicast or
+// unspecified
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPublicAddress is a net.Dialer Control function that refuses to connect
+// to addresses that are not publicly routable. It runs on the address DNS
+// resolved to, so host names that point inside the network are refused too.
+func checkPublicAddress(network string, address string, _ syscall.RawConn) error {
+	if allowPrivateAddresses {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// publicTransport connects only to publicly routable addresses. It ignores
+// proxy settings, which would otherwise be the address checked.
+var publicTransport = func() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkPublicAddress,
+	}).DialContext
+	return transport
+}()
+
+// newPublicHTTPClient returns a client for URLs supplied by users, such as
+// web pages to crawl and tool servers. It only connects to publicly routable
+// addresses, including when following redirects, and follows a redirect only
+// when allowRedirect, if not nil, accepts its URL.
+func newPublicHTTPClient(timeout time.Duration, allowRedirect func(*url.URL) bool) *http.Client {
+	return &http.Client{
+		Transport: publicTransport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxOutboundRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxOutboundRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("%w: %s", ErrRedirectNotAllowed, req.URL)
+			}
+			if allowRedirect != nil && !allowRedirect(req.URL) {
+				return fmt.Errorf("%w: %s", ErrRedirectNotAllowed, req.URL)
+			}
+			return nil
+		},
+	}
+}
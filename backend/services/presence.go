@@ -0,0 +1,123 @@
+package services
+
+import (
+	"sort"
+	"sync"
+)
+
+// PresenceUser is a user currently connected to a chat room
+type PresenceUser struct {
+	UserID uint   `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// PresenceTracker keeps track of which users are connected to which chat
+// rooms. A user connected from several sockets is listed once.
+type PresenceTracker struct {
+	mu    sync.Mutex
+	rooms map[uint]map[string]PresenceUser // chat ID -> socket ID -> user
+}
+
+// NewPresenceTracker creates an empty PresenceTracker
+func NewPresenceTracker() *PresenceTracker {
+	return &PresenceTracker{rooms: make(map[uint]map[string]PresenceUser)}
+}
+
+// Join records that a socket joined a chat and returns the chat's members
+func (p *PresenceTracker) Join(chatID uint, socketID string, user PresenceUser) []PresenceUser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sockets, ok := p.rooms[chatID]
+	if !ok {
+		sockets = make(map[string]PresenceUser)
+		p.rooms[chatID] = sockets
+	}
+	sockets[socketID] = user
+	return p.membersLocked(chatID)
+}
+
+// Leave records that a socket left a chat and returns the remaining members.
+// The boolean is false when the socket was not in the chat.
+func (p *PresenceTracker) Leave(chatID uint, socketID string) ([]PresenceUser, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sockets, ok := p.rooms[chatID]
+	if !ok {
+		return nil, false
+	}
+	if _, ok := sockets[socketID]; !ok {
+		return nil, false
+	}
+	delete(sockets, socketID)
+	if len(sockets) == 0 {
+		delete(p.rooms, chatID)
+	}
+	return p.membersLocked(chatID), true
+}
+
+// LeaveAll removes a socket from every chat, e.g. on disconnect, and returns
+// the remaining members of each chat it was in
+func (p *PresenceTracker) LeaveAll(socketID string) map[uint][]PresenceUser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	left := make(map[uint][]PresenceUser)
+	for chatID, sockets := range p.rooms {
+		if _, ok := sockets[socketID]; !ok {
+			continue
+		}
+		delete(sockets, socketID)
+		if len(sockets) == 0 {
+			delete(p.rooms, chatID)
+		}
+		left[chatID] = p.membersLocked(chatID)
+	}
+	return left
+}
+
+// InRoom reports whether a socket has joined a chat
+func (p *PresenceTracker) InRoom(chatID uint, socketID string) (PresenceUser, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.rooms[chatID][socketID]
+	return user, ok
+}
+
+// Clients returns the sockets through which a user has joined a chat
+func (p *PresenceTracker) Clients(chatID uint, userID uint) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var clients []string
+	for socketID, user := range p.rooms[chatID] {
+		if user.UserID == userID {
+			clients = append(clients, socketID)
+		}
+	}
+	return clients
+}
+
+// Members returns the distinct users connected to a chat, ordered by user ID
+func (p *PresenceTracker) Members(chatID uint) []PresenceUser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.membersLocked(chatID)
+}
+
+func (p *PresenceTracker) membersLocked(chatID uint) []PresenceUser {
+	seen := make(map[uint]bool)
+	members := []PresenceUser{}
+	for _, user := range p.rooms[chatID] {
+		if seen[user.UserID] {
+			continue
+		}
+		seen[user.UserID] = true
+		members = append(members, user)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+
+	"gorm.io/gorm"
+)
+
+// DefaultFileRetentionDays is how long deleted files are kept in storage when
+// FILE_RETENTION_DAYS is not set
+const DefaultFileRetentionDays = 30
+
+// ErrQuotaExceeded is returned when a file would take a user over their
+// storage quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+var retentionOnce sync.Once
+
+// RoleStorageQuota returns the default quota of a role in bytes from
+// STORAGE_QUOTA_<ROLE>, falling back to STORAGE_QUOTA. 0 is unlimited.
+func RoleStorageQuota(role string) int64 {
+	value := config.Config("STORAGE_QUOTA_" + strings.ToUpper(role))
+	if value == "" {
+		value = config.Config("STORAGE_QUOTA")
+	}
+	quota, _ := strconv.ParseInt(value, 10, 64)
+	if quota < 0 {
+		return 0
+	}
+	return quota
+}
+
+// GroupStorageQuota returns the most generous quota of the groups a user is
+// in, and false when none of them sets one. 0 is unlimited.
+func GroupStorageQuota(userID uint) (int64, bool) {
+	var quotas []int64
+	database.DB.Model(&models.Group{}).
+		Joins("JOIN group_members ON group_members.group_id = groups.id").
+		Where("group_members.user_id = ? AND groups.storage_quota IS NOT NULL", userID).
+		Pluck("groups.storage_quota", &quotas)
+	if len(quotas) == 0 {
+		return 0, false
+	}
+	best := quotas[0]
+	for _, q := range quotas {
+		if q <= 0 {
+			return 0, true
+		}
+		if q > best {
+			best = q
+		}
+	}
+	return best, true
+}
+
+// UserStorageQuota returns a user's quota in bytes: their own StorageQuota,
+// the most generous one of their groups, or their role's default. 0 is
+// unlimited.
+func UserStorageQuota(user models.User) int64 {
+	if user.StorageQuota != nil {
+		return *user.StorageQuota
+	}
+	if quota, ok := GroupStorageQuota(user.ID); ok {
+		return quota
+	}
+	return RoleStorageQuota(user.Role)
+}
+
+// StorageUsed returns the bytes taken by a user's files
+func StorageUsed(userID uint) (int64, error) {
+	return storageUsed(database.DB, userID)
+}
+
+func storageUsed(db *gorm.DB, userID uint) (int64, error) {
+	var used int64
+	result := db.Model(&models.File{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size), 0)").Scan(&used)
+	return used, result.Error
+}
+
+// CheckStorageQuota returns ErrQuotaExceeded if adding size bytes would take
+// a user over their quota. Uploads check it early; reserveStorage settles it
+// when the file is created.
+func CheckStorageQuota(userID uint, size int64) error {
+	return checkStorageQuota(database.DB, userID, size)
+}
+
+func checkStorageQuota(db *gorm.DB, userID uint, size int64) error {
+	var user models.User
+	if result := db.First(&user, userID); result.Error != nil {
+		return result.Error
+	}
+	quota := UserStorageQuota(user)
+	if quota == 0 {
+		return nil
+	}
+	used, err := storageUsed(db, userID)
+	if err != nil {
+		return err
+	}
+	if used+size > quota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// reserveStorage checks that size more bytes fit in a user's quota while
+// holding a lock on the user's storage until the end of the transaction, in
+// which the caller saves the file. Concurrent uploads are checked one after
+// the other, so they cannot together go over the quota. Exceeding it is
+// reported as a *FileRejectedError.
+func reserveStorage(tx *gorm.DB, userID uint, size int64) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", fmt.Sprintf("storage:%d", userID)).Error; err != nil {
+		return err
+	}
+	if err := checkStorageQuota(tx, userID, size); err == ErrQuotaExceeded {
+		return &FileRejectedError{Err: err}
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UserStorageUsage returns a user's total storage use with a breakdown by
+// folder and by MIME type
+func UserStorageUsage(userID uint) (models.StorageUsage, error) {
+	var user models.User
+	if result := database.DB.First(&user, userID); result.Error != nil {
+		return models.StorageUsage{}, result.Error
+	}
+	usage := models.StorageUsage{Quota: UserStorageQuota(user)}
+
+	result := database.DB.Model(&models.File{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size), 0) AS total_bytes, COUNT(*) AS file_count").Scan(&usage)
+	if result.Error != nil {
+		return usage, result.Error
+	}
+
+	result = database.DB.Table("files").
+		Select("files.folder_id, COALESCE(folders.name, '') AS folder_name, SUM(files.size) AS total_bytes, COUNT(*) AS file_count").
+		Joins("LEFT JOIN folders ON folders.id = files.folder_id").
+		Where("files.user_id = ? AND files.deleted_at IS NULL", userID).
+		Group("files.folder_id, folders.name").
+		Order("total_bytes DESC").
+		Scan(&usage.ByFolder)
+	if result.Error != nil {
+		return usage, result.Error
+	}
+
+	result = database.DB.Model(&models.File{}).
+		Select("COALESCE(NULLIF(mime_type, ''), 'application/octet-stream') AS mime_type, SUM(size) AS total_bytes, COUNT(*) AS file_count").
+		Where("user_id = ?", userID).
+		Group("COALESCE(NULLIF(mime_type, ''), 'application/octet-stream')").
+		Order("total_bytes DESC").
+		Scan(&usage.ByMimeType)
+	return usage, result.Error
+}
+
+// HeaviestStorageUsers returns the users whose files take the most storage
+func HeaviestStorageUsers(limit int) ([]models.UserStorageReport, error) {
+	var rows []struct {
+		models.UserStorageReport
+		Role         string
+		StorageQuota *int64
+	}
+	result := database.DB.Table("users").
+		Select("users.id AS user_id, users.name, users.email, users.role, users.storage_quota, COALESCE(SUM(files.size), 0) AS total_bytes, COUNT(files.id) AS file_count").
+		Joins("LEFT JOIN files ON files.user_id = users.id AND files.deleted_at IS NULL").
+		Where("users.deleted_at IS NULL").
+		Group("users.id").
+		Order("total_bytes DESC, users.id").
+		Limit(limit).
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	reports := make([]models.UserStorageReport, len(rows))
+	for i, row := range rows {
+		reports[i] = row.UserStorageReport
+		reports[i].Quota = UserStorageQuota(models.User{ID: row.UserID, Role: row.Role, StorageQuota: row.StorageQuota})
+	}
+	return reports, nil
+}
+
+// fileRetention is how long deleted files are kept before they are purged
+func fileRetention() time.Duration {
+	days, err := strconv.Atoi(config.Config("FILE_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		days = DefaultFileRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// StartFileRetention starts purging files deleted more than
+// FILE_RETENTION_DAYS ago: their rows are removed for good and their
+// content is deleted from storage once no other file refers to it. Calling
+// it more than once has no effect.
+func StartFileRetention() {
+	retentionOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for {
+				PurgeDeletedFiles()
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+// PurgeDeletedFiles purges the files whose retention period has elapsed and
+// returns how many were purged
+func PurgeDeletedFiles() int {
+	var files []models.File
+	cutoff := time.Now().Add(-fileRetention())
+	database.DB.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at <= ?", cutoff).Limit(1000).Find(&files)
+
+	purged := 0
+	for _, file := range files {
+		DeleteFileArtifacts(file.ID)
+		if result := database.DB.Unscoped().Delete(&file); result.Error != nil {
+			log.Printf("Failed to purge file %d: %v", file.ID, result.Error)
+			continue
+		}
+		if err := DeleteFileBlob(file); err != nil {
+			log.Printf("Failed to delete content of purged file %d: %v", file.ID, err)
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("Purged %d deleted files", purged)
+	}
+	return purged
+}
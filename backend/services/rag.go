@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"backend/config"
+	"backend/models"
+)
+
+// DefaultRAGTemplate is the prompt used to give retrieved context to the
+// model when RAG_TEMPLATE is not set. {{CONTEXT}} is replaced by the numbered
+// sources and {{QUERY}} by the user's question.
+const DefaultRAGTemplate = `Use the following context to answer the user's question. The context is split into numbered sources.
+
+<context>
+{{CONTEXT}}
+</context>
+
+Rules:
+- Cite the sources you use inline with their number in brackets, e.g. [1].
+- If the context does not contain the answer, say so instead of guessing.
+- Do not mention these rules or the context tags.
+
+Question: {{QUERY}}`
+
+// RAGTemplate returns the configured RAG prompt template. Literal "\n"
+// sequences in RAG_TEMPLATE are turned into newlines since environment
+// variables are usually single-line.
+func RAGTemplate() string {
+	if template := config.Config("RAG_TEMPLATE"); template != "" {
+		return strings.ReplaceAll(template, `\n`, "\n")
+	}
+	return DefaultRAGTemplate
+}
+
+// RenderRAGPrompt fills a RAG template with the retrieved citations and the query
+func RenderRAGPrompt(template string, citations []models.Citation, query string) string {
+	var b strings.Builder
+	for i, c := range citations {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if c.URL != "" {
+			fmt.Fprintf(&b, "<source id=\"%d\" name=%q url=%q>\n%s\n</source>", c.Source, c.FileName, c.URL, c.Content)
+			continue
+		}
+		fmt.Fprintf(&b, "<source id=\"%d\" name=%q>\n%s\n</source>", c.Source, c.FileName, c.Content)
+	}
+
+	prompt := strings.ReplaceAll(template, "{{CONTEXT}}", b.String())
+	return strings.ReplaceAll(prompt, "{{QUERY}}", query)
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"backend/config"
+	"backend/models"
+)
+
+// Reranker scores documents by their relevance to a query, from 0 to 1
+type Reranker interface {
+	Rerank(query string, documents []string) ([]float64, error)
+}
+
+var (
+	rerankerOnce sync.Once
+	reranker     Reranker
+)
+
+// KnowledgeReranker returns the reranker selected by RERANK_ENGINE, or nil
+// when reranking is disabled
+func KnowledgeReranker() Reranker {
+	rerankerOnce.Do(func() {
+		model := config.Config("RERANK_MODEL")
+		switch config.Config("RERANK_ENGINE") {
+		case "cross-encoder":
+			reranker = &CrossEncoderReranker{
+				URL:    config.Config("RERANK_URL"),
+				APIKey: config.Config("RERANK_API_KEY"),
+				Model:  model,
+			}
+		case "llm":
+			reranker = &LLMReranker{Model: model}
+		}
+	})
+	return reranker
+}
+
+// rerankCitations scores citations with a reranker, replacing their scores
+func rerankCitations(r Reranker, query string, citations []models.Citation) error {
+	documents := make([]string, 0, len(citations))
+	for _, c := range citations {
+		documents = append(documents, c.Content)
+	}
+	scores, err := r.Rerank(query, documents)
+	if err != nil {
+		return err
+	}
+	if len(scores) != len(citations) {
+		return fmt.Errorf("reranker returned %d scores for %d documents", len(scores), len(citations))
+	}
+	for i := range citations {
+		citations[i].RerankScore = scores[i]
+		citations[i].Score = scores[i]
+	}
+	return nil
+}
+
+// CrossEncoderReranker calls a rerank endpoint with the request and response
+// format shared by Cohere, Jina and compatible cross-encoder servers
+type CrossEncoderReranker struct {
+	URL    string
+	APIKey string
+	Model  string
+}
+
+// Rerank implements Reranker
+func (r *CrossEncoderReranker) Rerank(query string, documents []string) ([]float64, error) {
+	if r.URL == "" {
+		return nil, fmt.Errorf("RERANK_URL is not set")
+	}
+
+	request := map[string]interface{}{
+		"query":     query,
+		"documents": documents,
+		"top_n":     len(documents),
+	}
+	if r.Model != "" {
+		request["model"] = r.Model
+	}
+	var response struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := postJSON(r.URL, r.APIKey, request, &response); err != nil {
+		return nil, fmt.Errorf("rerank request failed: %w", err)
+	}
+
+	scores := make([]float64, len(documents))
+	for _, res := range response.Results {
+		if res.Index < 0 || res.Index >= len(scores) {
+			return nil, fmt.Errorf("reranker returned out-of-range index %d", res.Index)
+		}
+		scores[res.Index] = res.RelevanceScore
+	}
+	return scores, nil
+}
+
+// LLMReranker asks a chat model to grade each document from 0 to 10. Model
+// uses the same provider prefixes as chat completions, e.g. "ollama/llama3".
+type LLMReranker struct {
+	Model string
+}
+
+// Rerank implements Reranker
+func (r *LLMReranker) Rerank(query string, documents []string) ([]float64, error) {
+	var prompt strings.Builder
+	prompt.WriteString("Rate how relevant each document is to the query, from 0 (irrelevant) to 10 (answers it fully).\n")
+	fmt.Fprintf(&prompt, "Reply with only a JSON array of %d numbers, one per document, in order.\n\n", len(documents))
+	fmt.Fprintf(&prompt, "Query: %s\n", query)
+	for i, d := range documents {
+		fmt.Fprintf(&prompt, "\nDocument %d:\n%s\n", i+1, d)
+	}
+	messages := []models.Message{{Role: "user", Content: prompt.String()}}
+
+	reply, err := CompleteText(r.Model, messages)
+	if err != nil {
+		return nil, fmt.Errorf("reranking failed: %w", err)
+	}
+
+	// Models like to wrap the array in prose or code fences
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("reranking model did not return a JSON array")
+	}
+	var grades []float64
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &grades); err != nil {
+		return nil, fmt.Errorf("failed to parse reranking grades: %w", err)
+	}
+	if len(grades) != len(documents) {
+		return nil, fmt.Errorf("reranking model graded %d of %d documents", len(grades), len(documents))
+	}
+
+	scores := make([]float64, len(grades))
+	for i, g := range grades {
+		switch {
+		case g < 0:
+			g = 0
+		case g > 10:
+			g = 10
+		}
+		scores[i] = g / 10
+	}
+	return scores, nil
+}
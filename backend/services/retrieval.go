@@ -0,0 +1,576 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+)
+
+// DefaultRAGTopK is the number of chunks given to the model when RAG_TOP_K is not set
+const DefaultRAGTopK = 5
+
+// maxDirectFileChunks caps how many chunks of a file that is not in any
+// knowledge base are embedded on the fly for a single request
+const maxDirectFileChunks = 200
+
+// ErrInvalidRAGSource is returned when a source does not exist, is not the
+// user's or has an unknown type
+var ErrInvalidRAGSource = errors.New("invalid source")
+
+// RAGTopK returns the configured number of chunks to retrieve
+func RAGTopK() int {
+	if k, err := strconv.Atoi(config.Config("RAG_TOP_K")); err == nil && k > 0 {
+		return k
+	}
+	return DefaultRAGTopK
+}
+
+// RAGRelevanceThreshold returns the minimum relevance a chunk needs to be
+// used when its knowledge base sets none
+func RAGRelevanceThreshold() float64 {
+	threshold, _ := strconv.ParseFloat(config.Config("RAG_RELEVANCE_THRESHOLD"), 64)
+	return threshold
+}
+
+// HybridSearchEnabled reports whether keyword search is combined with vector
+// search; it is unless RAG_HYBRID_SEARCH is false
+func HybridSearchEnabled() bool {
+	enabled, err := strconv.ParseBool(config.Config("RAG_HYBRID_SEARCH"))
+	return err != nil || enabled
+}
+
+// KnowledgeTopK returns the number of chunks retrieved from a knowledge base
+func KnowledgeTopK(k models.Knowledge) int {
+	if k.TopK > 0 {
+		return k.TopK
+	}
+	return RAGTopK()
+}
+
+// KnowledgeRelevanceThreshold returns the minimum relevance of the chunks
+// retrieved from a knowledge base
+func KnowledgeRelevanceThreshold(k models.Knowledge) float64 {
+	if k.RelevanceThreshold > 0 {
+		return k.RelevanceThreshold
+	}
+	return RAGRelevanceThreshold()
+}
+
+// retrievalCandidates is how many chunks each search returns before fusion,
+// reranking and filtering cut them down to limit
+func retrievalCandidates(limit int) int {
+	if limit*4 < 20 {
+		return 20
+	}
+	return limit * 4
+}
+
+var mentionPattern = regexp.MustCompile(`(?:^|\s)#([^\s#]+)`)
+
+// KnowledgeMentions returns the user's knowledge bases mentioned in text as
+// #name, where spaces in the name are written as dashes
+func KnowledgeMentions(userID uint, text string) ([]models.RAGSource, error) {
+	matches := mentionPattern.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var knowledges []models.Knowledge
+	if result := database.DB.Where("user_id = ?", userID).Find(&knowledges); result.Error != nil {
+		return nil, result.Error
+	}
+	bySlug := make(map[string]uint, len(knowledges))
+	for _, k := range knowledges {
+		bySlug[strings.ToLower(strings.ReplaceAll(k.Name, " ", "-"))] = k.ID
+	}
+
+	var sources []models.RAGSource
+	for _, m := range matches {
+		// Allow trailing punctuation such as "#docs?"
+		slug := strings.ToLower(strings.TrimRight(m[1], ".,;:!?)"))
+		if id, ok := bySlug[slug]; ok {
+			sources = append(sources, models.RAGSource{Type: models.RAGSourceCollection, ID: id})
+		}
+	}
+	return sources, nil
+}
+
+// retrievalTarget is a knowledge base to search, optionally restricted to some files
+type retrievalTarget struct {
+	knowledge models.Knowledge
+	fileIDs   []uint
+}
+
+// Retrieve finds the chunks most relevant to query among the user's sources.
+// Knowledge bases are searched with hybrid keyword and vector search; files
+// are searched through a knowledge base that indexed them or, failing that,
+// extracted and embedded on the fly. Candidates are then optionally reranked
+// and filtered by each knowledge base's relevance threshold and top-k. A
+// positive topK overrides the per-knowledge limits. Citations are numbered from 1.
+func Retrieve(userID uint, query string, sources []models.RAGSource, topK int) ([]models.Citation, error) {
+	if knowledgeEmbedder == nil || knowledgeVectors == nil {
+		return nil, fmt.Errorf("knowledge index is not initialized")
+	}
+	if strings.TrimSpace(query) == "" || len(sources) == 0 {
+		return nil, nil
+	}
+
+	targets, directFiles, err := resolveRAGSources(userID, sources)
+	if err != nil {
+		return nil, err
+	}
+	return retrieve(query, targets, directFiles, topK)
+}
+
+// QueryKnowledge retrieves the chunks of a knowledge base most relevant to
+// query, as Retrieve would for chat completions. When fileIDs is not empty
+// only those files are searched.
+func QueryKnowledge(knowledge models.Knowledge, query string, fileIDs []uint, topK int) ([]models.Citation, error) {
+	if knowledgeEmbedder == nil || knowledgeVectors == nil {
+		return nil, fmt.Errorf("knowledge index is not initialized")
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	return retrieve(query, []retrievalTarget{{knowledge: knowledge, fileIDs: fileIDs}}, nil, topK)
+}
+
+func retrieve(query string, targets []retrievalTarget, directFiles []models.File, topK int) ([]models.Citation, error) {
+	queryVectors, err := knowledgeEmbedder.Embed([]string{query})
+	if err != nil {
+		return nil, err
+	}
+	queryVector := queryVectors[0]
+
+	// Overall limit: the largest top-k of the sources searched
+	limit := topK
+	limits := make(map[uint]int, len(targets))
+	thresholds := make(map[uint]float64, len(targets))
+	for _, t := range targets {
+		limits[t.knowledge.ID] = KnowledgeTopK(t.knowledge)
+		thresholds[t.knowledge.ID] = KnowledgeRelevanceThreshold(t.knowledge)
+		if topK <= 0 && limits[t.knowledge.ID] > limit {
+			limit = limits[t.knowledge.ID]
+		}
+	}
+	if topK <= 0 && len(directFiles) > 0 && RAGTopK() > limit {
+		limit = RAGTopK()
+	}
+	candidates := retrievalCandidates(limit)
+
+	var citations []models.Citation
+	for _, t := range targets {
+		found, err := searchKnowledge(t, query, queryVector, candidates)
+		if err != nil {
+			log.Printf("Retrieval from knowledge %d failed: %v", t.knowledge.ID, err)
+			continue
+		}
+		citations = append(citations, found...)
+	}
+	for _, f := range directFiles {
+		found, err := searchFileDirectly(f, query, queryVector, candidates)
+		if err != nil {
+			log.Printf("Retrieval from file %d failed: %v", f.ID, err)
+			continue
+		}
+		citations = append(citations, found...)
+	}
+
+	reranked := false
+	if reranker := KnowledgeReranker(); reranker != nil && len(citations) > 0 {
+		if err := rerankCitations(reranker, query, citations); err != nil {
+			log.Printf("Reranking failed, keeping retrieval order: %v", err)
+		} else {
+			reranked = true
+		}
+	}
+
+	// Apply relevance thresholds and per-knowledge limits, best first
+	sort.SliceStable(citations, func(i, j int) bool { return citations[i].Score > citations[j].Score })
+	kept := citations[:0]
+	perKnowledge := make(map[uint]int)
+	for _, c := range citations {
+		threshold, knowledgeLimit := RAGRelevanceThreshold(), RAGTopK()
+		if c.KnowledgeID != 0 {
+			threshold, knowledgeLimit = thresholds[c.KnowledgeID], limits[c.KnowledgeID]
+		}
+		if relevance(c, reranked) < threshold {
+			continue
+		}
+		if topK <= 0 && perKnowledge[c.KnowledgeID] >= knowledgeLimit {
+			continue
+		}
+		perKnowledge[c.KnowledgeID]++
+		kept = append(kept, c)
+	}
+
+	return rankCitations(kept, limit), nil
+}
+
+// relevance returns the score a relevance threshold applies to: the
+// reranker's when the citations were reranked, and the vector similarity
+// otherwise. Fused scores only reflect rank, so they order citations but are
+// never compared with a threshold. Without a reranker, chunks found by
+// keyword search alone have no vector similarity and only pass a threshold of 0.
+func relevance(c models.Citation, reranked bool) float64 {
+	if reranked {
+		return c.RerankScore
+	}
+	return c.VectorScore
+}
+
+// resolveRAGSources checks that the user owns each source and groups them into
+// knowledge bases to search and files that no knowledge base has indexed
+func resolveRAGSources(userID uint, sources []models.RAGSource) ([]retrievalTarget, []models.File, error) {
+	targets := make(map[uint]*retrievalTarget)
+	var order []uint
+	// addTarget searches a whole knowledge base when fileID is 0 and only the
+	// given files otherwise; a whole knowledge base wins over its files
+	addTarget := func(k models.Knowledge, fileID uint) {
+		t, ok := targets[k.ID]
+		if !ok {
+			t = &retrievalTarget{knowledge: k}
+			targets[k.ID] = t
+			order = append(order, k.ID)
+			if fileID != 0 {
+				t.fileIDs = []uint{fileID}
+			}
+			return
+		}
+		if fileID == 0 {
+			t.fileIDs = nil
+		} else if t.fileIDs != nil {
+			t.fileIDs = append(t.fileIDs, fileID)
+		}
+	}
+
+	var directFiles []models.File
+	for _, s := range sources {
+		switch s.Type {
+		case models.RAGSourceCollection:
+			var k models.Knowledge
+			if result := database.DB.Where("id = ? AND user_id = ?", s.ID, userID).First(&k); result.Error != nil {
+				return nil, nil, fmt.Errorf("%w: knowledge base %d not found", ErrInvalidRAGSource, s.ID)
+			}
+			addTarget(k, 0)
+		case models.RAGSourceFile:
+			var file models.File
+			if result := database.DB.Where("id = ? AND user_id = ?", s.ID, userID).First(&file); result.Error != nil {
+				return nil, nil, fmt.Errorf("%w: file %d not found", ErrInvalidRAGSource, s.ID)
+			}
+			var k models.Knowledge
+			result := database.DB.
+				Joins("JOIN knowledge_files ON knowledge_files.knowledge_id = knowledges.id").
+				Where("knowledges.user_id = ? AND knowledge_files.file_id = ? AND knowledge_files.status = ?", userID, file.ID, models.IngestionIndexed).
+				First(&k)
+			if result.Error != nil {
+				directFiles = append(directFiles, file)
+				continue
+			}
+			addTarget(k, file.ID)
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown source type %q", ErrInvalidRAGSource, s.Type)
+		}
+	}
+
+	resolved := make([]retrievalTarget, 0, len(order))
+	for _, id := range order {
+		resolved = append(resolved, *targets[id])
+	}
+	return resolved, directFiles, nil
+}
+
+// scoredChunk is a chunk ranked by hybrid search
+type scoredChunk struct {
+	ChunkID      uint
+	Score        float64
+	VectorScore  float64
+	KeywordScore float64
+}
+
+// searchKnowledge runs a vector search and, unless RAG_HYBRID_SEARCH is
+// false, a keyword search on a knowledge base and fuses both rankings with
+// reciprocal-rank fusion. Keyword search still works while the collection is
+// being rebuilt.
+func searchKnowledge(t retrievalTarget, query string, queryVector []float32, candidates int) ([]models.Citation, error) {
+	var vectorMatches []VectorMatch
+	if t.knowledge.EmbeddingModel == knowledgeEmbedder.Name() {
+		var err error
+		vectorMatches, err = knowledgeVectors.Search(t.knowledge.CollectionName, queryVector, candidates, t.fileIDs)
+		if err != nil {
+			return nil, err
+		}
+	} else if !HybridSearchEnabled() {
+		return nil, fmt.Errorf("collection is being rebuilt for %s", knowledgeEmbedder.Name())
+	}
+
+	if !HybridSearchEnabled() {
+		scored := make([]scoredChunk, 0, len(vectorMatches))
+		for _, m := range vectorMatches {
+			scored = append(scored, scoredChunk{ChunkID: m.ChunkID, Score: m.Score, VectorScore: m.Score})
+		}
+		return citationsForChunks(t.knowledge.ID, scored)
+	}
+
+	keywordMatches, err := KeywordSearch(t.knowledge.ID, t.fileIDs, query, candidates)
+	if err != nil {
+		return nil, err
+	}
+	return citationsForChunks(t.knowledge.ID, fuseRankings(vectorMatches, keywordMatches))
+}
+
+// rrfK dampens the weight of top ranks in reciprocal-rank fusion
+const rrfK = 60
+
+// fuseRankings merges vector and keyword rankings with reciprocal-rank
+// fusion. Scores are scaled so that a chunk ranked first by both searches
+// scores 1, and one ranked first by a single search scores 0.5. They only
+// order chunks; relevance thresholds apply to the vector scores kept alongside.
+func fuseRankings(vectorMatches []VectorMatch, keywordMatches []VectorMatch) []scoredChunk {
+	byID := make(map[uint]*scoredChunk)
+	var order []uint
+	add := func(m VectorMatch, rank int, vector bool) {
+		c, ok := byID[m.ChunkID]
+		if !ok {
+			c = &scoredChunk{ChunkID: m.ChunkID}
+			byID[m.ChunkID] = c
+			order = append(order, m.ChunkID)
+		}
+		c.Score += 1 / float64(rrfK+rank+1)
+		if vector {
+			c.VectorScore = m.Score
+		} else {
+			c.KeywordScore = m.Score
+		}
+	}
+	for i, m := range vectorMatches {
+		add(m, i, true)
+	}
+	for i, m := range keywordMatches {
+		add(m, i, false)
+	}
+
+	best := 2 / float64(rrfK+1)
+	fused := make([]scoredChunk, 0, len(order))
+	for _, id := range order {
+		c := *byID[id]
+		c.Score /= best
+		fused = append(fused, c)
+	}
+	sort.SliceStable(fused, func(i, j int) bool { return fused[i].Score > fused[j].Score })
+	return fused
+}
+
+// KeywordSearch ranks a knowledge base's chunks by Postgres full-text
+// relevance to any of the query's words. Exact identifiers such as error
+// codes, which embeddings tend to blur, are matched as words.
+func KeywordSearch(knowledgeID uint, fileIDs []uint, query string, limit int) ([]VectorMatch, error) {
+	// Tokens are letters and digits only, so they are safe tsquery operands
+	seen := make(map[string]bool)
+	var terms []string
+	for _, token := range Tokenize(query) {
+		if seen[token] || len(terms) == 64 {
+			continue
+		}
+		seen[token] = true
+		terms = append(terms, token)
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	tsquery := strings.Join(terms, " | ")
+
+	sql := `SELECT id AS chunk_id, file_id, ts_rank_cd(to_tsvector('simple', content), to_tsquery('simple', ?)) AS score
+		FROM knowledge_chunks
+		WHERE knowledge_id = ? AND to_tsvector('simple', content) @@ to_tsquery('simple', ?)`
+	args := []interface{}{tsquery, knowledgeID, tsquery}
+	if len(fileIDs) > 0 {
+		sql += " AND file_id IN ?"
+		args = append(args, fileIDs)
+	}
+	sql += " ORDER BY score DESC, id LIMIT ?"
+	args = append(args, limit)
+
+	var matches []VectorMatch
+	if err := database.DB.Raw(sql, args...).Scan(&matches).Error; err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// citationsForChunks loads the chunks and file names of ranked chunks
+func citationsForChunks(knowledgeID uint, scored []scoredChunk) ([]models.Citation, error) {
+	if len(scored) == 0 {
+		return nil, nil
+	}
+
+	chunkIDs := make([]uint, 0, len(scored))
+	for _, m := range scored {
+		chunkIDs = append(chunkIDs, m.ChunkID)
+	}
+	var chunks []models.KnowledgeChunk
+	if result := database.DB.Where("id IN ?", chunkIDs).Find(&chunks); result.Error != nil {
+		return nil, result.Error
+	}
+	byID := make(map[uint]models.KnowledgeChunk, len(chunks))
+	fileIDs := make([]uint, 0, len(chunks))
+	for _, c := range chunks {
+		byID[c.ID] = c
+		fileIDs = append(fileIDs, c.FileID)
+	}
+	fileNames := make(map[uint]string)
+	if len(fileIDs) > 0 {
+		var files []models.File
+		database.DB.Where("id IN ?", fileIDs).Find(&files)
+		for _, f := range files {
+			fileNames[f.ID] = f.Name
+		}
+	}
+
+	citations := make([]models.Citation, 0, len(scored))
+	for _, m := range scored {
+		c, ok := byID[m.ChunkID]
+		if !ok {
+			continue // Deleted since it was indexed
+		}
+		citations = append(citations, models.Citation{
+			KnowledgeID:  knowledgeID,
+			FileID:       c.FileID,
+			FileName:     fileNames[c.FileID],
+			ChunkID:      c.ID,
+			ChunkIndex:   c.ChunkIndex,
+			StartOffset:  c.StartOffset,
+			EndOffset:    c.EndOffset,
+			Score:        m.Score,
+			VectorScore:  m.VectorScore,
+			KeywordScore: m.KeywordScore,
+			Content:      c.Content,
+		})
+	}
+	return citations, nil
+}
+
+// searchFileDirectly extracts, chunks and embeds a file that is not in any
+// knowledge base and ranks its chunks against the query. Its scores are on
+// the scale of searchKnowledge's, fused with a keyword ranking under hybrid
+// search, so that the chunks of both are ranked together.
+func searchFileDirectly(file models.File, query string, queryVector []float32, candidates int) ([]models.Citation, error) {
+	text, err := ExtractFileText(file)
+	if err != nil {
+		return nil, err
+	}
+	size, overlap := ChunkSettings(0, nil)
+	chunks := ChunkText(text, size, overlap)
+	if len(chunks) > maxDirectFileChunks {
+		chunks = chunks[:maxDirectFileChunks]
+	}
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	texts := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		texts = append(texts, c.Content)
+	}
+	vectors, err := EmbedBatched(knowledgeEmbedder, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks are identified by their position plus one while ranked
+	vectorMatches := make([]VectorMatch, 0, len(chunks))
+	for i := range chunks {
+		vectorMatches = append(vectorMatches, VectorMatch{ChunkID: uint(i + 1), FileID: file.ID, Score: CosineSimilarity(queryVector, vectors[i])})
+	}
+	sort.SliceStable(vectorMatches, func(i, j int) bool { return vectorMatches[i].Score > vectorMatches[j].Score })
+	if len(vectorMatches) > candidates {
+		vectorMatches = vectorMatches[:candidates]
+	}
+
+	var scored []scoredChunk
+	if HybridSearchEnabled() {
+		scored = fuseRankings(vectorMatches, keywordRanking(query, texts, file.ID, candidates))
+	} else {
+		for _, m := range vectorMatches {
+			scored = append(scored, scoredChunk{ChunkID: m.ChunkID, Score: m.Score, VectorScore: m.Score})
+		}
+	}
+
+	citations := make([]models.Citation, 0, len(scored))
+	for _, m := range scored {
+		c := chunks[m.ChunkID-1]
+		citations = append(citations, models.Citation{
+			FileID:       file.ID,
+			FileName:     file.Name,
+			ChunkIndex:   c.Index,
+			StartOffset:  c.Start,
+			EndOffset:    c.End,
+			Score:        m.Score,
+			VectorScore:  m.VectorScore,
+			KeywordScore: m.KeywordScore,
+			Content:      c.Content,
+		})
+	}
+	return citations, nil
+}
+
+// keywordRanking ranks texts by the share of the query's words they contain,
+// as KeywordSearch does for indexed chunks. Matches are identified by the
+// position of the text plus one.
+func keywordRanking(query string, texts []string, fileID uint, limit int) []VectorMatch {
+	terms := make(map[string]bool)
+	for _, token := range Tokenize(query) {
+		terms[token] = true
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var matches []VectorMatch
+	for i, text := range texts {
+		found := make(map[string]bool)
+		for _, token := range Tokenize(text) {
+			if terms[token] {
+				found[token] = true
+			}
+		}
+		if len(found) > 0 {
+			matches = append(matches, VectorMatch{ChunkID: uint(i + 1), FileID: fileID, Score: float64(len(found)) / float64(len(terms))})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// rankCitations orders citations best first, drops duplicates, keeps topK
+// and numbers them from 1
+func rankCitations(citations []models.Citation, topK int) []models.Citation {
+	sort.SliceStable(citations, func(i, j int) bool { return citations[i].Score > citations[j].Score })
+
+	seen := make(map[string]bool, len(citations))
+	ranked := make([]models.Citation, 0, topK)
+	for _, c := range citations {
+		key := fmt.Sprintf("%d:%d:%d", c.FileID, c.ChunkIndex, c.StartOffset)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		c.Source = len(ranked) + 1
+		ranked = append(ranked, c)
+		if len(ranked) == topK {
+			break
+		}
+	}
+	return ranked
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"backend/config"
+)
+
+// Storage holds the content of uploaded files as blobs addressed by key.
+// Keys are generated by the server, never taken from client file names.
+type Storage interface {
+	// Put stores a blob of size bytes, replacing any blob with the same key
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get opens a blob for reading
+	Get(key string) (io.ReadCloser, error)
+	// GetRange opens length bytes of a blob starting at offset. A negative
+	// length reads to the end.
+	GetRange(key string, offset int64, length int64) (io.ReadCloser, error)
+	// Size returns the size of a blob, or ErrBlobNotFound
+	Size(key string) (int64, error)
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(key string) error
+	// SignedURL returns a URL granting read access to a blob until it
+	// expires, or ErrSignedURLUnsupported
+	SignedURL(key string, expires time.Duration) (string, error)
+}
+
+var (
+	// ErrBlobNotFound is returned for a key with no blob
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrSignedURLUnsupported is returned by backends that cannot sign URLs
+	ErrSignedURLUnsupported = errors.New("storage backend does not support signed URLs")
+)
+
+// DefaultStorageDir is where the local backend stores blobs when STORAGE_DIR
+// is not set
+const DefaultStorageDir = "./uploads"
+
+var fileStorage Storage
+
+// InitStorage sets up the storage selected by STORAGE_BACKEND: "local"
+// (default, STORAGE_DIR) or "s3" (S3_* settings)
+func InitStorage() error {
+	storage, err := NewStorageFromConfig()
+	if err != nil {
+		return err
+	}
+	SetFileStorage(storage)
+	return nil
+}
+
+// NewStorageFromConfig creates the storage selected by STORAGE_BACKEND
+func NewStorageFromConfig() (Storage, error) {
+	switch config.Config("STORAGE_BACKEND") {
+	case "", "local":
+		dir := config.Config("STORAGE_DIR")
+		if dir == "" {
+			dir = DefaultStorageDir
+		}
+		return NewLocalStorage(dir)
+	case "s3":
+		return NewS3StorageFromConfig()
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", config.Config("STORAGE_BACKEND"))
+	}
+}
+
+// SetFileStorage replaces the storage holding file content
+func SetFileStorage(storage Storage) {
+	fileStorage = storage
+}
+
+// FileStorage returns the storage holding file content
+func FileStorage() Storage {
+	return fileStorage
+}
+
+// ReadBlob reads a whole blob, up to limit bytes
+func ReadBlob(key string, limit int64) ([]byte, error) {
+	if fileStorage == nil {
+		return nil, fmt.Errorf("file storage is not initialized")
+	}
+	rc, err := fileStorage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, limit))
+}
+
+// blobKeyPattern matches the keys the server generates: hex content hashes
+var blobKeyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// IsBlobKey reports whether a file path is a storage key, as opposed to a
+// local path recorded before blob storage existed
+func IsBlobKey(path string) bool {
+	return blobKeyPattern.MatchString(path)
+}
+
+// LocalStorage stores blobs as files in a directory
+type LocalStorage struct {
+	Dir string
+}
+
+// NewLocalStorage creates a local storage, creating its directory if needed
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStorage{Dir: dir}, nil
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.Dir, key), nil
+}
+
+// Put implements Storage. The blob is written to a temporary file first so
+// that readers never see a partial blob.
+func (s *LocalStorage) Put(key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get implements Storage
+func (s *LocalStorage) Get(key string) (io.ReadCloser, error) {
+	return s.GetRange(key, 0, -1)
+}
+
+// GetRange implements Storage
+func (s *LocalStorage) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// Size implements Storage
+func (s *LocalStorage) Size(key string) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, ErrBlobNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Delete implements Storage
+func (s *LocalStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SignedURL implements Storage. Local blobs are only served through the API.
+func (s *LocalStorage) SignedURL(key string, expires time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
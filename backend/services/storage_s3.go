@@ -0,0 +1,465 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+)
+
+// unsignedPayload is the payload hash used when the body is not signed, so
+// that uploads can be streamed without hashing them twice
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyPayloadHash is the SHA-256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// DefaultS3PartSize is the size of the parts of multipart uploads when
+// S3_PART_SIZE is not set. Content larger than a part is uploaded in parts,
+// since a single PUT is limited to 5 GB; with at most 10,000 parts, 64 MB
+// parts allow objects of up to 640 GB.
+const DefaultS3PartSize = 64 << 20 // 64 MB
+
+// minS3PartSize is the smallest part S3 accepts, except for the last one
+const minS3PartSize = 5 << 20 // 5 MB
+
+// S3Storage stores blobs in a bucket of an S3-compatible service, such as
+// AWS S3 or MinIO. Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // Prepended to every key
+	PathStyle bool   // Address the bucket in the path instead of the host name
+	PartSize  int64  // Content larger than this is uploaded in parts of this size
+	Client    *http.Client
+}
+
+// NewS3StorageFromConfig creates an S3 storage from S3_ENDPOINT, S3_REGION,
+// S3_BUCKET, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY, S3_PREFIX, S3_PATH_STYLE
+// and S3_PART_SIZE. Path-style addressing is the default for custom endpoints.
+func NewS3StorageFromConfig() (*S3Storage, error) {
+	s := &S3Storage{
+		Endpoint:  strings.TrimSuffix(config.Config("S3_ENDPOINT"), "/"),
+		Region:    config.Config("S3_REGION"),
+		Bucket:    config.Config("S3_BUCKET"),
+		AccessKey: config.Config("S3_ACCESS_KEY_ID"),
+		SecretKey: config.Config("S3_SECRET_ACCESS_KEY"),
+		Prefix:    config.Config("S3_PREFIX"),
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	} else {
+		s.PathStyle = true
+	}
+	if v := config.Config("S3_PATH_STYLE"); v != "" {
+		s.PathStyle, _ = strconv.ParseBool(v)
+	}
+	if v := config.Config("S3_PART_SIZE"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size < minS3PartSize {
+			return nil, fmt.Errorf("S3_PART_SIZE must be at least %d bytes", minS3PartSize)
+		}
+		s.PartSize = size
+	}
+
+	if s.Bucket == "" {
+		return nil, fmt.Errorf("S3_BUCKET is not set")
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, fmt.Errorf("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set")
+	}
+	if _, err := url.Parse(s.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT: %w", err)
+	}
+	return s, nil
+}
+
+// Put implements Storage. Content larger than PartSize is uploaded in parts.
+func (s *S3Storage) Put(key string, r io.Reader, size int64, contentType string) error {
+	if size > s.partSize() {
+		return s.putMultipart(key, r, size, contentType)
+	}
+
+	req, err := s.newRequest("PUT", key, nil, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) partSize() int64 {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+	return DefaultS3PartSize
+}
+
+// s3CompletedPart is a part listed when completing a multipart upload
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads content in parts of PartSize bytes and assembles
+// them. A failed upload is aborted so that its parts are not left behind.
+func (s *S3Storage) putMultipart(key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.newRequest("POST", key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.doXML(req, emptyPayloadHash, &initiated); err != nil {
+		return err
+	}
+	if initiated.UploadID == "" {
+		return fmt.Errorf("S3 did not return a multipart upload ID")
+	}
+
+	if err := s.uploadParts(key, initiated.UploadID, r, size); err != nil {
+		if abortErr := s.abortMultipart(key, initiated.UploadID); abortErr != nil {
+			return fmt.Errorf("%w (and aborting the upload failed: %v)", err, abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParts uploads the parts of a multipart upload and completes it
+func (s *S3Storage) uploadParts(key string, uploadID string, r io.Reader, size int64) error {
+	var parts []s3CompletedPart
+	for offset, number := int64(0), 1; offset < size; number++ {
+		length := s.partSize()
+		if size-offset < length {
+			length = size - offset
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		req, err := s.newRequest("PUT", key, query, io.LimitReader(r, length))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = length
+		resp, err := s.do(req, unsignedPayload)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		offset += length
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err := s.newRequest("POST", key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+	// S3 reports a failure to assemble the parts in a 200 response
+	var completed struct {
+		XMLName xml.Name
+		Message string `xml:"Message"`
+	}
+	if err := s.doXML(req, hex.EncodeToString(hash[:]), &completed); err != nil {
+		return err
+	}
+	if completed.XMLName.Local == "Error" {
+		return fmt.Errorf("S3 failed to complete the multipart upload: %s", completed.Message)
+	}
+	return nil
+}
+
+// abortMultipart discards the parts of a multipart upload
+func (s *S3Storage) abortMultipart(key string, uploadID string) error {
+	req, err := s.newRequest("DELETE", key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// doXML sends a request with do and decodes its XML response
+func (s *S3Storage) doXML(req *http.Request, payloadHash string, response interface{}) error {
+	resp, err := s.do(req, payloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("S3 %s returned an invalid response: %w", req.Method, err)
+	}
+	return nil
+}
+
+// Get implements Storage
+func (s *S3Storage) Get(key string) (io.ReadCloser, error) {
+	return s.GetRange(key, 0, -1)
+}
+
+// GetRange implements Storage
+func (s *S3Storage) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	req, err := s.newRequest("GET", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case length > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Size implements Storage
+func (s *S3Storage) Size(key string) (int64, error) {
+	req, err := s.newRequest("HEAD", key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// Delete implements Storage
+func (s *S3Storage) Delete(key string) error {
+	req, err := s.newRequest("DELETE", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err == ErrBlobNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL implements Storage with a presigned GET URL
+func (s *S3Storage) SignedURL(key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("signed URLs must expire within 7 days")
+	}
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = awsCanonicalQuery(query)
+
+	canonical := strings.Join([]string{
+		"GET",
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	signature := s.sign(now, amzDate, scope, canonical)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// objectURL returns the URL of a key, with each path segment escaped as
+// SigV4 expects
+func (s *S3Storage) objectURL(key string) (*url.URL, error) {
+	if key == "" {
+		return nil, fmt.Errorf("invalid storage key %q", key)
+	}
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	objectPath := "/" + awsEscapePath(s.Prefix+key)
+	if s.PathStyle {
+		objectPath = "/" + awsEscapePath(s.Bucket) + objectPath
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.RawPath = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	return u, nil
+}
+
+func (s *S3Storage) newRequest(method string, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	if query != nil {
+		u.RawQuery = awsCanonicalQuery(query)
+	}
+	return http.NewRequest(method, u.String(), body)
+}
+
+// do signs a request with the given payload hash and sends it. Responses
+// other than 2xx are turned into errors, 404 into ErrBlobNotFound.
+func (s *S3Storage) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		awsCanonicalQuery(req.URL.Query()),
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	signature := s.sign(now, amzDate, scope, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKey, scope, signedHeaders, signature))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s failed: %w", req.Method, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s returned non-2xx status: %d - %s", req.Method, resp.StatusCode, string(bodyBytes))
+	}
+	return resp, nil
+}
+
+func (s *S3Storage) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+// sign returns the SigV4 signature of a canonical request
+func (s *S3Storage) sign(t time.Time, amzDate string, scope string, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but the unreserved characters of RFC 3986
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsEscapePath escapes each segment of a path, keeping the slashes
+func awsEscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsCanonicalQuery encodes a query string sorted by key, as SigV4 expects
+func awsCanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
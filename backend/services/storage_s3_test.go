@@ -0,0 +1,205 @@
+package services
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 stands in for an S3 bucket addressed path-style. It keeps objects
+// in memory, serves single-range GETs and supports multipart uploads.
+// failPart makes the upload of that part number fail.
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]map[int][]byte
+	nextID    int
+	singlePut int
+	aborted   int
+	failPart  int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Storage) {
+	t.Helper()
+	f := &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, &S3Storage{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "bucket",
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+		Client:    server.Client(),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == "POST" && query.Has("uploads"):
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+
+	case r.Method == "PUT" && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if !ok || number < 1 {
+			http.Error(w, "no such upload", http.StatusNotFound)
+			return
+		}
+		if number == f.failPart {
+			http.Error(w, "part failed", http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+
+	case r.Method == "POST" && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "no such upload", http.StatusNotFound)
+			return
+		}
+		var complete struct {
+			Parts []s3CompletedPart `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			fmt.Fprintf(w, `<Error><Code>MalformedXML</Code><Message>%v</Message></Error>`, err)
+			return
+		}
+		var object []byte
+		for i, p := range complete.Parts {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf(`"part-%d"`, p.PartNumber) {
+				fmt.Fprint(w, `<Error><Code>InvalidPart</Code><Message>invalid part</Message></Error>`)
+				return
+			}
+			object = append(object, parts[p.PartNumber]...)
+		}
+		f.objects[key] = object
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>`, key)
+
+	case r.Method == "DELETE" && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "PUT":
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		f.singlePut++
+
+	case r.Method == "GET" || r.Method == "HEAD":
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(object))
+
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+	}
+}
+
+func TestS3StorageStoresObjects(t *testing.T) {
+	server, s := newFakeS3(t)
+
+	content := "hello, object storage"
+	if err := s.Put("key", strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if server.singlePut != 1 {
+		t.Errorf("small object took %d single PUTs, want 1", server.singlePut)
+	}
+
+	if size, err := s.Size("key"); err != nil || size != int64(len(content)) {
+		t.Errorf("Size = %d, %v, want %d", size, err, len(content))
+	}
+	rc, err := s.GetRange("key", 7, 6)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	part, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(part) != "object" {
+		t.Errorf("GetRange(7, 6) = %q, want %q", part, "object")
+	}
+
+	if err := s.Delete("key"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get("key"); err != ErrBlobNotFound {
+		t.Errorf("Get after Delete error = %v, want ErrBlobNotFound", err)
+	}
+}
+
+func TestS3StorageUploadsLargeObjectsInParts(t *testing.T) {
+	server, s := newFakeS3(t)
+	s.PartSize = 10
+
+	content := strings.Repeat("0123456789", 3) + "tail"
+	if err := s.Put("large", strings.NewReader(content), int64(len(content)), ""); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if server.singlePut != 0 {
+		t.Errorf("large object took %d single PUTs, want a multipart upload", server.singlePut)
+	}
+	if got := string(server.objects["large"]); got != content {
+		t.Errorf("stored %q, want %q", got, content)
+	}
+	if len(server.uploads) != 0 {
+		t.Errorf("%d multipart uploads were left open", len(server.uploads))
+	}
+}
+
+func TestS3StorageAbortsFailedMultipartUploads(t *testing.T) {
+	server, s := newFakeS3(t)
+	s.PartSize = 10
+	server.failPart = 2
+
+	content := strings.Repeat("x", 25)
+	if err := s.Put("large", strings.NewReader(content), int64(len(content)), ""); err == nil {
+		t.Fatal("Put succeeded although a part failed")
+	}
+	if server.aborted != 1 || len(server.uploads) != 0 {
+		t.Errorf("aborted %d uploads with %d left open, want the failed upload aborted", server.aborted, len(server.uploads))
+	}
+	if _, ok := server.objects["large"]; ok {
+		t.Error("a failed multipart upload created the object")
+	}
+}
+
+func TestAWSCanonicalQuery(t *testing.T) {
+	query := map[string][]string{"uploadId": {"a b"}, "partNumber": {"2"}, "uploads": {""}}
+	got := awsCanonicalQuery(query)
+	want := "partNumber=2&uploadId=a%20b&uploads="
+	if got != want {
+		t.Errorf("awsCanonicalQuery = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+// toolHarness is the Python program the subprocess executor runs in its
+// jail. It applies the resource limits given as arguments (CPU seconds,
+// memory bytes, whether network access is allowed) before any tool code
+// runs, reads a request from stdin and writes a JSON response to stdout:
+//
+//	{"code": ..., "function": ..., "arguments": {...}} -> {"result": ...} or {"error": ...}
+//
+// Tools are written like Open WebUI tools: the public methods of a Tools
+// class, or the module's own functions when there is no such class. The
+// jail is what keeps tools off the network; disabling sockets here only
+// makes the error clearer.
+const toolHarness = `
+import inspect
+import json
+import sys
+
+
+def apply_limits(cpu_seconds, memory_bytes, allow_network):
+    try:
+        import resource
+
+        if cpu_seconds > 0:
+            resource.setrlimit(resource.RLIMIT_CPU, (cpu_seconds, cpu_seconds))
+        if memory_bytes > 0:
+            resource.setrlimit(resource.RLIMIT_AS, (memory_bytes, memory_bytes))
+        resource.setrlimit(resource.RLIMIT_FSIZE, (16 << 20, 16 << 20))
+        resource.setrlimit(resource.RLIMIT_CORE, (0, 0))
+    except ImportError:
+        pass
+
+    if not allow_network:
+        import socket
+
+        class DeniedSocket(socket.socket):
+            def __init__(self, *args, **kwargs):
+                raise PermissionError("network access is disabled for tools")
+
+        def denied(*args, **kwargs):
+            raise PermissionError("network access is disabled for tools")
+
+        socket.socket = DeniedSocket
+        socket.create_connection = denied
+        socket.getaddrinfo = denied
+
+
+def tool_functions(namespace):
+    tools = namespace.get("Tools")
+    if inspect.isclass(tools):
+        instance = tools()
+        return {
+            name: member
+            for name, member in inspect.getmembers(instance, inspect.ismethod)
+            if not name.startswith("_")
+        }
+    return {
+        name: member
+        for name, member in namespace.items()
+        if inspect.isfunction(member) and member.__module__ == "tool" and not name.startswith("_")
+    }
+
+
+def main():
+    apply_limits(int(sys.argv[1]), int(sys.argv[2]), sys.argv[3] == "1")
+    request = json.load(sys.stdin)
+
+    # Output of the tool goes to stderr so that stdout only holds the response
+    stdout, sys.stdout = sys.stdout, sys.stderr
+    try:
+        namespace = {"__name__": "tool"}
+        exec(compile(request["code"], "tool.py", "exec"), namespace)
+        function = tool_functions(namespace).get(request["function"])
+        if function is None:
+            raise LookupError("unknown function %r" % request["function"])
+        result = function(**(request.get("arguments") or {}))
+        if inspect.isawaitable(result):
+            import asyncio
+
+            result = asyncio.run(result)
+        response = {"result": result}
+    except BaseException as e:
+        response = {"error": "%s: %s" % (type(e).__name__, e)}
+
+    try:
+        data = json.dumps(response, default=str)
+    except Exception as e:
+        data = json.dumps({"error": "result is not serializable: %s" % e})
+    stdout.write(data)
+    stdout.flush()
+
+
+main()
+`
@@ -0,0 +1,483 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"backend/config"
+	"backend/models"
+)
+
+const (
+	// maxOpenAPIDocument bounds the size of the OpenAPI documents of tool servers
+	maxOpenAPIDocument = 5 << 20 // 5 MB
+	// maxOpenAPIRefDepth bounds how many $ref references are followed within
+	// one another
+	maxOpenAPIRefDepth = 8
+)
+
+// ErrInvalidOpenAPI is returned for a tool server document that is not a
+// JSON OpenAPI 3 document
+var ErrInvalidOpenAPI = errors.New("invalid OpenAPI document")
+
+// openAPIMethods are the operations of a path item offered as functions
+var openAPIMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// openAPIOperation is an operation of a tool server offered as a function
+type openAPIOperation struct {
+	Spec   models.ToolSpec
+	Method string
+	Path   string
+	Params []openAPIParam
+	// BodyFields are the arguments sent as the properties of a JSON body;
+	// with WholeBody the "body" argument is sent as the body instead
+	BodyFields []string
+	WholeBody  bool
+}
+
+// openAPIParam is a path, query or header parameter of an operation
+type openAPIParam struct {
+	Name string
+	In   string
+}
+
+// FetchOpenAPITool fetches the OpenAPI document of a tool server and returns
+// it with the functions it offers
+func FetchOpenAPITool(documentURL string, auth models.ToolServerAuth) ([]byte, []models.ToolSpec, error) {
+	req, err := http.NewRequest("GET", documentURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidOpenAPI, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	applyToolServerAuth(req, auth)
+
+	resp, err := toolServerClient().Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch OpenAPI document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch OpenAPI document: %s returned %d", documentURL, resp.StatusCode)
+	}
+	document, err := io.ReadAll(io.LimitReader(resp.Body, maxOpenAPIDocument+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch OpenAPI document: %w", err)
+	}
+	if len(document) > maxOpenAPIDocument {
+		return nil, nil, fmt.Errorf("%w: document is larger than %d bytes", ErrInvalidOpenAPI, maxOpenAPIDocument)
+	}
+
+	operations, err := parseOpenAPI(document)
+	if err != nil {
+		return nil, nil, err
+	}
+	specs := make([]models.ToolSpec, len(operations))
+	for i, op := range operations {
+		specs[i] = op.Spec
+	}
+	return document, specs, nil
+}
+
+// parseOpenAPI reads the operations of an OpenAPI 3 document, in path order
+func parseOpenAPI(document []byte) ([]openAPIOperation, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOpenAPI, err)
+	}
+	if version, _ := doc["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("%w: only OpenAPI 3 documents are supported", ErrInvalidOpenAPI)
+	}
+	paths, _ := doc["paths"].(map[string]interface{})
+	pathNames := make([]string, 0, len(paths))
+	for path := range paths {
+		pathNames = append(pathNames, path)
+	}
+	sort.Strings(pathNames)
+
+	var operations []openAPIOperation
+	seen := make(map[string]bool)
+	for _, path := range pathNames {
+		item, _ := resolveOpenAPIRefs(doc, paths[path], make(map[string]bool)).(map[string]interface{})
+		shared, _ := item["parameters"].([]interface{})
+		for _, method := range openAPIMethods {
+			node, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			op := parseOpenAPIOperation(method, path, node, shared)
+			if seen[op.Spec.Name] {
+				continue
+			}
+			seen[op.Spec.Name] = true
+			operations = append(operations, op)
+		}
+	}
+	return operations, nil
+}
+
+// parseOpenAPIOperation turns an operation into a function whose arguments
+// are its parameters and the properties of its JSON body
+func parseOpenAPIOperation(method string, path string, node map[string]interface{}, shared []interface{}) openAPIOperation {
+	name, _ := node["operationId"].(string)
+	if name == "" {
+		name = method + "_" + strings.Trim(path, "/")
+	}
+	op := openAPIOperation{Method: strings.ToUpper(method), Path: path}
+	op.Spec.Name = toolFunctionName(name)
+	op.Spec.Description, _ = node["summary"].(string)
+	if description, _ := node["description"].(string); description != "" {
+		if op.Spec.Description != "" {
+			op.Spec.Description += ". "
+		}
+		op.Spec.Description += description
+	}
+
+	properties := make(map[string]interface{})
+	var required []string
+	params, _ := node["parameters"].([]interface{})
+	for _, p := range append(shared, params...) {
+		param, _ := p.(map[string]interface{})
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		if name == "" || (in != "path" && in != "query" && in != "header") {
+			continue
+		}
+		schema, _ := param["schema"].(map[string]interface{})
+		if schema == nil {
+			schema = map[string]interface{}{"type": "string"}
+		}
+		if description, _ := param["description"].(string); description != "" {
+			schema = withDescription(schema, description)
+		}
+		// Parameters of the operation override those of its path
+		_, overridden := properties[name]
+		properties[name] = schema
+		if overridden {
+			continue
+		}
+		op.Params = append(op.Params, openAPIParam{Name: name, In: in})
+		if isRequired, _ := param["required"].(bool); isRequired || in == "path" {
+			required = append(required, name)
+		}
+	}
+
+	body, _ := node["requestBody"].(map[string]interface{})
+	content, _ := body["content"].(map[string]interface{})
+	if media, ok := content["application/json"].(map[string]interface{}); ok {
+		schema, _ := media["schema"].(map[string]interface{})
+		bodyProperties, _ := schema["properties"].(map[string]interface{})
+		bodyRequired, _ := body["required"].(bool)
+		if schema["type"] == "object" || (schema["type"] == nil && len(bodyProperties) > 0) {
+			requiredFields := make(map[string]bool)
+			if list, _ := schema["required"].([]interface{}); bodyRequired {
+				for _, field := range list {
+					if field, ok := field.(string); ok {
+						requiredFields[field] = true
+					}
+				}
+			}
+			fields := make([]string, 0, len(bodyProperties))
+			for field := range bodyProperties {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				if _, taken := properties[field]; taken {
+					continue
+				}
+				properties[field] = bodyProperties[field]
+				op.BodyFields = append(op.BodyFields, field)
+				if requiredFields[field] {
+					required = append(required, field)
+				}
+			}
+		} else if schema != nil {
+			op.WholeBody = true
+			properties["body"] = schema
+			if bodyRequired {
+				required = append(required, "body")
+			}
+		}
+	}
+
+	parameters := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		parameters["required"] = required
+	}
+	op.Spec.Parameters, _ = json.Marshal(parameters)
+	return op
+}
+
+// withDescription returns a copy of a schema with a description
+func withDescription(schema map[string]interface{}, description string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(schema)+1)
+	for k, v := range schema {
+		copied[k] = v
+	}
+	copied["description"] = description
+	return copied
+}
+
+// resolveOpenAPIRefs replaces local $ref references such as
+// #/components/schemas/Pet with what they point to. A reference met again
+// inside itself, as in recursive schemas, or nested too deeply is cut off as
+// an empty schema.
+func resolveOpenAPIRefs(doc map[string]interface{}, node interface{}, visiting map[string]bool) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := n["$ref"].(string); ok {
+			if visiting[ref] || len(visiting) >= maxOpenAPIRefDepth {
+				return map[string]interface{}{}
+			}
+			visiting[ref] = true
+			defer delete(visiting, ref)
+			return resolveOpenAPIRefs(doc, lookupOpenAPIRef(doc, ref), visiting)
+		}
+		resolved := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			resolved[k] = resolveOpenAPIRefs(doc, v, visiting)
+		}
+		return resolved
+	case []interface{}:
+		resolved := make([]interface{}, len(n))
+		for i, v := range n {
+			resolved[i] = resolveOpenAPIRefs(doc, v, visiting)
+		}
+		return resolved
+	}
+	return node
+}
+
+// lookupOpenAPIRef follows a local JSON pointer reference
+func lookupOpenAPIRef(doc map[string]interface{}, ref string) interface{} {
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return map[string]interface{}{}
+	}
+	var node interface{} = doc
+	for _, part := range strings.Split(pointer, "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return map[string]interface{}{}
+		}
+		node = m[part]
+	}
+	if node == nil {
+		return map[string]interface{}{}
+	}
+	return node
+}
+
+var invalidFunctionChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// toolFunctionName turns an operation ID into a function name models accept
+func toolFunctionName(name string) string {
+	name = strings.Trim(invalidFunctionChars.ReplaceAllString(name, "_"), "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// toolServerClient is the HTTP client for tool servers, bounded by
+// TOOL_TIMEOUT. Tool server documents name the URLs called, so only publicly
+// routable addresses are reached, including through redirects, unless
+// TOOL_SERVER_ALLOW_PRIVATE is true for tool servers on the internal network.
+func toolServerClient() *http.Client {
+	if config.Config("TOOL_SERVER_ALLOW_PRIVATE") == "true" {
+		return &http.Client{Timeout: toolTimeout()}
+	}
+	return newPublicHTTPClient(toolTimeout(), nil)
+}
+
+// applyToolServerAuth adds a tool server's credentials to a request
+func applyToolServerAuth(req *http.Request, auth models.ToolServerAuth) {
+	switch auth.Type {
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+auth.Token)
+	case "basic":
+		req.SetBasicAuth(auth.Username, auth.Password)
+	case "header":
+		if auth.Header != "" {
+			req.Header.Set(auth.Header, auth.Token)
+		}
+	}
+}
+
+// OpenAPIExecutor calls the operations of tool servers over HTTP
+type OpenAPIExecutor struct{}
+
+// Execute implements ToolExecutor. The response body is returned as it is;
+// responses other than 2xx are reported as a *ToolCallError.
+func (OpenAPIExecutor) Execute(tool models.Tool, function string, arguments json.RawMessage) (string, error) {
+	operations, err := parseOpenAPI([]byte(tool.Content))
+	if err != nil {
+		return "", err
+	}
+	var op *openAPIOperation
+	for i := range operations {
+		if operations[i].Spec.Name == function {
+			op = &operations[i]
+			break
+		}
+	}
+	if op == nil {
+		return "", &ToolCallError{Message: fmt.Sprintf("unknown function %q", function)}
+	}
+
+	var args map[string]json.RawMessage
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return "", &ToolCallError{Message: "arguments must be a JSON object"}
+	}
+	req, err := newOpenAPIRequest(tool, *op, args)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := toolServerClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call tool server: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxToolOutput+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read tool server response: %w", err)
+	}
+	if len(data) > maxToolOutput {
+		return "", &ToolCallError{Message: fmt.Sprintf("tool output is larger than %d bytes", maxToolOutput)}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", &ToolCallError{Message: fmt.Sprintf("%s %s returned %d: %s", op.Method, op.Path, resp.StatusCode, strings.TrimSpace(string(data)))}
+	}
+	return string(data), nil
+}
+
+// newOpenAPIRequest builds the request for an operation from the arguments
+// of a call
+func newOpenAPIRequest(tool models.Tool, op openAPIOperation, args map[string]json.RawMessage) (*http.Request, error) {
+	base, err := openAPIServerURL(tool)
+	if err != nil {
+		return nil, err
+	}
+
+	path := op.Path
+	query := url.Values{}
+	headers := http.Header{}
+	for _, param := range op.Params {
+		raw, ok := args[param.Name]
+		if !ok {
+			if param.In == "path" {
+				return nil, &ToolCallError{Message: fmt.Sprintf("missing argument %q", param.Name)}
+			}
+			continue
+		}
+		values := argumentStrings(raw)
+		switch param.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+param.Name+"}", url.PathEscape(strings.Join(values, ",")))
+		case "query":
+			for _, v := range values {
+				query.Add(param.Name, v)
+			}
+		case "header":
+			headers.Set(param.Name, strings.Join(values, ","))
+		}
+	}
+
+	var body io.Reader
+	if op.WholeBody {
+		if raw, ok := args["body"]; ok {
+			body = bytes.NewReader(raw)
+		}
+	} else if len(op.BodyFields) > 0 {
+		fields := make(map[string]json.RawMessage)
+		for _, field := range op.BodyFields {
+			if raw, ok := args[field]; ok {
+				fields[field] = raw
+			}
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	target := strings.TrimSuffix(base, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(op.Method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	applyToolServerAuth(req, tool.Auth)
+	return req, nil
+}
+
+// openAPIServerURL returns the base URL of a tool server: the document's
+// first server, resolved against the URL the document was fetched from, with
+// its variables set to their defaults
+func openAPIServerURL(tool models.Tool) (string, error) {
+	var doc struct {
+		Servers []struct {
+			URL       string `json:"url"`
+			Variables map[string]struct {
+				Default string `json:"default"`
+			} `json:"variables"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal([]byte(tool.Content), &doc); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidOpenAPI, err)
+	}
+	documentURL, err := url.Parse(tool.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid tool server URL: %w", err)
+	}
+	server := "/"
+	if len(doc.Servers) > 0 && doc.Servers[0].URL != "" {
+		server = doc.Servers[0].URL
+		for name, variable := range doc.Servers[0].Variables {
+			server = strings.ReplaceAll(server, "{"+name+"}", variable.Default)
+		}
+	}
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid server URL %q", ErrInvalidOpenAPI, server)
+	}
+	return documentURL.ResolveReference(serverURL).String(), nil
+}
+
+// argumentStrings renders an argument for a path, query or header parameter.
+// Arrays give one value per element.
+func argumentStrings(raw json.RawMessage) []string {
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			values = append(values, argumentStrings(item)...)
+		}
+		return values
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return []string{text}
+	}
+	return []string{string(raw)}
+}
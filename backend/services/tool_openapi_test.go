@@ -0,0 +1,245 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"backend/models"
+)
+
+// testOpenAPIDocument is a tool server with path, query, header and body
+// parameters, served under /api
+const testOpenAPIDocument = `{
+  "openapi": "3.0.0",
+  "servers": [{"url": "/api"}],
+  "paths": {
+    "/pets/{petId}": {
+      "parameters": [{"name": "petId", "in": "path", "schema": {"type": "integer"}}],
+      "get": {
+        "operationId": "getPet",
+        "summary": "Get a pet",
+        "parameters": [
+          {"name": "fields", "in": "query", "schema": {"type": "array", "items": {"type": "string"}}},
+          {"name": "X-Trace", "in": "header", "description": "Trace ID"}
+        ]
+      },
+      "put": {
+        "operationId": "update pet!",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}
+        }
+      }
+    },
+    "/notes": {
+      "post": {
+        "operationId": "addNotes",
+        "requestBody": {"content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}}
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Pet": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {"name": {"type": "string"}, "tag": {"type": "string"}, "parent": {"$ref": "#/components/schemas/Pet"}}
+      }
+    }
+  }
+}`
+
+// toolServerCall is a request a test tool server received
+type toolServerCall struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   string
+}
+
+// newTestToolServer serves testOpenAPIDocument at /openapi.json and records
+// the calls to its operations. Calls to /api/pets/404 fail.
+func newTestToolServer(t *testing.T) (*httptest.Server, func() []toolServerCall) {
+	t.Helper()
+	var mu sync.Mutex
+	var calls []toolServerCall
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/openapi.json" {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, testOpenAPIDocument)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls = append(calls, toolServerCall{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header, Body: string(body)})
+		mu.Unlock()
+		if r.URL.Path == "/api/pets/404" {
+			http.Error(w, "no such pet", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []toolServerCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]toolServerCall(nil), calls...)
+	}
+}
+
+// fetchTestTool registers the test tool server as a tool
+func fetchTestTool(t *testing.T, server *httptest.Server) (models.Tool, []models.ToolSpec) {
+	t.Helper()
+	tool := models.Tool{Type: models.ToolTypeOpenAPI, URL: server.URL + "/openapi.json", Auth: models.ToolServerAuth{Type: "bearer", Token: "secret"}}
+	document, specs, err := FetchOpenAPITool(tool.URL, tool.Auth)
+	if err != nil {
+		t.Fatalf("FetchOpenAPITool: %v", err)
+	}
+	tool.Content = string(document)
+	return tool, specs
+}
+
+func TestFetchOpenAPIToolParsesOperations(t *testing.T) {
+	allowTestPrivateAddresses(t)
+	server, _ := newTestToolServer(t)
+	_, specs := fetchTestTool(t, server)
+
+	got := make(map[string]string)
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+		got[spec.Name] = string(spec.Parameters)
+	}
+	if strings.Join(names, ",") != "addNotes,getPet,update_pet" {
+		t.Fatalf("functions = %v, want addNotes, getPet and update_pet", names)
+	}
+	if specs[1].Description != "Get a pet" {
+		t.Errorf("getPet description = %q, want the summary", specs[1].Description)
+	}
+	for name, want := range map[string]string{
+		"getPet":     `{"properties":{"X-Trace":{"description":"Trace ID","type":"string"},"fields":{"items":{"type":"string"},"type":"array"},"petId":{"type":"integer"}},"required":["petId"],"type":"object"}`,
+		"update_pet": `{"properties":{"name":{"type":"string"},"parent":{},"petId":{"type":"integer"},"tag":{"type":"string"}},"required":["petId","name"],"type":"object"}`,
+		"addNotes":   `{"properties":{"body":{"items":{"type":"string"},"type":"array"}},"type":"object"}`,
+	} {
+		if got[name] != want {
+			t.Errorf("%s parameters = %s\nwant %s", name, got[name], want)
+		}
+	}
+}
+
+func TestFetchOpenAPIToolRejectsInvalidDocuments(t *testing.T) {
+	allowTestPrivateAddresses(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/swagger.json":
+			fmt.Fprint(w, `{"swagger": "2.0", "paths": {}}`)
+		case "/broken.json":
+			fmt.Fprint(w, `{"openapi": `)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/swagger.json", "/broken.json"} {
+		if _, _, err := FetchOpenAPITool(server.URL+path, models.ToolServerAuth{}); !errors.Is(err, ErrInvalidOpenAPI) {
+			t.Errorf("FetchOpenAPITool(%s) error = %v, want ErrInvalidOpenAPI", path, err)
+		}
+	}
+	if _, _, err := FetchOpenAPITool(server.URL+"/missing.json", models.ToolServerAuth{}); err == nil || !strings.Contains(err.Error(), "returned 404") {
+		t.Errorf("FetchOpenAPITool(missing) error = %v, want the status", err)
+	}
+}
+
+func TestToolServersMustBePublic(t *testing.T) {
+	server, calls := newTestToolServer(t)
+
+	if _, _, err := FetchOpenAPITool(server.URL+"/openapi.json", models.ToolServerAuth{}); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("fetching a document from loopback: error = %v, want ErrPrivateAddress", err)
+	}
+
+	// A document fetched earlier cannot point calls inside the network either
+	tool := models.Tool{Type: models.ToolTypeOpenAPI, URL: server.URL + "/openapi.json", Content: testOpenAPIDocument}
+	if _, err := (OpenAPIExecutor{}).Execute(tool, "getPet", json.RawMessage(`{"petId": 1}`)); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("calling a loopback server: error = %v, want ErrPrivateAddress", err)
+	}
+	if n := len(calls()); n != 0 {
+		t.Errorf("the tool server was called %d times", n)
+	}
+}
+
+func TestOpenAPIExecutorPlacesArguments(t *testing.T) {
+	allowTestPrivateAddresses(t)
+	server, calls := newTestToolServer(t)
+	tool, _ := fetchTestTool(t, server)
+	executor := OpenAPIExecutor{}
+
+	for _, call := range []struct {
+		function  string
+		arguments string
+	}{
+		{"getPet", `{"petId": 7, "fields": ["name", "tag"], "X-Trace": "abc"}`},
+		{"update_pet", `{"petId": "a b", "name": "Rex", "tag": "dog", "unknown": 1}`},
+		{"addNotes", `{"body": ["one", "two"]}`},
+	} {
+		result, err := executor.Execute(tool, call.function, json.RawMessage(call.arguments))
+		if err != nil || result != `{"ok":true}` {
+			t.Errorf("%s = %q, %v, want the response body", call.function, result, err)
+		}
+	}
+
+	got := calls()
+	if len(got) != 3 {
+		t.Fatalf("tool server got %d calls, want 3", len(got))
+	}
+	if c := got[0]; c.Method != "GET" || c.Path != "/api/pets/7" || c.Query != "fields=name&fields=tag" || c.Header.Get("X-Trace") != "abc" || c.Body != "" {
+		t.Errorf("getPet sent %s %s?%s with X-Trace %q and body %q", c.Method, c.Path, c.Query, c.Header.Get("X-Trace"), c.Body)
+	}
+	if c := got[0]; c.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("getPet sent Authorization %q, want the tool's credentials", c.Header.Get("Authorization"))
+	}
+	if c := got[1]; c.Method != "PUT" || c.Path != "/api/pets/a b" || c.Body != `{"name":"Rex","tag":"dog"}` || c.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("update_pet sent %s %s with %s body %s", c.Method, c.Path, c.Header.Get("Content-Type"), c.Body)
+	}
+	if c := got[2]; c.Method != "POST" || c.Path != "/api/notes" || c.Body != `["one", "two"]` {
+		t.Errorf("addNotes sent %s %s with body %s", c.Method, c.Path, c.Body)
+	}
+}
+
+func TestOpenAPIExecutorReportsErrors(t *testing.T) {
+	allowTestPrivateAddresses(t)
+	server, calls := newTestToolServer(t)
+	tool, _ := fetchTestTool(t, server)
+	executor := OpenAPIExecutor{}
+
+	for _, c := range []struct {
+		function  string
+		arguments string
+		want      string
+	}{
+		{"getPet", `{"petId": 404}`, "GET /pets/{petId} returned 404: no such pet"},
+		{"getPet", `{}`, `missing argument "petId"`},
+		{"getPet", `[1]`, "arguments must be a JSON object"},
+		{"feedPet", `{}`, `unknown function "feedPet"`},
+	} {
+		_, err := executor.Execute(tool, c.function, json.RawMessage(c.arguments))
+		var callErr *ToolCallError
+		if !errors.As(err, &callErr) || callErr.Message != c.want {
+			t.Errorf("%s(%s) error = %v, want %q", c.function, c.arguments, err, c.want)
+		}
+	}
+	if n := len(calls()); n != 1 {
+		t.Errorf("tool server got %d calls, want only the valid one", n)
+	}
+}
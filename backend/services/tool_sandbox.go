@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+)
+
+// Defaults of tool calls when TOOL_TIMEOUT, TOOL_CPU_SECONDS,
+// TOOL_MEMORY_MB and TOOL_UID are not set. Tools run as nobody by default.
+const (
+	DefaultToolTimeout    = 30 * time.Second
+	DefaultToolCPUSeconds = 10
+	DefaultToolMemoryMB   = 256
+	DefaultToolUID        = 65534
+)
+
+// defaultToolJailMounts are the host directories mounted read-only in the
+// jail, enough to run the system's Python, when TOOL_JAIL_MOUNTS is not set.
+// Those that do not exist are skipped.
+var defaultToolJailMounts = []string{"/usr", "/lib", "/lib64", "/lib32", "/bin", "/etc/alternatives", "/etc/ld.so.cache"}
+
+// toolSeccompSyscalls are the system calls tools may not make: debugging
+// and memory access to other processes, kernel and namespace
+// administration. socket is added when tools have no network.
+var toolSeccompSyscalls = []string{
+	"ptrace", "process_vm_readv", "process_vm_writev", "mount", "umount2", "pivot_root", "chroot",
+	"unshare", "setns", "kexec_load", "init_module", "finit_module", "delete_module", "bpf",
+	"perf_event_open", "keyctl", "add_key", "request_key", "userfaultfd", "swapon", "swapoff", "reboot",
+}
+
+const (
+	// maxToolOutput bounds the response a tool writes
+	maxToolOutput = 1 << 20 // 1 MB
+	// maxToolStderr bounds the diagnostics kept from a failed tool
+	maxToolStderr = 4 << 10 // 4 KB
+)
+
+// ErrToolTimeout is returned when a tool runs longer than its wall-clock limit
+var ErrToolTimeout = errors.New("tool timed out")
+
+var toolExecutorOnce sync.Once
+
+// ToolRunner returns the executor selected by TOOL_EXECUTOR: "none" (the
+// default), which runs no tools, or "subprocess", which runs them in a jail
+// (see NewSubprocessExecutor)
+func ToolRunner() ToolExecutor {
+	toolExecutorOnce.Do(func() {
+		switch config.Config("TOOL_EXECUTOR") {
+		case "subprocess":
+			toolExecutor = NewSubprocessExecutor()
+		default:
+			toolExecutor = unavailableExecutor{}
+		}
+	})
+	return toolExecutor
+}
+
+// ToolCallError is a failure reported by the tool itself, such as an
+// exception it raised, as opposed to a failure to run it
+type ToolCallError struct {
+	Message string
+}
+
+func (e *ToolCallError) Error() string {
+	return e.Message
+}
+
+// SubprocessExecutor runs Python tools in a separate interpreter for each
+// call, jailed with nsjail. The call is given as JSON on stdin and the result
+// read as JSON from stdout. The jail has its own user, PID, IPC, mount and,
+// unless AllowNetwork is set, network namespaces. Its file system holds only
+// Mounts, read-only, and an empty /tmp, and it runs as UID and GID, so the
+// backend's files, environment and processes are out of reach. A seccomp
+// policy denies the system calls in toolSeccompSyscalls, and the process
+// gets CPU and memory limits and a wall-clock timeout. Nothing runs when the
+// jail cannot be set up.
+type SubprocessExecutor struct {
+	Python       string
+	Jail         string
+	Mounts       []string
+	UID          int
+	GID          int
+	Timeout      time.Duration
+	CPUSeconds   int
+	MemoryBytes  int64
+	AllowNetwork bool
+}
+
+// NewSubprocessExecutor creates an executor from TOOL_PYTHON (python3 by
+// default), TOOL_NSJAIL (the nsjail binary, nsjail by default),
+// TOOL_JAIL_MOUNTS (comma-separated), TOOL_UID, TOOL_GID, TOOL_TIMEOUT
+// (seconds), TOOL_CPU_SECONDS, TOOL_MEMORY_MB and TOOL_ALLOW_NETWORK
+func NewSubprocessExecutor() *SubprocessExecutor {
+	e := &SubprocessExecutor{
+		Python:       config.Config("TOOL_PYTHON"),
+		Jail:         config.Config("TOOL_NSJAIL"),
+		UID:          DefaultToolUID,
+		GID:          DefaultToolUID,
+		Timeout:      toolTimeout(),
+		CPUSeconds:   DefaultToolCPUSeconds,
+		MemoryBytes:  DefaultToolMemoryMB << 20,
+		AllowNetwork: config.Config("TOOL_ALLOW_NETWORK") == "true",
+	}
+	if e.Python == "" {
+		e.Python = "python3"
+	}
+	if e.Jail == "" {
+		e.Jail = "nsjail"
+	}
+	if mounts := config.Config("TOOL_JAIL_MOUNTS"); mounts != "" {
+		for _, m := range strings.Split(mounts, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				e.Mounts = append(e.Mounts, m)
+			}
+		}
+	} else {
+		for _, m := range defaultToolJailMounts {
+			if _, err := os.Stat(m); err == nil {
+				e.Mounts = append(e.Mounts, m)
+			}
+		}
+	}
+	if n, err := strconv.Atoi(config.Config("TOOL_UID")); err == nil && n > 0 {
+		e.UID, e.GID = n, n
+	}
+	if n, err := strconv.Atoi(config.Config("TOOL_GID")); err == nil && n > 0 {
+		e.GID = n
+	}
+	if n, err := strconv.Atoi(config.Config("TOOL_CPU_SECONDS")); err == nil && n >= 0 {
+		e.CPUSeconds = n
+	}
+	if n, err := strconv.ParseInt(config.Config("TOOL_MEMORY_MB"), 10, 64); err == nil && n >= 0 {
+		e.MemoryBytes = n << 20
+	}
+	return e
+}
+
+// toolTimeout is the wall-clock limit of a tool call
+func toolTimeout() time.Duration {
+	if n, err := strconv.Atoi(config.Config("TOOL_TIMEOUT")); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return DefaultToolTimeout
+}
+
+// sandboxRequest is what the harness reads from stdin
+type sandboxRequest struct {
+	Code      string          `json:"code"`
+	Function  string          `json:"function"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// sandboxResponse is what the harness writes to stdout
+type sandboxResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// Execute implements ToolExecutor. Results that are strings are returned as
+// they are, others as JSON.
+func (e *SubprocessExecutor) Execute(tool models.Tool, function string, arguments json.RawMessage) (string, error) {
+	res, err := e.run(sandboxRequest{Code: tool.Content, Function: function, Arguments: arguments})
+	if err != nil {
+		return "", err
+	}
+	var text string
+	if json.Unmarshal(res.Result, &text) == nil {
+		return text, nil
+	}
+	return string(res.Result), nil
+}
+
+// jailArgs are the arguments of nsjail that run command in the jail
+func (e *SubprocessExecutor) jailArgs(command ...string) []string {
+	cpu, memory := strconv.Itoa(e.CPUSeconds), strconv.FormatInt(e.MemoryBytes>>20, 10)
+	if e.CPUSeconds == 0 {
+		cpu = "inf"
+	}
+	if e.MemoryBytes == 0 {
+		memory = "inf"
+	}
+	args := []string{
+		"--mode", "o", "--quiet",
+		"--user", fmt.Sprintf("%d:%d:1", e.UID, e.UID),
+		"--group", fmt.Sprintf("%d:%d:1", e.GID, e.GID),
+		"--hostname", "tool",
+		"--disable_proc",
+		"--tmpfsmount", "/tmp",
+		"--cwd", "/tmp",
+		"--time_limit", strconv.Itoa(int(e.Timeout / time.Second)),
+		"--rlimit_cpu", cpu,
+		"--rlimit_as", memory,
+		"--rlimit_fsize", "16",
+		"--rlimit_nofile", "64",
+		"--rlimit_core", "0",
+	}
+	for _, m := range e.Mounts {
+		args = append(args, "--bindmount_ro", m)
+	}
+	// The interpreter may live outside the mounts, e.g. in /usr/local
+	if python, err := exec.LookPath(e.Python); err == nil {
+		if dir := filepath.Dir(python); !e.mounted(dir) {
+			args = append(args, "--bindmount_ro", dir)
+		}
+	}
+	for _, env := range []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=/tmp", "TMPDIR=/tmp", "PYTHONDONTWRITEBYTECODE=1", "LANG=C.UTF-8"} {
+		args = append(args, "--env", env)
+	}
+
+	denied := toolSeccompSyscalls
+	if e.AllowNetwork {
+		args = append(args, "--disable_clone_newnet")
+	} else {
+		denied = append(denied[:len(denied):len(denied)], "socket")
+	}
+	args = append(args, "--seccomp_string",
+		fmt.Sprintf("POLICY tool { ERRNO(1) { %s } } USE tool DEFAULT ALLOW", strings.Join(denied, ", ")))
+
+	return append(append(args, "--"), command...)
+}
+
+// mounted reports whether dir is within one of the jail's mounts
+func (e *SubprocessExecutor) mounted(dir string) bool {
+	for _, m := range e.Mounts {
+		if dir == m || strings.HasPrefix(dir, strings.TrimSuffix(m, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// run starts the harness in a fresh jail and returns its response
+func (e *SubprocessExecutor) run(request sandboxRequest) (sandboxResponse, error) {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return sandboxResponse{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+
+	network := "0"
+	if e.AllowNetwork {
+		network = "1"
+	}
+	python := e.Python
+	if path, err := exec.LookPath(e.Python); err == nil {
+		python = path
+	}
+	cmd := exec.CommandContext(ctx, e.Jail, e.jailArgs(python, "-I", "-c", toolHarness,
+		strconv.Itoa(e.CPUSeconds), strconv.FormatInt(e.MemoryBytes, 10), network)...)
+	cmd.Env = []string{}
+	cmd.Stdin = bytes.NewReader(input)
+	stdout := &limitedBuffer{limit: maxToolOutput}
+	stderr := &limitedBuffer{limit: maxToolStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.WaitDelay = time.Second
+	if err := startToolProcess(cmd); err != nil {
+		return sandboxResponse{}, fmt.Errorf("failed to start tool process: %w", err)
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return sandboxResponse{}, fmt.Errorf("%w after %s", ErrToolTimeout, e.Timeout)
+	}
+	if stdout.truncated {
+		return sandboxResponse{}, &ToolCallError{Message: fmt.Sprintf("tool output is larger than %d bytes", maxToolOutput)}
+	}
+
+	var res sandboxResponse
+	if decodeErr := json.Unmarshal(stdout.Bytes(), &res); decodeErr != nil {
+		if err == nil {
+			err = decodeErr
+		}
+		if reason := toolExitReason(err); reason != "" {
+			return sandboxResponse{}, &ToolCallError{Message: reason}
+		}
+		return sandboxResponse{}, fmt.Errorf("tool process failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if res.Error != "" {
+		return res, &ToolCallError{Message: res.Error}
+	}
+	return res, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package services
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// startToolProcess starts a jailed tool process in its own process group,
+// killed with the backend. The namespaces are created by the jail, which
+// exits with an error instead of running the tool when it cannot set them
+// up.
+func startToolProcess(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	// Kill everything the tool started, not only the jail
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd.Start()
+}
+
+// toolExitReason explains a tool process killed for exceeding a limit. The
+// jail exits with 128 plus the number of the signal that killed the tool.
+func toolExitReason(err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ""
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ""
+	}
+	var signal syscall.Signal
+	switch {
+	case status.Signaled():
+		signal = status.Signal()
+	case status.ExitStatus() > 128:
+		signal = syscall.Signal(status.ExitStatus() - 128)
+	default:
+		return ""
+	}
+	switch signal {
+	case syscall.SIGXCPU, syscall.SIGKILL:
+		return "tool exceeded its CPU time limit"
+	case syscall.SIGSEGV, syscall.SIGABRT:
+		return "tool exceeded its memory limit"
+	}
+	return ""
+}
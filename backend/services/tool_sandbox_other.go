@@ -0,0 +1,19 @@
+//go:build !linux
+
+package services
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// startToolProcess refuses to start tools: the jail needs Linux namespaces
+func startToolProcess(cmd *exec.Cmd) error {
+	return fmt.Errorf("%w: tools can only be jailed on Linux, not %s", ErrToolExecutionUnavailable, runtime.GOOS)
+}
+
+// toolExitReason explains a tool process killed for exceeding a limit
+func toolExitReason(err error) string {
+	return ""
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+)
+
+// toolSpecsTimeout bounds reading the functions of a tool's code
+const toolSpecsTimeout = 10 * time.Second
+
+// GenerateToolSpecs reads the function signatures of a tool's code into its
+// Specs. The code is only parsed, never run, so specs can be generated
+// whether or not tools can be executed. It needs TOOL_PYTHON (python3 by
+// default) and returns ErrToolExecutionUnavailable when it is missing.
+func GenerateToolSpecs(code string) ([]models.ToolSpec, error) {
+	python := config.Config("TOOL_PYTHON")
+	if python == "" {
+		python = "python3"
+	}
+	if _, err := exec.LookPath(python); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrToolExecutionUnavailable, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), toolSpecsTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, python, "-I", "-c", toolSpecsProgram,
+		strconv.Itoa(DefaultToolCPUSeconds), strconv.FormatInt(DefaultToolMemoryMB<<20, 10))
+	cmd.Env = []string{"LANG=C.UTF-8"}
+	cmd.Stdin = strings.NewReader(code)
+	stdout := &limitedBuffer{limit: maxToolOutput}
+	stderr := &limitedBuffer{limit: maxToolStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%w after %s", ErrToolTimeout, toolSpecsTimeout)
+	}
+	var res struct {
+		Specs []models.ToolSpec `json:"specs"`
+		Error string            `json:"error"`
+	}
+	if decodeErr := json.Unmarshal(stdout.Bytes(), &res); decodeErr != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, &ToolCallError{Message: "tool code could not be parsed"}
+		}
+		return nil, fmt.Errorf("failed to read tool specs: %v: %s", decodeErr, strings.TrimSpace(stderr.String()))
+	}
+	if res.Error != "" {
+		return nil, &ToolCallError{Message: res.Error}
+	}
+	if res.Specs == nil {
+		return []models.ToolSpec{}, nil
+	}
+	return res.Specs, nil
+}
+
+// toolSpecsProgram is the Python program that reads the functions of a
+// tool's code, given on stdin, with the ast module. It applies the resource
+// limits given as arguments (CPU seconds, memory bytes) and writes
+// {"specs": [...]} or {"error": ...} to stdout.
+//
+// The functions are the public methods of a Tools class, or the module's own
+// functions when there is no such class. Type annotations become JSON Schema
+// types, parameters without a default are required, and the docstring gives
+// the description and ":param name:" descriptions. Parameters named with a
+// leading "__" are reserved and are not offered to models.
+const toolSpecsProgram = `
+import ast
+import json
+import sys
+
+
+def apply_limits(cpu_seconds, memory_bytes):
+    try:
+        import resource
+
+        resource.setrlimit(resource.RLIMIT_CPU, (cpu_seconds, cpu_seconds))
+        resource.setrlimit(resource.RLIMIT_AS, (memory_bytes, memory_bytes))
+        resource.setrlimit(resource.RLIMIT_CORE, (0, 0))
+    except ImportError:
+        pass
+
+
+JSON_TYPES = {
+    "str": "string",
+    "int": "integer",
+    "float": "number",
+    "bool": "boolean",
+    "list": "array",
+    "List": "array",
+    "tuple": "array",
+    "Tuple": "array",
+    "set": "array",
+    "Set": "array",
+    "dict": "object",
+    "Dict": "object",
+}
+
+
+def annotation_name(node):
+    if isinstance(node, ast.Name):
+        return node.id
+    if isinstance(node, ast.Attribute):
+        return node.attr
+    return None
+
+
+def type_schema(node):
+    if node is None:
+        return {"type": "string"}
+    if isinstance(node, ast.Constant) and isinstance(node.value, str):
+        try:
+            return type_schema(ast.parse(node.value, mode="eval").body)
+        except SyntaxError:
+            return {"type": "string"}
+    if isinstance(node, ast.BinOp) and isinstance(node.op, ast.BitOr):
+        options = [n for n in (node.left, node.right) if not (isinstance(n, ast.Constant) and n.value is None)]
+        return type_schema(options[0]) if options else {"type": "string"}
+    if isinstance(node, ast.Subscript):
+        name = annotation_name(node.value)
+        args = node.slice.elts if isinstance(node.slice, ast.Tuple) else [node.slice]
+        if name in ("Optional", "Union"):
+            options = [a for a in args if not (isinstance(a, ast.Constant) and a.value is None)]
+            return type_schema(options[0]) if options else {"type": "string"}
+        if name == "Literal":
+            try:
+                values = [ast.literal_eval(a) for a in args]
+            except ValueError:
+                return {"type": "string"}
+            schema = {"enum": values}
+            if values and type(values[0]).__name__ in JSON_TYPES:
+                schema["type"] = JSON_TYPES[type(values[0]).__name__]
+            return schema
+        if name == "Annotated" and args:
+            return type_schema(args[0])
+        kind = JSON_TYPES.get(name, "string")
+        schema = {"type": kind}
+        if kind == "array" and args:
+            schema["items"] = type_schema(args[0])
+        return schema
+    return {"type": JSON_TYPES.get(annotation_name(node), "string")}
+
+
+def parse_docstring(doc):
+    description, params = [], {}
+    for line in doc.splitlines():
+        line = line.strip()
+        if line.startswith(":param "):
+            name, _, text = line[len(":param "):].partition(":")
+            params[name.split()[-1]] = text.strip()
+        elif line.startswith(":"):
+            continue
+        elif not params:
+            description.append(line)
+    return " ".join(l for l in description if l), params
+
+
+def function_spec(function, method):
+    description, param_docs = parse_docstring(ast.get_docstring(function) or "")
+    args = function.args
+    positional = args.posonlyargs + args.args
+    static = any(annotation_name(d) == "staticmethod" for d in function.decorator_list)
+    if method and not static:
+        positional = positional[1:]
+
+    defaults = len(args.defaults)
+    params = [(a, i >= len(positional) - defaults) for i, a in enumerate(positional)]
+    params += [(a, d is not None) for a, d in zip(args.kwonlyargs, args.kw_defaults)]
+
+    properties, required = {}, []
+    for arg, has_default in params:
+        if arg.arg.startswith("__"):
+            continue
+        schema = type_schema(arg.annotation)
+        if arg.arg in param_docs:
+            schema["description"] = param_docs[arg.arg]
+        properties[arg.arg] = schema
+        if not has_default:
+            required.append(arg.arg)
+
+    parameters = {"type": "object", "properties": properties}
+    if required:
+        parameters["required"] = required
+    spec = {"name": function.name, "parameters": parameters}
+    if description:
+        spec["description"] = description
+    return spec
+
+
+def tool_functions(module):
+    functions = (ast.FunctionDef, ast.AsyncFunctionDef)
+    for node in module.body:
+        if isinstance(node, ast.ClassDef) and node.name == "Tools":
+            return [(n, True) for n in node.body if isinstance(n, functions) and not n.name.startswith("_")]
+    return [(n, False) for n in module.body if isinstance(n, functions) and not n.name.startswith("_")]
+
+
+def main():
+    apply_limits(int(sys.argv[1]), int(sys.argv[2]))
+    try:
+        module = ast.parse(sys.stdin.read(), "tool.py")
+        specs = {}
+        for function, method in tool_functions(module):
+            specs[function.name] = function_spec(function, method)
+        response = {"specs": [specs[name] for name in sorted(specs)]}
+    except BaseException as e:
+        response = {"error": "%s: %s" % (type(e).__name__, e)}
+    sys.stdout.write(json.dumps(response))
+
+
+main()
+`
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateToolSpecsParsesWithoutRunning(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	t.Setenv("TOOL_PYTHON", "python3")
+
+	code := `
+from typing import Literal, Optional
+
+raise RuntimeError("the module was run")
+
+
+class Tools:
+    def get_weather(self, city: str, units: Literal["metric", "imperial"] = "metric", days: Optional[int] = None) -> str:
+        """
+        Get the weather forecast.
+        :param city: Name of the city
+        """
+        return city
+
+    async def tags(self, names: list[str], *, limit: "int", __user__: dict = {}) -> list:
+        return names
+
+    def _helper(self):
+        pass
+`
+	specs, err := GenerateToolSpecs(code)
+	if err != nil {
+		t.Fatalf("GenerateToolSpecs: %v", err)
+	}
+	got, _ := json.Marshal(specs)
+	want := `[{"name":"get_weather","description":"Get the weather forecast.","parameters":{"type":"object","properties":{"city":{"type":"string","description":"Name of the city"},"units":{"enum":["metric","imperial"],"type":"string"},"days":{"type":"integer"}},"required":["city"]}},` +
+		`{"name":"tags","parameters":{"type":"object","properties":{"names":{"type":"array","items":{"type":"string"}},"limit":{"type":"integer"}},"required":["names","limit"]}}]`
+	if string(got) != want {
+		t.Errorf("specs = %s\nwant %s", got, want)
+	}
+
+	if _, err := GenerateToolSpecs("def broken(:\n"); err == nil {
+		t.Error("code with a syntax error was accepted")
+	} else if _, ok := err.(*ToolCallError); !ok {
+		t.Errorf("syntax error = %v, want a ToolCallError", err)
+	}
+}
+
+func TestSubprocessExecutorJailArgs(t *testing.T) {
+	e := &SubprocessExecutor{
+		Python:      "python3",
+		Mounts:      []string{"/usr", "/lib"},
+		UID:         65534,
+		GID:         65534,
+		Timeout:     30 * time.Second,
+		CPUSeconds:  10,
+		MemoryBytes: 256 << 20,
+	}
+	args := strings.Join(e.jailArgs("python3", "-c", "pass"), " ")
+	for _, want := range []string{
+		"--user 65534:65534:1", "--group 65534:65534:1", "--disable_proc", "--bindmount_ro /usr",
+		"--rlimit_as 256", "--time_limit 30", "socket } } USE tool DEFAULT ALLOW", "-- python3 -c pass",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("jail args %q lack %q", args, want)
+		}
+	}
+	if strings.Contains(args, "--disable_clone_newnet") {
+		t.Error("a tool without network shares the backend's network namespace")
+	}
+
+	e.AllowNetwork = true
+	args = strings.Join(e.jailArgs("python3"), " ")
+	if !strings.Contains(args, "--disable_clone_newnet") || strings.Contains(args, "socket }") {
+		t.Errorf("jail args %q do not give the tool network access", args)
+	}
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"unicode/utf8"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+)
+
+// DefaultToolMaxIterations is how many rounds of tool calls a model may make
+// in one chat completion when TOOL_MAX_ITERATIONS is not set
+const DefaultToolMaxIterations = 5
+
+// maxToolResultLength bounds the characters of a tool result given back to
+// the model
+const maxToolResultLength = 16000
+
+var (
+	// ErrToolNotFound is returned for a tool that does not exist or belongs
+	// to another user
+	ErrToolNotFound = errors.New("tool not found")
+	// ErrToolExecutionUnavailable is returned when no executor can run a tool
+	ErrToolExecutionUnavailable = errors.New("tool execution is not available")
+)
+
+// ToolExecutor runs a function of a tool with arguments, a JSON object, and
+// returns its result as text for the model
+type ToolExecutor interface {
+	Execute(tool models.Tool, function string, arguments json.RawMessage) (string, error)
+}
+
+// unavailableExecutor fails every call
+type unavailableExecutor struct{}
+
+func (unavailableExecutor) Execute(tool models.Tool, function string, arguments json.RawMessage) (string, error) {
+	return "", ErrToolExecutionUnavailable
+}
+
+var toolExecutor ToolExecutor
+
+// SetToolExecutor replaces the executor that runs the code of tools
+func SetToolExecutor(executor ToolExecutor) {
+	toolExecutorOnce.Do(func() {})
+	toolExecutor = executor
+}
+
+// toolExecutorFor returns the executor that runs a tool: tool servers are
+// called over HTTP and code runs in the configured executor
+func toolExecutorFor(tool models.Tool) ToolExecutor {
+	if tool.Type == models.ToolTypeOpenAPI {
+		return OpenAPIExecutor{}
+	}
+	return ToolRunner()
+}
+
+// ToolSpecs returns the functions a tool declares in its Specs
+func ToolSpecs(tool models.Tool) []models.ToolSpec {
+	var specs []models.ToolSpec
+	if len(tool.Specs) == 0 || json.Unmarshal(tool.Specs, &specs) != nil {
+		return nil
+	}
+	return specs
+}
+
+// toolFunction is a function of a tool offered to a model
+type toolFunction struct {
+	tool models.Tool
+	spec models.ToolSpec
+}
+
+// Toolset is the set of tool functions offered to a model in a chat
+// completion, by function name
+type Toolset struct {
+	functions   map[string]toolFunction
+	definitions []models.ToolDefinition
+}
+
+// LoadToolset loads the user's tools with the given IDs. It returns nil when
+// no tools are requested. When two tools declare a function with the same
+// name, the first one wins.
+func LoadToolset(userID uint, ids []string) (*Toolset, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	set := &Toolset{functions: make(map[string]toolFunction)}
+	for _, id := range ids {
+		var tool models.Tool
+		// TODO: Implement access control logic as in python/backend/open_webui/routers/tools.py
+		if result := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&tool); result.Error != nil {
+			return nil, fmt.Errorf("%w: %s", ErrToolNotFound, id)
+		}
+		for _, spec := range ToolSpecs(tool) {
+			if _, ok := set.functions[spec.Name]; ok || spec.Name == "" {
+				log.Printf("Skipping function %q of tool %s: duplicate or empty name", spec.Name, tool.ID)
+				continue
+			}
+			if len(spec.Parameters) == 0 {
+				spec.Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+			}
+			set.functions[spec.Name] = toolFunction{tool: tool, spec: spec}
+			set.definitions = append(set.definitions, models.ToolDefinition{Type: "function", Function: spec})
+		}
+	}
+	return set, nil
+}
+
+// Definitions returns the functions of the toolset for a chat request
+func (s *Toolset) Definitions() []models.ToolDefinition {
+	if s == nil {
+		return nil
+	}
+	return s.definitions
+}
+
+// Call runs a tool call and returns its result as a tool message. Failures
+// are reported to the model in the result rather than ending the completion.
+func (s *Toolset) Call(call models.ToolCall) models.Message {
+	result := models.Message{Role: "tool", ToolCallID: call.ID, ToolName: call.Function.Name}
+
+	function, ok := s.functions[call.Function.Name]
+	if !ok {
+		result.Content = fmt.Sprintf("Error: unknown function %q", call.Function.Name)
+		return result
+	}
+	output, err := toolExecutorFor(function.tool).Execute(function.tool, call.Function.Name, call.Function.Arguments)
+	if err != nil {
+		log.Printf("Call to function %q of tool %s failed: %v", call.Function.Name, function.tool.ID, err)
+		result.Content = "Error: " + err.Error()
+		return result
+	}
+	if utf8.RuneCountInString(output) > maxToolResultLength {
+		output = string([]rune(output)[:maxToolResultLength]) + "\n[truncated]"
+	}
+	result.Content = output
+	return result
+}
+
+// toolMaxIterations is how many rounds of tool calls a model may make
+func toolMaxIterations() int {
+	n, err := strconv.Atoi(config.Config("TOOL_MAX_ITERATIONS"))
+	if err != nil || n < 0 {
+		return DefaultToolMaxIterations
+	}
+	return n
+}
+
+// normalizeToolCalls decodes arguments sent as a JSON string and gives every
+// call an ID, which Ollama does not
+func normalizeToolCalls(calls []models.ToolCall) {
+	for i := range calls {
+		call := &calls[i]
+		call.Type = "function"
+		if call.ID == "" {
+			b := make([]byte, 12)
+			rand.Read(b)
+			call.ID = "call_" + hex.EncodeToString(b)
+		}
+
+		var encoded string
+		if json.Unmarshal(call.Function.Arguments, &encoded) == nil {
+			if encoded == "" {
+				encoded = "{}"
+			}
+			if json.Valid([]byte(encoded)) {
+				call.Function.Arguments = json.RawMessage(encoded)
+			}
+		}
+		if len(call.Function.Arguments) == 0 || string(call.Function.Arguments) == "null" {
+			call.Function.Arguments = json.RawMessage("{}")
+		}
+	}
+}
+
+// CallOllamaWithTools sends messages to Ollama with the toolset's functions
+// and runs the tool calls the model makes, giving it the results, until it
+// answers or TOOL_MAX_ITERATIONS rounds have been made; the last request
+// offers no tools so that the model has to answer. record is called with
+// every tool call and result message.
+func CallOllamaWithTools(request models.OllamaChatRequest, messages []models.Message, vision bool, tools *Toolset, record func(models.Message)) (*models.OllamaChatResponse, error) {
+	for i := 0; ; i++ {
+		request.Messages = OllamaMessages(messages, vision)
+		request.Tools = nil
+		if i < toolMaxIterations() {
+			request.Tools = tools.Definitions()
+		}
+
+		res, err := CallOllama(request)
+		if err != nil || len(request.Tools) == 0 || len(res.Message.ToolCalls) == 0 {
+			return res, err
+		}
+		messages = append(messages, runToolCalls(res.Message, tools, record)...)
+	}
+}
+
+// CallOpenAIWithTools is CallOllamaWithTools for the OpenAI API
+func CallOpenAIWithTools(request models.OpenAIChatRequest, messages []models.Message, vision bool, tools *Toolset, record func(models.Message)) (*models.OpenAIChatResponse, error) {
+	for i := 0; ; i++ {
+		request.Messages = OpenAIMessages(messages, vision)
+		request.Tools = nil
+		if i < toolMaxIterations() {
+			request.Tools = tools.Definitions()
+		}
+
+		res, err := CallOpenAI(request)
+		if err != nil || len(request.Tools) == 0 || len(res.Choices) == 0 || len(res.Choices[0].Message.ToolCalls) == 0 {
+			return res, err
+		}
+		messages = append(messages, runToolCalls(res.Choices[0].Message, tools, record)...)
+	}
+}
+
+// runToolCalls runs the tool calls of an assistant message and returns the
+// message followed by the results
+func runToolCalls(reply models.Message, tools *Toolset, record func(models.Message)) []models.Message {
+	normalizeToolCalls(reply.ToolCalls)
+	assistant := models.Message{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls}
+	record(assistant)
+
+	messages := []models.Message{assistant}
+	for _, call := range reply.ToolCalls {
+		result := tools.Call(call)
+		record(result)
+		messages = append(messages, result)
+	}
+	return messages
+}
@@ -0,0 +1,491 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Resumable upload defaults, used when UPLOAD_MAX_SIZE, UPLOAD_MAX_PENDING
+// and UPLOAD_EXPIRY_HOURS are not set
+const (
+	DefaultUploadMaxSize     = 10 << 30 // 10 GB per file
+	DefaultUploadMaxPending  = 20 << 30 // 20 GB of unfinished uploads per user
+	DefaultUploadExpiryHours = 24
+)
+
+// Leases on an upload. A request or worker that dies while holding one only
+// blocks the upload until it runs out. Writing a chunk renews its lease
+// every uploadLeaseRenewal for as long as the chunk takes.
+const (
+	uploadWriteLease    = 15 * time.Minute
+	uploadCompleteLease = time.Hour
+	uploadLeaseRenewal  = uploadWriteLease / 3
+)
+
+var (
+	// ErrUploadNotFound is returned for an upload that does not exist, has
+	// expired or belongs to another user
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadTooLarge is returned for an upload, or a chunk, beyond the
+	// allowed size
+	ErrUploadTooLarge = errors.New("upload exceeds the maximum size")
+	// ErrUploadLimit is returned when a user's unfinished uploads would
+	// exceed UPLOAD_MAX_PENDING
+	ErrUploadLimit = errors.New("too much unfinished upload data, complete or cancel other uploads first")
+	// ErrUploadOffsetMismatch is returned when a chunk does not start where
+	// the upload ended
+	ErrUploadOffsetMismatch = errors.New("chunk offset does not match the upload offset")
+	// ErrUploadChecksum is returned when a chunk or the completed content
+	// does not match its checksum
+	ErrUploadChecksum = errors.New("checksum mismatch")
+	// ErrUploadIncomplete is returned when completing an upload that has not
+	// received all of its bytes
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	// ErrUploadBusy is returned while another request is writing to the
+	// upload or it is being completed
+	ErrUploadBusy = errors.New("upload is being written by another request")
+	// ErrUploadClosed is returned when writing to or completing an upload
+	// that was already completed or failed for good
+	ErrUploadClosed = errors.New("upload was already completed or failed, start a new one")
+)
+
+var janitorOnce sync.Once
+
+// UploadMaxSize returns the largest file that can be uploaded, in bytes,
+// for roles without UPLOAD_MAX_SIZE_<ROLE>
+func UploadMaxSize() int64 {
+	return uploadLimit("UPLOAD_MAX_SIZE", DefaultUploadMaxSize)
+}
+
+// UploadMaxPending returns how many bytes a user's unfinished uploads may
+// reserve in total
+func UploadMaxPending() int64 {
+	return uploadLimit("UPLOAD_MAX_PENDING", DefaultUploadMaxPending)
+}
+
+func uploadLimit(key string, fallback int64) int64 {
+	if n, err := strconv.ParseInt(config.Config(key), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+func uploadExpiry() time.Duration {
+	hours, _ := strconv.Atoi(config.Config("UPLOAD_EXPIRY_HOURS"))
+	if hours <= 0 {
+		hours = DefaultUploadExpiryHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// uploadPartKey is the storage key of the chunk of an upload received at
+// offset. Chunks are staged in file storage so that every instance can
+// append to and complete any upload.
+func uploadPartKey(id uint, offset int64) string {
+	return fmt.Sprintf("upload-%d-%d", id, offset)
+}
+
+// CreateUpload starts a resumable upload of form.Size bytes for a user
+func CreateUpload(userID uint, form models.UploadForm) (models.Upload, error) {
+	if form.Size > UserFilePolicy(userID).MaxSize {
+		return models.Upload{}, ErrUploadTooLarge
+	}
+	checksum := strings.ToLower(form.SHA256)
+	if checksum != "" && !IsBlobKey(checksum) {
+		return models.Upload{}, fmt.Errorf("sha256 must be a hex SHA-256")
+	}
+
+	var pending int64
+	database.DB.Model(&models.Upload{}).
+		Where("user_id = ? AND expires_at > ? AND status IN ?", userID, time.Now(), []string{models.UploadReceiving, models.UploadProcessing}).
+		Select("COALESCE(SUM(size), 0)").Scan(&pending)
+	if pending+form.Size > UploadMaxPending() {
+		return models.Upload{}, ErrUploadLimit
+	}
+	// Unfinished uploads count towards the quota, as they will become files
+	if err := CheckStorageQuota(userID, pending+form.Size); err != nil {
+		return models.Upload{}, err
+	}
+
+	upload := models.Upload{
+		UserID:    userID,
+		FolderID:  form.FolderID,
+		Name:      form.Name,
+		MimeType:  form.MimeType,
+		Size:      form.Size,
+		SHA256:    checksum,
+		Status:    models.UploadReceiving,
+		ExpiresAt: time.Now().Add(uploadExpiry()),
+	}
+	if result := database.DB.Create(&upload); result.Error != nil {
+		return models.Upload{}, result.Error
+	}
+	return upload, nil
+}
+
+// GetUpload returns a user's unexpired upload
+func GetUpload(id uint, userID uint) (models.Upload, error) {
+	var upload models.Upload
+	if result := database.DB.Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, time.Now()).First(&upload); result.Error != nil {
+		return models.Upload{}, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// AppendUpload writes a chunk at offset, which must be the upload's current
+// offset. With a checksum (hex SHA-256) the chunk is only kept if it
+// matches; without one, the bytes received before a dropped connection are
+// kept so the client can resume from the new offset.
+func AppendUpload(id uint, userID uint, offset int64, chunk io.Reader, checksum string) (models.Upload, error) {
+	upload, err := lockUpload(id, userID, uploadWriteLease)
+	if err != nil {
+		return upload, err
+	}
+	defer unlockUpload(upload)
+	defer holdUploadLease(upload, uploadWriteLease)()
+
+	if upload.Status != models.UploadReceiving {
+		return upload, ErrUploadClosed
+	}
+	if offset != upload.Offset {
+		return upload, ErrUploadOffsetMismatch
+	}
+
+	tmp, err := os.CreateTemp("", "upload-chunk-*")
+	if err != nil {
+		return upload, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// Read one byte past the remaining size to detect oversized chunks
+	hash := sha256.New()
+	remaining := upload.Size - offset
+	n, copyErr := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(chunk, remaining+1))
+	switch {
+	case n > remaining:
+		return upload, ErrUploadTooLarge
+	case checksum != "" && copyErr != nil:
+		return upload, copyErr
+	case checksum != "" && !strings.EqualFold(checksum, hex.EncodeToString(hash.Sum(nil))):
+		return upload, ErrUploadChecksum
+	case n == 0:
+		return upload, copyErr
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return upload, err
+	}
+	if err := fileStorage.Put(uploadPartKey(upload.ID, offset), tmp, n, "application/octet-stream"); err != nil {
+		return upload, err
+	}
+
+	// A chunk stored at this offset before a failed update is overwritten
+	// by the retry, so the offset only moves once the chunk is stored, and
+	// only while this request still holds the lease
+	result := database.DB.Model(&models.Upload{}).Where("id = ? AND upload_offset = ? AND lock_token = ?", upload.ID, offset, upload.LockToken).
+		UpdateColumns(map[string]interface{}{"upload_offset": offset + n, "expires_at": time.Now().Add(uploadExpiry()), "updated_at": time.Now()})
+	if result.Error != nil {
+		return upload, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return upload, ErrUploadBusy
+	}
+	upload.Offset = offset + n
+	upload.ExpiresAt = time.Now().Add(uploadExpiry())
+	return upload, copyErr
+}
+
+// CompleteUpload starts turning a fully received upload into a file and
+// returns it with the status processing. The content is then assembled,
+// checked with StoreUploadFile and saved as a File in the background, and
+// the upload reports the outcome: completed with its FileID, or an Error.
+// Uploads whose malware scan failed or that did not fit in the quota go back
+// to receiving so that completing can be retried; other rejections fail the
+// upload for good.
+func CompleteUpload(id uint, userID uint) (models.Upload, error) {
+	upload, err := lockUpload(id, userID, uploadCompleteLease)
+	if err != nil {
+		return upload, err
+	}
+
+	// A processing upload whose lease ran out lost its worker, so it is
+	// completed again
+	if upload.Status != models.UploadReceiving && upload.Status != models.UploadProcessing {
+		unlockUpload(upload)
+		return upload, ErrUploadClosed
+	}
+	if upload.Offset != upload.Size {
+		unlockUpload(upload)
+		return upload, ErrUploadIncomplete
+	}
+
+	upload.Status = models.UploadProcessing
+	upload.Error = ""
+	result := database.DB.Model(&upload).UpdateColumns(map[string]interface{}{"status": upload.Status, "error": "", "updated_at": time.Now()})
+	if result.Error != nil {
+		unlockUpload(upload)
+		return upload, result.Error
+	}
+
+	go func() {
+		defer unlockUpload(upload)
+		finishUpload(upload)
+	}()
+	return upload, nil
+}
+
+// finishUpload assembles a completed upload, stores it as a File and records
+// the outcome on the upload
+func finishUpload(upload models.Upload) {
+	file, err := storeCompletedUpload(upload)
+	if err == nil {
+		deleteUploadParts(upload)
+		result := database.DB.Model(&upload).UpdateColumns(map[string]interface{}{
+			"status":     models.UploadComplete,
+			"error":      "",
+			"file_id":    file.ID,
+			"mime_type":  file.MimeType,
+			"expires_at": time.Now().Add(uploadExpiry()),
+			"updated_at": time.Now(),
+		})
+		if result.Error != nil {
+			log.Printf("Failed to record completion of upload %d: %v", upload.ID, result.Error)
+		}
+		return
+	}
+
+	message := err.Error()
+	status := models.UploadFailed
+	var rejected *FileRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		if rejected.QuarantineID != 0 {
+			message += fmt.Sprintf(" (quarantined as %d)", rejected.QuarantineID)
+		}
+		if rejected.Err == ErrFileScanFailed || rejected.Err == ErrQuotaExceeded {
+			status = models.UploadReceiving
+		}
+	case err != ErrUploadChecksum && err != ErrBlobNotFound:
+		log.Printf("Failed to complete upload %d: %v", upload.ID, err)
+		status = models.UploadReceiving
+	}
+	if status == models.UploadFailed {
+		// The content is unusable as a whole, so it has to be uploaded again
+		deleteUploadParts(upload)
+	}
+	database.DB.Model(&upload).UpdateColumns(map[string]interface{}{"status": status, "error": message, "updated_at": time.Now()})
+}
+
+// storeCompletedUpload assembles the staged chunks of an upload, checks them
+// against its checksum and with StoreUploadFile, and creates the File
+func storeCompletedUpload(upload models.Upload) (models.File, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return models.File{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	for offset := int64(0); offset < upload.Offset; {
+		part, err := fileStorage.Get(uploadPartKey(upload.ID, offset))
+		if err != nil {
+			return models.File{}, err
+		}
+		n, err := io.Copy(io.MultiWriter(tmp, hash), part)
+		part.Close()
+		if err != nil {
+			return models.File{}, err
+		}
+		if n == 0 {
+			return models.File{}, ErrBlobNotFound
+		}
+		offset += n
+	}
+	if upload.SHA256 != "" && hex.EncodeToString(hash.Sum(nil)) != upload.SHA256 {
+		return models.File{}, ErrUploadChecksum
+	}
+
+	key, size, mimeType, err := StoreUploadFile(upload.UserID, tmp, upload.Name)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	// The folder may have been deleted while the upload was in progress
+	folderID := upload.FolderID
+	if folderID != nil {
+		var folder models.Folder
+		if result := database.DB.Where("id = ? AND user_id = ?", *folderID, upload.UserID).First(&folder); result.Error != nil {
+			folderID = nil
+		}
+	}
+	file := models.File{
+		UserID:      upload.UserID,
+		FolderID:    folderID,
+		Name:        upload.Name,
+		Path:        key,
+		MimeType:    mimeType,
+		Size:        size,
+		ContentHash: key,
+	}
+	if err := CreateBlobFile(&file); err != nil {
+		DeleteFileBlob(models.File{Path: key})
+		return models.File{}, err
+	}
+	return file, nil
+}
+
+// AbortUpload cancels an upload and discards the bytes received
+func AbortUpload(id uint, userID uint) error {
+	upload, err := lockUpload(id, userID, uploadWriteLease)
+	if err != nil {
+		return err
+	}
+	removeUpload(upload)
+	return nil
+}
+
+// StartUploadJanitor starts removing uploads that have received no chunk
+// for UPLOAD_EXPIRY_HOURS, and finished uploads once their status has been
+// kept as long. Calling it more than once has no effect.
+func StartUploadJanitor() {
+	janitorOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for {
+				removeExpiredUploads()
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+func removeExpiredUploads() {
+	var expired []models.Upload
+	database.DB.Where("expires_at <= ? AND (locked_until IS NULL OR locked_until < ?)", time.Now(), time.Now()).Find(&expired)
+	removed := 0
+	for _, upload := range expired {
+		if claimUpload(database.DB.Where("id = ?", upload.ID), uploadWriteLease, newLeaseToken()) {
+			removeUpload(upload)
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("Removed %d expired uploads", removed)
+	}
+}
+
+// removeUpload discards the staged chunks of an upload and the upload
+func removeUpload(upload models.Upload) {
+	deleteUploadParts(upload)
+	database.DB.Delete(&upload)
+}
+
+// deleteUploadParts removes the staged chunks of an upload, including one
+// stored at its offset by a write that failed before the offset moved
+func deleteUploadParts(upload models.Upload) {
+	for offset := int64(0); offset <= upload.Offset; {
+		key := uploadPartKey(upload.ID, offset)
+		size, err := fileStorage.Size(key)
+		if err != nil {
+			if err != ErrBlobNotFound {
+				log.Printf("Failed to find staged chunk %s: %v", key, err)
+			}
+			return
+		}
+		if err := fileStorage.Delete(key); err != nil {
+			log.Printf("Failed to remove staged chunk %s: %v", key, err)
+		}
+		if size <= 0 {
+			return
+		}
+		offset += size
+	}
+}
+
+// lockUpload takes the lease of a user's unexpired upload for a request or
+// worker, across all instances. It fails with ErrUploadBusy while another
+// one holds it. The upload is returned with the LockToken of the lease.
+func lockUpload(id uint, userID uint, lease time.Duration) (models.Upload, error) {
+	token := newLeaseToken()
+	if !claimUpload(database.DB.Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, time.Now()), lease, token) {
+		if _, err := GetUpload(id, userID); err != nil {
+			return models.Upload{}, err
+		}
+		return models.Upload{}, ErrUploadBusy
+	}
+	upload, err := GetUpload(id, userID)
+	upload.LockToken = token
+	return upload, err
+}
+
+// claimUpload takes the lease of the upload matched by query under token
+// unless another request or worker holds it
+func claimUpload(query *gorm.DB, lease time.Duration, token string) bool {
+	now := time.Now()
+	result := query.Model(&models.Upload{}).
+		Where("(locked_until IS NULL OR locked_until < ?)", now).
+		UpdateColumns(map[string]interface{}{"locked_until": now.Add(lease), "lock_token": token})
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// holdUploadLease renews the lease of a locked upload until the returned
+// function is called, so that a slow chunk does not outlive it. A lease
+// taken over by someone else is not renewed.
+func holdUploadLease(upload models.Upload, lease time.Duration) (release func()) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(uploadLeaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				result := database.DB.Model(&models.Upload{}).Where("id = ? AND lock_token = ?", upload.ID, upload.LockToken).
+					UpdateColumn("locked_until", time.Now().Add(lease))
+				if result.Error != nil {
+					log.Printf("Failed to renew the lease of upload %d: %v", upload.ID, result.Error)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// unlockUpload gives up the lease of a locked upload, unless it was taken
+// over since
+func unlockUpload(upload models.Upload) {
+	database.DB.Model(&models.Upload{}).Where("id = ? AND lock_token = ?", upload.ID, upload.LockToken).
+		UpdateColumns(map[string]interface{}{"locked_until": nil, "lock_token": ""})
+}
+
+// newLeaseToken returns a random token identifying the holder of a lease
+func newLeaseToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
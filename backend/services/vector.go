@@ -0,0 +1,200 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"backend/config"
+	"backend/database"
+)
+
+// VectorRecord is the embedding of a knowledge chunk
+type VectorRecord struct {
+	ChunkID uint
+	FileID  uint
+	Vector  []float32
+}
+
+// VectorMatch is a chunk found by a similarity search. Score is the cosine
+// similarity to the query vector, higher is closer.
+type VectorMatch struct {
+	ChunkID uint    `json:"chunk_id"`
+	FileID  uint    `json:"file_id"`
+	Score   float64 `json:"score"`
+}
+
+// VectorStore keeps chunk embeddings in named collections, one per knowledge
+// base. All vectors of a collection have the dimensions it was created with.
+type VectorStore interface {
+	// HasCollection reports whether a collection exists
+	HasCollection(name string) (bool, error)
+	// CreateCollection creates an empty collection, replacing any existing one
+	CreateCollection(name string, dimensions int) error
+	// DeleteCollection removes a collection and its vectors
+	DeleteCollection(name string) error
+	// Upsert adds or replaces vectors, keyed by chunk ID
+	Upsert(collection string, records []VectorRecord) error
+	// DeleteFile removes the vectors of a file's chunks
+	DeleteFile(collection string, fileID uint) error
+	// Search returns the limit chunks closest to a vector, best first. When
+	// fileIDs is not empty only chunks of those files are considered.
+	Search(collection string, vector []float32, limit int, fileIDs []uint) ([]VectorMatch, error)
+}
+
+// NewVectorStoreFromConfig creates the vector store selected by VECTOR_STORE:
+// memory (default) or pgvector. The memory store is for development only: it
+// is refused when APP_ENV is production or when several instances share the
+// database through BROADCAST_BACKEND, since each would hold its own vectors.
+func NewVectorStoreFromConfig() (VectorStore, error) {
+	switch store := config.Config("VECTOR_STORE"); store {
+	case "", "memory":
+		if config.Config("APP_ENV") == "production" {
+			return nil, fmt.Errorf("the memory vector store is for development only; set VECTOR_STORE=pgvector in production")
+		}
+		if backend := config.Config("BROADCAST_BACKEND"); backend != "" && backend != "memory" {
+			return nil, fmt.Errorf("the memory vector store cannot be shared by instances using the %s broadcast backend; set VECTOR_STORE=pgvector", backend)
+		}
+		return NewMemoryVectorStore(), nil
+	case "pgvector":
+		return NewPgVectorStore(database.DB)
+	default:
+		return nil, fmt.Errorf("unknown VECTOR_STORE %q", store)
+	}
+}
+
+// MemoryVectorStore keeps vectors in process memory and searches them by
+// brute force. Its contents are lost on restart, so collections are rebuilt
+// from the stored chunks when the server starts, embedding every chunk again.
+// It is meant for development and tests.
+type MemoryVectorStore struct {
+	mu          sync.RWMutex
+	collections map[string]*memoryCollection
+}
+
+type memoryCollection struct {
+	dimensions int
+	records    map[uint]VectorRecord // chunk ID -> record
+}
+
+// NewMemoryVectorStore creates an empty MemoryVectorStore
+func NewMemoryVectorStore() *MemoryVectorStore {
+	return &MemoryVectorStore{collections: make(map[string]*memoryCollection)}
+}
+
+// HasCollection implements VectorStore
+func (s *MemoryVectorStore) HasCollection(name string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.collections[name]
+	return ok, nil
+}
+
+// CreateCollection implements VectorStore
+func (s *MemoryVectorStore) CreateCollection(name string, dimensions int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.collections[name] = &memoryCollection{dimensions: dimensions, records: make(map[uint]VectorRecord)}
+	return nil
+}
+
+// DeleteCollection implements VectorStore
+func (s *MemoryVectorStore) DeleteCollection(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.collections, name)
+	return nil
+}
+
+// Upsert implements VectorStore
+func (s *MemoryVectorStore) Upsert(collection string, records []VectorRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.collections[collection]
+	if !ok {
+		return fmt.Errorf("vector collection %q does not exist", collection)
+	}
+	for _, r := range records {
+		if len(r.Vector) != c.dimensions {
+			return fmt.Errorf("vector has %d dimensions, collection %q expects %d", len(r.Vector), collection, c.dimensions)
+		}
+		c.records[r.ChunkID] = r
+	}
+	return nil
+}
+
+// DeleteFile implements VectorStore
+func (s *MemoryVectorStore) DeleteFile(collection string, fileID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.collections[collection]
+	if !ok {
+		return nil
+	}
+	for id, r := range c.records {
+		if r.FileID == fileID {
+			delete(c.records, id)
+		}
+	}
+	return nil
+}
+
+// Search implements VectorStore
+func (s *MemoryVectorStore) Search(collection string, vector []float32, limit int, fileIDs []uint) ([]VectorMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.collections[collection]
+	if !ok {
+		return nil, fmt.Errorf("vector collection %q does not exist", collection)
+	}
+	if len(vector) != c.dimensions {
+		return nil, fmt.Errorf("query vector has %d dimensions, collection %q expects %d", len(vector), collection, c.dimensions)
+	}
+
+	var files map[uint]bool
+	if len(fileIDs) > 0 {
+		files = make(map[uint]bool, len(fileIDs))
+		for _, id := range fileIDs {
+			files[id] = true
+		}
+	}
+
+	matches := make([]VectorMatch, 0, len(c.records))
+	for _, r := range c.records {
+		if files != nil && !files[r.FileID] {
+			continue
+		}
+		matches = append(matches, VectorMatch{ChunkID: r.ChunkID, FileID: r.FileID, Score: CosineSimilarity(vector, r.Vector)})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ChunkID < matches[j].ChunkID
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// CosineSimilarity returns the cosine of the angle between two vectors of the same size
+func CosineSimilarity(a []float32, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
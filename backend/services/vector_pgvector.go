@@ -0,0 +1,144 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// pgvectorMaxIndexedDimensions is the largest vector size an HNSW index supports
+const pgvectorMaxIndexedDimensions = 2000
+
+// PgVectorStore keeps each collection in its own table of the application
+// database, using the pgvector extension with an HNSW cosine index
+type PgVectorStore struct {
+	db *gorm.DB
+}
+
+// NewPgVectorStore enables the pgvector extension and returns a store using db
+func NewPgVectorStore(db *gorm.DB) (*PgVectorStore, error) {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+		return nil, fmt.Errorf("failed to enable the pgvector extension: %w", err)
+	}
+	return &PgVectorStore{db: db}, nil
+}
+
+// pgvectorTable maps a collection name, which is derived from user input, to
+// a safe table name
+func pgvectorTable(collection string) string {
+	sum := sha1.Sum([]byte(collection))
+	return "knowledge_vectors_" + hex.EncodeToString(sum[:8])
+}
+
+// pgvectorLiteral formats a vector in pgvector's text representation
+func pgvectorLiteral(vector []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range vector {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// HasCollection implements VectorStore
+func (s *PgVectorStore) HasCollection(name string) (bool, error) {
+	var exists bool
+	err := s.db.Raw("SELECT to_regclass(?) IS NOT NULL", pgvectorTable(name)).Scan(&exists).Error
+	return exists, err
+}
+
+// CreateCollection implements VectorStore
+func (s *PgVectorStore) CreateCollection(name string, dimensions int) error {
+	table := pgvectorTable(name)
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table)).Error; err != nil {
+			return err
+		}
+		create := fmt.Sprintf(`CREATE TABLE %s (
+			chunk_id BIGINT PRIMARY KEY,
+			file_id BIGINT NOT NULL,
+			embedding vector(%d) NOT NULL
+		)`, table, dimensions)
+		if err := tx.Exec(create).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(fmt.Sprintf("CREATE INDEX %s_file_idx ON %s (file_id)", table, table)).Error; err != nil {
+			return err
+		}
+		if dimensions > pgvectorMaxIndexedDimensions {
+			return nil // Searched sequentially
+		}
+		return tx.Exec(fmt.Sprintf("CREATE INDEX %s_embedding_idx ON %s USING hnsw (embedding vector_cosine_ops)", table, table)).Error
+	})
+}
+
+// DeleteCollection implements VectorStore
+func (s *PgVectorStore) DeleteCollection(name string) error {
+	return s.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", pgvectorTable(name))).Error
+}
+
+// Upsert implements VectorStore
+func (s *PgVectorStore) Upsert(collection string, records []VectorRecord) error {
+	table := pgvectorTable(collection)
+	const batchSize = 200
+	for start := 0; start < len(records); start += batchSize {
+		end := start + batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, 3*(end-start))
+		for _, r := range records[start:end] {
+			placeholders = append(placeholders, "(?, ?, ?::vector)")
+			args = append(args, r.ChunkID, r.FileID, pgvectorLiteral(r.Vector))
+		}
+		query := fmt.Sprintf(`INSERT INTO %s (chunk_id, file_id, embedding) VALUES %s
+			ON CONFLICT (chunk_id) DO UPDATE SET file_id = EXCLUDED.file_id, embedding = EXCLUDED.embedding`,
+			table, strings.Join(placeholders, ", "))
+		if err := s.db.Exec(query, args...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFile implements VectorStore
+func (s *PgVectorStore) DeleteFile(collection string, fileID uint) error {
+	exists, err := s.HasCollection(collection)
+	if err != nil || !exists {
+		return err
+	}
+	return s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE file_id = ?", pgvectorTable(collection)), fileID).Error
+}
+
+// Search implements VectorStore
+func (s *PgVectorStore) Search(collection string, vector []float32, limit int, fileIDs []uint) ([]VectorMatch, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	literal := pgvectorLiteral(vector)
+	args := []interface{}{literal}
+	where := ""
+	if len(fileIDs) > 0 {
+		where = "WHERE file_id IN ?"
+		args = append(args, fileIDs)
+	}
+	args = append(args, literal, limit)
+	query := fmt.Sprintf(`SELECT chunk_id, file_id, 1 - (embedding <=> ?::vector) AS score
+		FROM %s %s ORDER BY embedding <=> ?::vector LIMIT ?`, pgvectorTable(collection), where)
+
+	var matches []VectorMatch
+	if err := s.db.Raw(query, args...).Scan(&matches).Error; err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
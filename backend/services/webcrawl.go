@@ -0,0 +1,545 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/database"
+	"backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Web crawl limits. A source's own MaxDepth and MaxPages are capped by these.
+const (
+	DefaultCrawlMaxPages = 50
+	MaxCrawlPages        = 500
+	MaxCrawlDepth        = 5
+	maxWebPageSize       = 5 << 20 // 5 MB
+	staleCrawlAfter      = time.Hour
+)
+
+// ErrCrawlInProgress is returned when a web source is already being crawled
+var ErrCrawlInProgress = errors.New("web source is already being crawled")
+
+// webFetchTimeout bounds the download of one web page
+const webFetchTimeout = 30 * time.Second
+
+var (
+	htmlLinks = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	htmlTitle = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title>`)
+
+	crawlerOnce sync.Once
+)
+
+// webPage is a fetched page reduced to its readable text
+type webPage struct {
+	URL   string
+	Title string
+	Text  string
+	Links []string
+}
+
+// StartWebCrawler starts the scheduler that re-crawls web sources whose
+// RecrawlInterval has elapsed. Calling it more than once has no effect.
+func StartWebCrawler() {
+	crawlerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for {
+				crawlDueWebSources()
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+func crawlDueWebSources() {
+	var due []models.KnowledgeWebSource
+	database.DB.Where("recrawl_interval > 0 AND next_crawl_at <= ?", time.Now()).Find(&due)
+	for _, source := range due {
+		if err := CrawlWebSource(source.ID); err != nil && err != ErrCrawlInProgress {
+			log.Printf("Scheduled crawl of web source %d failed: %v", source.ID, err)
+		}
+	}
+}
+
+// CrawlWebSource fetches the pages of a web source, stores them as file
+// snapshots and indexes the new and changed ones into its knowledge base.
+// Pages that are no longer found are removed. Only one crawl of a source
+// runs at a time, across all instances.
+func CrawlWebSource(sourceID uint) error {
+	// Claim the source; a crawl left running by a crashed instance goes stale
+	claim := database.DB.Model(&models.KnowledgeWebSource{}).
+		Where("id = ? AND (status <> ? OR updated_at < ?)", sourceID, models.CrawlRunning, time.Now().Add(-staleCrawlAfter)).
+		Updates(map[string]interface{}{"status": models.CrawlRunning, "error": ""})
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return ErrCrawlInProgress
+	}
+
+	var source models.KnowledgeWebSource
+	if result := database.DB.First(&source, sourceID); result.Error != nil {
+		return result.Error
+	}
+
+	count, err := crawlWebSource(source)
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":          models.CrawlComplete,
+		"last_crawled_at": &now,
+		"next_crawl_at":   nil,
+	}
+	if source.RecrawlInterval > 0 {
+		next := now.Add(time.Duration(source.RecrawlInterval) * time.Minute)
+		updates["next_crawl_at"] = &next
+	}
+	if err != nil {
+		updates["status"] = models.CrawlFailed
+		updates["error"] = err.Error()
+	} else {
+		updates["page_count"] = count
+	}
+	database.DB.Model(&source).Updates(updates)
+	return err
+}
+
+func crawlWebSource(source models.KnowledgeWebSource) (int, error) {
+	var knowledge models.Knowledge
+	if result := database.DB.First(&knowledge, source.KnowledgeID); result.Error != nil {
+		return 0, result.Error
+	}
+
+	pages, err := collectWebPages(source)
+	if err != nil {
+		return 0, err
+	}
+
+	var existing []models.File
+	database.DB.Where("web_source_id = ?", source.ID).Find(&existing)
+	byURL := make(map[string]models.File, len(existing))
+	for _, f := range existing {
+		byURL[f.SourceURL] = f
+	}
+
+	seenHashes := make(map[string]bool)
+	kept := make(map[uint]bool)
+	for _, page := range pages {
+		content := page.Text
+		sum := sha256.Sum256([]byte(content))
+		hash := hex.EncodeToString(sum[:])
+		if seenHashes[hash] {
+			continue // The same page under another URL
+		}
+		seenHashes[hash] = true
+
+		file, err := storeWebPage(knowledge, source, byURL[page.URL], page, hash)
+		if err != nil {
+			log.Printf("Failed to store %s for web source %d: %v", page.URL, source.ID, err)
+			if f, ok := byURL[page.URL]; ok {
+				kept[f.ID] = true // Keep the previous snapshot
+			}
+			continue
+		}
+		kept[file.ID] = true
+	}
+
+	for _, f := range existing {
+		if !kept[f.ID] {
+			removeWebSnapshot(f)
+		}
+	}
+	return len(kept), nil
+}
+
+// storeWebPage saves a page as a snapshot file, creating it on the first
+// crawl and updating it when the text changed, and (re)indexes it
+func storeWebPage(knowledge models.Knowledge, source models.KnowledgeWebSource, file models.File, page webPage, hash string) (models.File, error) {
+	if file.ID != 0 && file.ContentHash == hash {
+		return file, nil
+	}
+
+	name := page.Title
+	if name == "" {
+		name = page.URL
+	}
+
+	// Snapshots go through the same policy, quota and scan as uploads
+	path, size, mimeType, err := StoreUpload(knowledge.UserID, strings.NewReader(page.Text), name)
+	if err != nil {
+		return file, err
+	}
+
+	if file.ID == 0 {
+		sourceID := source.ID
+		file = models.File{
+			UserID:      knowledge.UserID,
+			Name:        name,
+			Path:        path,
+			MimeType:    mimeType,
+			Size:        size,
+			ContentHash: hash,
+			SourceURL:   page.URL,
+			WebSourceID: &sourceID,
+		}
+		if err := CreateBlobFile(&file); err != nil {
+			return file, err
+		}
+		if err := AddToKnowledge(knowledge.ID, file.ID); err != nil && err != ErrFileInKnowledge {
+			return file, err
+		}
+		return file, nil
+	}
+
+	previous := file
+	err = referBlob(path, func(tx *gorm.DB) error {
+		if err := reserveStorage(tx, file.UserID, size-previous.Size); err != nil {
+			return err
+		}
+		return tx.Model(&file).Updates(map[string]interface{}{
+			"name":         name,
+			"path":         path,
+			"mime_type":    mimeType,
+			"size":         size,
+			"content_hash": hash,
+		}).Error
+	})
+	if err != nil {
+		return file, err
+	}
+	if previous.Path != path {
+		DeleteFileArtifacts(file.ID)
+		DeleteFileBlob(previous)
+	}
+	if err := EnqueueIngestion(knowledge.ID, file.ID); err == ErrFileNotInKnowledge {
+		return file, AddToKnowledge(knowledge.ID, file.ID)
+	} else if err != nil {
+		return file, err
+	}
+	return file, nil
+}
+
+// removeWebSnapshot deletes the snapshot of a page that is no longer part of
+// its web source. Its content is purged with other deleted files.
+func removeWebSnapshot(file models.File) {
+	if err := RemoveFileFromKnowledges(file.ID); err != nil {
+		log.Printf("Failed to remove snapshot %d from its knowledge bases: %v", file.ID, err)
+		return
+	}
+	database.DB.Delete(&file)
+}
+
+// DeleteWebSource removes a web source and the snapshots of its pages
+func DeleteWebSource(source models.KnowledgeWebSource) error {
+	var files []models.File
+	database.DB.Where("web_source_id = ?", source.ID).Find(&files)
+	for _, f := range files {
+		removeWebSnapshot(f)
+	}
+	return database.DB.Delete(&source).Error
+}
+
+// collectWebPages fetches the pages of a web source according to its mode
+func collectWebPages(source models.KnowledgeWebSource) ([]webPage, error) {
+	maxPages := source.MaxPages
+	if maxPages <= 0 {
+		maxPages = DefaultCrawlMaxPages
+	}
+	if maxPages > MaxCrawlPages {
+		maxPages = MaxCrawlPages
+	}
+
+	start, err := url.Parse(source.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	domains := crawlDomains(source.AllowedDomains, start.Hostname())
+
+	switch source.Mode {
+	case models.WebSourceSitemap:
+		urls, err := sitemapURLs(source.URL, domains, maxPages, 0)
+		if err != nil {
+			return nil, err
+		}
+		pages := make([]webPage, 0, len(urls))
+		for _, u := range urls {
+			page, err := fetchWebPage(u, domains, maxWebPageSize)
+			if err != nil {
+				log.Printf("Skipping %s: %v", u, err)
+				continue
+			}
+			pages = append(pages, page)
+		}
+		return pages, nil
+
+	case models.WebSourceCrawl:
+		depth := source.MaxDepth
+		if depth > MaxCrawlDepth {
+			depth = MaxCrawlDepth
+		}
+		return crawlLinks(source.URL, depth, maxPages, domains)
+
+	default:
+		page, err := fetchWebPage(source.URL, domains, maxWebPageSize)
+		if err != nil {
+			return nil, err
+		}
+		return []webPage{page}, nil
+	}
+}
+
+// crawlLinks fetches pages breadth-first from start, following links up to
+// depth hops away that stay within the allowed domains
+func crawlLinks(start string, depth int, maxPages int, domains []string) ([]webPage, error) {
+	type queued struct {
+		url   string
+		depth int
+	}
+	queue := []queued{{url: normalizePageURL(start), depth: 0}}
+	visited := map[string]bool{queue[0].url: true}
+
+	var pages []webPage
+	for len(queue) > 0 && len(pages) < maxPages {
+		next := queue[0]
+		queue = queue[1:]
+
+		page, err := fetchWebPage(next.url, domains, maxWebPageSize)
+		if err != nil {
+			if next.depth == 0 {
+				return nil, err // The start page itself must be reachable
+			}
+			log.Printf("Skipping %s: %v", next.url, err)
+			continue
+		}
+		pages = append(pages, page)
+
+		if next.depth >= depth {
+			continue
+		}
+		for _, link := range page.Links {
+			if !visited[link] && domainAllowed(link, domains) {
+				visited[link] = true
+				queue = append(queue, queued{url: link, depth: next.depth + 1})
+			}
+		}
+	}
+	return pages, nil
+}
+
+// fetchWebPage downloads an HTML or plain text page and reduces it to its
+// readable text. Links are only collected from HTML pages. Redirects must
+// stay within domains unless it is nil, and pages over maxSize bytes fail.
+func fetchWebPage(pageURL string, domains []string, maxSize int64) (webPage, error) {
+	body, contentType, err := fetchWeb(pageURL, domains, maxSize)
+	if err != nil {
+		return webPage{}, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	page := webPage{URL: pageURL}
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" || mediaType == "":
+		doc := string(body)
+		if m := htmlTitle.FindStringSubmatch(doc); m != nil {
+			page.Title = strings.TrimSpace(html.UnescapeString(normalizeWhitespace(m[1])))
+		}
+		page.Text = normalizeWhitespace(HTMLToText(doc))
+		page.Links = pageLinks(pageURL, doc)
+	case strings.HasPrefix(mediaType, "text/"):
+		text, err := decodeText(body)
+		if err != nil {
+			return webPage{}, err
+		}
+		page.Text = normalizeWhitespace(text)
+	default:
+		return webPage{}, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	if page.Text == "" {
+		return webPage{}, fmt.Errorf("page has no text")
+	}
+	return page, nil
+}
+
+// webCrawlAllowPrivate reports whether crawls may reach addresses that are
+// not publicly routable, for intranet sites. WEB_CRAWL_ALLOW_PRIVATE turns it
+// on; web search results are never fetched from such addresses.
+func webCrawlAllowPrivate() bool {
+	return config.Config("WEB_CRAWL_ALLOW_PRIVATE") == "true"
+}
+
+// fetchWeb GETs a URL and returns its body, failing when it is larger than
+// maxSize bytes. Redirects must stay within domains unless it is nil. Only
+// publicly routable addresses are reached, except by crawls, which pass
+// domains, when webCrawlAllowPrivate is on.
+func fetchWeb(pageURL string, domains []string, maxSize int64) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	userAgent := config.Config("WEB_USER_AGENT")
+	if userAgent == "" {
+		userAgent = "webui-backend-crawler/1.0"
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	var allowRedirect func(*url.URL) bool
+	if domains != nil {
+		allowRedirect = func(u *url.URL) bool { return domainAllowed(u.String(), domains) }
+	}
+	client := newPublicHTTPClient(webFetchTimeout, allowRedirect)
+	if domains != nil && webCrawlAllowPrivate() {
+		client.Transport = nil // Any address, redirects are still checked
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("non-200 status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, "", fmt.Errorf("page is larger than %d bytes", maxSize)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// pageLinks returns the absolute http(s) URLs linked from an HTML page
+func pageLinks(base string, doc string) []string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil
+	}
+
+	var links []string
+	for _, m := range htmlLinks.FindAllStringSubmatch(doc, -1) {
+		href := strings.TrimSpace(html.UnescapeString(m[1] + m[2] + m[3]))
+		ref, err := url.Parse(href)
+		if err != nil {
+			continue
+		}
+		link := baseURL.ResolveReference(ref)
+		if link.Scheme != "http" && link.Scheme != "https" {
+			continue
+		}
+		links = append(links, normalizePageURL(link.String()))
+	}
+	return links
+}
+
+// normalizePageURL drops the fragment so that anchors within a page are not
+// fetched as separate pages
+func normalizePageURL(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return pageURL
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// sitemapURLs lists the page URLs of a sitemap that are within domains,
+// following sitemap indexes within domains one level deep
+func sitemapURLs(sitemapURL string, domains []string, limit int, level int) ([]string, error) {
+	body, _, err := fetchWeb(sitemapURL, domains, maxWebPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		XMLName xml.Name
+		URLs    []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid sitemap: %w", err)
+	}
+
+	var urls []string
+	for _, u := range doc.URLs {
+		if len(urls) >= limit {
+			return urls, nil
+		}
+		if loc := strings.TrimSpace(u.Loc); loc != "" && domainAllowed(loc, domains) {
+			urls = append(urls, loc)
+		}
+	}
+	if level > 0 {
+		return urls, nil
+	}
+	for _, s := range doc.Sitemaps {
+		if len(urls) >= limit {
+			break
+		}
+		loc := strings.TrimSpace(s.Loc)
+		if !domainAllowed(loc, domains) {
+			log.Printf("Skipping sitemap %s: outside the allowed domains", loc)
+			continue
+		}
+		nested, err := sitemapURLs(loc, domains, limit-len(urls), level+1)
+		if err != nil {
+			log.Printf("Skipping sitemap %s: %v", s.Loc, err)
+			continue
+		}
+		urls = append(urls, nested...)
+	}
+	return urls, nil
+}
+
+// crawlDomains parses a comma-separated domain list, defaulting to host
+func crawlDomains(allowed string, host string) []string {
+	var domains []string
+	for _, d := range strings.Split(allowed, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	if len(domains) == 0 && host != "" {
+		domains = []string{strings.ToLower(host)}
+	}
+	return domains
+}
+
+// domainAllowed reports whether a URL's host is one of the domains or a
+// subdomain of one
+func domainAllowed(pageURL string, domains []string) bool {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
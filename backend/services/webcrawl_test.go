@@ -0,0 +1,200 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// allowTestPrivateAddresses lets a test reach its httptest servers, which
+// listen on loopback
+func allowTestPrivateAddresses(t *testing.T) {
+	t.Helper()
+	allowPrivateAddresses = true
+	t.Cleanup(func() { allowPrivateAddresses = false })
+}
+
+// localhostURL returns a server URL with the host localhost instead of
+// 127.0.0.1, to stand for another domain
+func localhostURL(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "http://localhost:" + u.Port()
+}
+
+func TestPublicIP(t *testing.T) {
+	for address, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := publicIP(net.ParseIP(address)); got != want {
+			t.Errorf("publicIP(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestFetchWebRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "internal")
+	}))
+	defer server.Close()
+
+	if _, _, err := fetchWeb(server.URL, nil, maxWebPageSize); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("fetchWeb(%s) error = %v, want ErrPrivateAddress", server.URL, err)
+	}
+	// A host name that resolves to loopback is refused the same way
+	if _, _, err := fetchWeb(localhostURL(t, server), nil, maxWebPageSize); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("fetchWeb(localhost) error = %v, want ErrPrivateAddress", err)
+	}
+}
+
+func TestCrawlReachesPrivateAddressesWhenAllowed(t *testing.T) {
+	t.Setenv("WEB_CRAWL_ALLOW_PRIVATE", "true")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<title>Intranet</title><a href="/wiki">Wiki</a>`)
+			return
+		}
+		fmt.Fprint(w, `<title>Wiki</title><p>Internal docs</p>`)
+	}))
+	defer server.Close()
+
+	pages, err := crawlLinks(server.URL+"/", 1, 10, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("crawlLinks: %v", err)
+	}
+	if len(pages) != 2 || pages[0].Title != "Intranet" || pages[1].Title != "Wiki" {
+		t.Errorf("crawled %+v, want Intranet and Wiki", pages)
+	}
+
+	// Web search results are still only fetched from public addresses
+	if _, err := fetchWebPage(server.URL, nil, maxWebResultSize); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("fetchWebPage without domains error = %v, want ErrPrivateAddress", err)
+	}
+}
+
+func TestFetchWebRedirectsStayWithinDomains(t *testing.T) {
+	allowTestPrivateAddresses(t)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "elsewhere")
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(w, r, localhostURL(t, other)+"/", http.StatusFound)
+		case "/here":
+			http.Redirect(w, r, "/page", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "page")
+		}
+	}))
+	defer server.Close()
+	domains := []string{"127.0.0.1"}
+
+	if _, _, err := fetchWeb(server.URL+"/away", domains, maxWebPageSize); !errors.Is(err, ErrRedirectNotAllowed) {
+		t.Errorf("redirect to another domain: error = %v, want ErrRedirectNotAllowed", err)
+	}
+	body, _, err := fetchWeb(server.URL+"/here", domains, maxWebPageSize)
+	if err != nil || string(body) != "page" {
+		t.Errorf("redirect within the domain = %q, %v, want page", body, err)
+	}
+}
+
+func TestSitemapURLsStayWithinDomains(t *testing.T) {
+	allowTestPrivateAddresses(t)
+	var mu sync.Mutex
+	var fetched []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, "other"+r.URL.Path)
+		mu.Unlock()
+		fmt.Fprint(w, `<urlset><url><loc>http://127.0.0.1/foreign</loc></url></urlset>`)
+	}))
+	defer other.Close()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%s/pages.xml</loc></sitemap><sitemap><loc>%s/pages.xml</loc></sitemap></sitemapindex>`,
+				server.URL, localhostURL(t, other))
+		case "/pages.xml":
+			fmt.Fprintf(w, `<urlset><url><loc>%s/a</loc></url><url><loc>%s/b</loc></url></urlset>`,
+				server.URL, localhostURL(t, other))
+		}
+	}))
+	defer server.Close()
+
+	urls, err := sitemapURLs(server.URL+"/sitemap.xml", []string{"127.0.0.1"}, 10, 0)
+	if err != nil {
+		t.Fatalf("sitemapURLs: %v", err)
+	}
+	if len(urls) != 1 || urls[0] != server.URL+"/a" {
+		t.Errorf("sitemapURLs = %v, want only %s/a", urls, server.URL)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(fetched)
+	if strings.Join(fetched, ",") != "/pages.xml,/sitemap.xml" {
+		t.Errorf("fetched %v, want only the sitemaps within the allowed domain", fetched)
+	}
+}
+
+func TestCrawlLinksFollowsLinksWithinDomains(t *testing.T) {
+	allowTestPrivateAddresses(t)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("crawled %s outside the allowed domains", r.URL)
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<title>Home</title><a href="/docs#intro">Docs</a><a href="%s/">Away</a>`, localhostURL(t, other))
+		case "/docs":
+			fmt.Fprint(w, `<title>Docs</title><p>Documentation</p><a href="/deeper">Deeper</a>`)
+		default:
+			fmt.Fprint(w, `<p>Too deep</p>`)
+		}
+	}))
+	defer server.Close()
+
+	pages, err := crawlLinks(server.URL+"/", 1, 10, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("crawlLinks: %v", err)
+	}
+	var titles []string
+	for _, p := range pages {
+		titles = append(titles, p.Title)
+	}
+	if strings.Join(titles, ",") != "Home,Docs" {
+		t.Errorf("crawled pages %v, want Home and Docs", titles)
+	}
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+)
+
+// Web search defaults, used when WEB_SEARCH_QUERIES and WEB_SEARCH_RESULTS
+// are not set
+const (
+	DefaultWebSearchQueries = 3
+	DefaultWebSearchResults = 3
+)
+
+// Limits on each search result page, which is fetched, chunked and embedded
+// while the user waits for an answer
+const (
+	maxWebResultSize   = 1 << 20 // 1 MB
+	maxWebResultChunks = 20
+)
+
+// SearchResult is a web page returned by a search provider
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+}
+
+// SearchProvider runs web searches
+type SearchProvider interface {
+	Search(query string, count int) ([]SearchResult, error)
+}
+
+var (
+	searchProviderOnce sync.Once
+	searchProvider     SearchProvider
+
+	// searchClient calls the configured search service, which unlike the
+	// pages it returns may run on the local network
+	searchClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// WebSearchProvider returns the search provider selected by
+// WEB_SEARCH_ENGINE, or nil when web search is disabled
+func WebSearchProvider() SearchProvider {
+	searchProviderOnce.Do(func() {
+		switch config.Config("WEB_SEARCH_ENGINE") {
+		case "searxng":
+			searchProvider = &SearxNGSearchProvider{URL: config.Config("WEB_SEARCH_URL")}
+		case "brave":
+			searchProvider = &BraveSearchProvider{APIKey: config.Config("WEB_SEARCH_API_KEY")}
+		case "stub":
+			var results []SearchResult
+			if raw := config.Config("WEB_SEARCH_STUB_RESULTS"); raw != "" {
+				if err := json.Unmarshal([]byte(raw), &results); err != nil {
+					log.Printf("Invalid WEB_SEARCH_STUB_RESULTS: %v", err)
+				}
+			}
+			searchProvider = &StubSearchProvider{Results: results}
+		}
+	})
+	return searchProvider
+}
+
+// WebSearch answers the last user message with the web: a chat model writes
+// search queries, the top results are fetched, chunked and ranked against
+// the message, and the best chunks are returned as citations with their URL.
+// Pages that cannot be fetched fall back to the provider's snippet.
+func WebSearch(model string, messages []models.Message) ([]models.Citation, error) {
+	provider := WebSearchProvider()
+	if provider == nil {
+		return nil, fmt.Errorf("web search is not configured")
+	}
+	question := lastUserContent(messages)
+	if strings.TrimSpace(question) == "" {
+		return nil, nil
+	}
+
+	queryModel := config.Config("WEB_SEARCH_QUERY_MODEL")
+	if queryModel == "" {
+		queryModel = model
+	}
+	queries, err := GenerateSearchQueries(queryModel, messages)
+	if err != nil {
+		log.Printf("Search query generation failed, searching for the message itself: %v", err)
+		queries = []string{question}
+	}
+
+	count, _ := strconv.Atoi(config.Config("WEB_SEARCH_RESULTS"))
+	if count <= 0 {
+		count = DefaultWebSearchResults
+	}
+	var results []SearchResult
+	seen := make(map[string]bool)
+	for _, q := range queries {
+		found, err := provider.Search(q, count)
+		if err != nil {
+			log.Printf("Web search for %q failed: %v", q, err)
+			continue
+		}
+		for _, r := range found {
+			if r.URL != "" && !seen[r.URL] {
+				seen[r.URL] = true
+				results = append(results, r)
+			}
+		}
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	citations := webResultCitations(results)
+	return rankWebCitations(question, citations, RAGTopK())
+}
+
+// GenerateSearchQueries asks a chat model for web search queries that would
+// answer the last user message, given the conversation so far
+func GenerateSearchQueries(model string, messages []models.Message) ([]string, error) {
+	limit, _ := strconv.Atoi(config.Config("WEB_SEARCH_QUERIES"))
+	if limit <= 0 {
+		limit = DefaultWebSearchQueries
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Write up to %d web search queries that would find the information needed to answer the last user message.\n", limit)
+	prompt.WriteString("Reply with only a JSON array of strings.\n\nConversation:\n")
+	for _, m := range messages {
+		fmt.Fprintf(&prompt, "%s: %s\n", m.Role, m.Content)
+	}
+
+	reply, err := CompleteText(model, []models.Message{{Role: "user", Content: prompt.String()}})
+	if err != nil {
+		return nil, err
+	}
+
+	// Models like to wrap the array in prose or code fences
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("model did not return a JSON array")
+	}
+	var queries []string
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &queries); err != nil {
+		return nil, fmt.Errorf("failed to parse search queries: %w", err)
+	}
+
+	cleaned := make([]string, 0, len(queries))
+	for _, q := range queries {
+		if q = strings.TrimSpace(q); q != "" && len(cleaned) < limit {
+			cleaned = append(cleaned, q)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil, fmt.Errorf("model returned no search queries")
+	}
+	return cleaned, nil
+}
+
+// webResultCitations fetches the search results concurrently and chunks
+// their text into unranked citations. Pages over maxWebResultSize fall back
+// to their snippet and only their first maxWebResultChunks chunks are kept.
+func webResultCitations(results []SearchResult) []models.Citation {
+	texts := make([]string, len(results))
+	titles := make([]string, len(results))
+	var wg sync.WaitGroup
+	for i, r := range results {
+		wg.Add(1)
+		go func(i int, r SearchResult) {
+			defer wg.Done()
+			titles[i] = r.Title
+			page, err := fetchWebPage(r.URL, nil, maxWebResultSize)
+			if err != nil {
+				texts[i] = r.Snippet
+				return
+			}
+			texts[i] = page.Text
+			if titles[i] == "" {
+				titles[i] = page.Title
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	size, overlap := ChunkSettings(0, nil)
+	var citations []models.Citation
+	for i, r := range results {
+		title := titles[i]
+		if title == "" {
+			title = r.URL
+		}
+		chunks := ChunkText(texts[i], size, overlap)
+		if len(chunks) > maxWebResultChunks {
+			chunks = chunks[:maxWebResultChunks]
+		}
+		for _, c := range chunks {
+			citations = append(citations, models.Citation{
+				FileName:    title,
+				URL:         r.URL,
+				ChunkIndex:  c.Index,
+				StartOffset: c.Start,
+				EndOffset:   c.End,
+				Content:     c.Content,
+			})
+		}
+	}
+	return citations
+}
+
+// rankWebCitations scores web chunks by cosine similarity to the question
+// with the knowledge embedder, or by word overlap without one, and keeps the
+// best topK
+func rankWebCitations(question string, citations []models.Citation, topK int) ([]models.Citation, error) {
+	if len(citations) == 0 {
+		return nil, nil
+	}
+
+	if embedder := KnowledgeEmbedder(); embedder != nil {
+		texts := make([]string, 0, len(citations)+1)
+		texts = append(texts, question)
+		for _, c := range citations {
+			texts = append(texts, c.Content)
+		}
+		vectors, err := EmbedBatched(embedder, texts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range citations {
+			citations[i].VectorScore = CosineSimilarity(vectors[0], vectors[i+1])
+			citations[i].Score = citations[i].VectorScore
+		}
+	} else {
+		words := make(map[string]bool)
+		for _, w := range Tokenize(question) {
+			words[w] = true
+		}
+		for i, c := range citations {
+			tokens := Tokenize(c.Content)
+			matches := 0
+			for _, t := range tokens {
+				if words[t] {
+					matches++
+				}
+			}
+			if len(tokens) > 0 {
+				citations[i].KeywordScore = float64(matches) / float64(len(tokens))
+				citations[i].Score = citations[i].KeywordScore
+			}
+		}
+	}
+
+	sort.SliceStable(citations, func(i, j int) bool { return citations[i].Score > citations[j].Score })
+	if len(citations) > topK {
+		citations = citations[:topK]
+	}
+	return citations, nil
+}
+
+// MergeCitations concatenates citation lists and numbers their sources in order
+func MergeCitations(lists ...[]models.Citation) []models.Citation {
+	var merged []models.Citation
+	for _, list := range lists {
+		for _, c := range list {
+			c.Source = len(merged) + 1
+			merged = append(merged, c)
+		}
+	}
+	return merged
+}
+
+func lastUserContent(messages []models.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// SearxNGSearchProvider searches with the JSON API of a SearxNG instance
+type SearxNGSearchProvider struct {
+	URL string
+}
+
+// Search implements SearchProvider
+func (p *SearxNGSearchProvider) Search(query string, count int) ([]SearchResult, error) {
+	if p.URL == "" {
+		return nil, fmt.Errorf("WEB_SEARCH_URL is not set")
+	}
+
+	var response struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	searchURL := fmt.Sprintf("%s/search?format=json&q=%s", strings.TrimSuffix(p.URL, "/"), url.QueryEscape(query))
+	if err := getJSON(searchURL, nil, &response); err != nil {
+		return nil, fmt.Errorf("SearxNG search failed: %w", err)
+	}
+
+	var results []SearchResult
+	for _, r := range response.Results {
+		if len(results) == count {
+			break
+		}
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+	}
+	return results, nil
+}
+
+// BraveSearchProvider searches with the Brave Search API
+type BraveSearchProvider struct {
+	APIKey string
+}
+
+// Search implements SearchProvider
+func (p *BraveSearchProvider) Search(query string, count int) ([]SearchResult, error) {
+	if p.APIKey == "" {
+		return nil, fmt.Errorf("WEB_SEARCH_API_KEY is not set")
+	}
+
+	var response struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	searchURL := fmt.Sprintf("https://api.search.brave.com/res/v1/web/search?q=%s&count=%d", url.QueryEscape(query), count)
+	if err := getJSON(searchURL, map[string]string{"X-Subscription-Token": p.APIKey}, &response); err != nil {
+		return nil, fmt.Errorf("Brave search failed: %w", err)
+	}
+
+	var results []SearchResult
+	for _, r := range response.Web.Results {
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: HTMLToText(r.Description)})
+	}
+	return results, nil
+}
+
+// StubSearchProvider returns fixed results for every query, for development
+// and tests without a search service
+type StubSearchProvider struct {
+	Results []SearchResult
+}
+
+// Search implements SearchProvider
+func (p *StubSearchProvider) Search(query string, count int) ([]SearchResult, error) {
+	if len(p.Results) > count {
+		return p.Results[:count], nil
+	}
+	return p.Results, nil
+}
+
+// getJSON sends a GET request with extra headers and decodes the JSON response
+func getJSON(url string, headers map[string]string, response interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := searchClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-200 status: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebResultCitationsBoundPages(t *testing.T) {
+	allowTestPrivateAddresses(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/huge":
+			fmt.Fprint(w, strings.Repeat("x", maxWebResultSize+1))
+		default:
+			fmt.Fprint(w, strings.Repeat("many words in a long page ", 20000))
+		}
+	}))
+	defer server.Close()
+
+	citations := webResultCitations([]SearchResult{
+		{Title: "Huge", URL: server.URL + "/huge", Snippet: "snippet"},
+		{Title: "Long", URL: server.URL + "/long"},
+	})
+
+	counts := make(map[string]int)
+	for _, c := range citations {
+		counts[c.FileName]++
+		if c.FileName == "Huge" && c.Content != "snippet" {
+			t.Errorf("a page over the size limit gave %.40q, want its snippet", c.Content)
+		}
+	}
+	if counts["Huge"] != 1 {
+		t.Errorf("page over the size limit gave %d citations, want 1", counts["Huge"])
+	}
+	if counts["Long"] != maxWebResultChunks {
+		t.Errorf("long page gave %d citations, want %d", counts["Long"], maxWebResultChunks)
+	}
+}